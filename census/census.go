@@ -0,0 +1,139 @@
+package census
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/etclabscore/dp2p/geoip"
+)
+
+// Entry is what is known about one node of the network.
+type Entry struct {
+	Client       string      // name from the devp2p hello, empty if unknown
+	Caps         []string    // capabilities from the hello, e.g. eth/63
+	NetworkID    uint64      // network id from the eth status, 0 if unknown
+	Probed       bool        // whether its RLPx endpoint was probed
+	TooManyPeers bool        // whether the last probe was turned away with too many peers
+	Geo          *geoip.Info // location and network of its address, nil if unknown
+}
+
+// Count is a value and the number of nodes with it.
+type Count struct {
+	Value string
+	Nodes int
+}
+
+// Tally counts the nodes per value.
+type Tally map[string]int
+
+// Sorted returns the counts, most nodes first.
+func (t Tally) Sorted() []Count {
+	counts := make([]Count, 0, len(t))
+	for v, n := range t {
+		counts = append(counts, Count{v, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Nodes != counts[j].Nodes {
+			return counts[i].Nodes > counts[j].Nodes
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	return counts
+}
+
+// Census tallies the entries added to it. The client tallies count the nodes
+// with a known client name, the capability tally those with known capabilities
+// and the network tally those with a known status.
+type Census struct {
+	Nodes        int // all nodes added
+	Identified   int // nodes with a client name
+	Probed       int // nodes whose RLPx endpoint was probed
+	TooManyPeers int // probed nodes turned away with too many peers
+	Located      int // nodes with a known location or network
+	Hosted       int // located nodes listed as hosting providers
+
+	Implementations Tally // e.g. Geth
+	Versions        Tally // implementation and version, e.g. Geth v1.9.0
+	OS              Tally // operating system and architecture, e.g. linux-amd64
+	Runtimes        Tally // e.g. go1.12.6
+	Caps            Tally // capability sets, e.g. eth/62,eth/63
+	Networks        Tally // network ids
+	Countries       Tally // country codes of the located nodes
+	ASNs            Tally // autonomous systems of the located nodes, e.g. AS16509 Amazon.com, Inc.
+
+	// The probed nodes of each implementation, and the ones among them
+	// turned away with too many peers.
+	ImplProbed       Tally
+	ImplTooManyPeers Tally
+}
+
+// New creates an empty census.
+func New() *Census {
+	return &Census{
+		Implementations:  make(Tally),
+		Versions:         make(Tally),
+		OS:               make(Tally),
+		Runtimes:         make(Tally),
+		Caps:             make(Tally),
+		Networks:         make(Tally),
+		Countries:        make(Tally),
+		ASNs:             make(Tally),
+		ImplProbed:       make(Tally),
+		ImplTooManyPeers: make(Tally),
+	}
+}
+
+// Unknown stands for the parts of a client name that couldn't be made out,
+// and for the implementation of probed nodes that sent none.
+const Unknown = "unknown"
+
+func orUnknown(s string) string {
+	if s == "" {
+		return Unknown
+	}
+	return s
+}
+
+// Add counts e.
+func (c *Census) Add(e *Entry) {
+	c.Nodes++
+	impl := ""
+	if e.Client != "" {
+		c.Identified++
+		cl := ParseClient(e.Client)
+		impl = orUnknown(cl.Impl)
+		c.Implementations[impl]++
+		c.Versions[strings.TrimSpace(impl+" "+cl.Version)]++
+		platform := cl.OS
+		if cl.Arch != "" {
+			platform = strings.TrimPrefix(platform+"-"+cl.Arch, "-")
+		}
+		c.OS[orUnknown(platform)]++
+		c.Runtimes[orUnknown(cl.Runtime)]++
+	}
+	if len(e.Caps) > 0 {
+		caps := append([]string(nil), e.Caps...)
+		sort.Strings(caps)
+		c.Caps[strings.Join(caps, ",")]++
+	}
+	if e.NetworkID != 0 {
+		c.Networks[strconv.FormatUint(e.NetworkID, 10)]++
+	}
+	if e.Geo != nil {
+		c.Located++
+		c.Countries[orUnknown(e.Geo.Country)]++
+		c.ASNs[orUnknown(e.Geo.AS())]++
+		if e.Geo.Hosting {
+			c.Hosted++
+		}
+	}
+	if e.Probed {
+		c.Probed++
+		c.ImplProbed[orUnknown(impl)]++
+		if e.TooManyPeers {
+			c.TooManyPeers++
+			c.ImplTooManyPeers[orUnknown(impl)]++
+		}
+	}
+}
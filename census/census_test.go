@@ -0,0 +1,55 @@
+package census
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/etclabscore/dp2p/geoip"
+)
+
+func TestParseClient(t *testing.T) {
+	tests := []Client{
+		{Name: "Geth/v1.8.23-stable-c9427004/linux-amd64/go1.11.5", Impl: "Geth", Version: "v1.8.23", Tag: "stable", OS: "linux", Arch: "amd64", Runtime: "go1.11.5"},
+		{Name: "Geth/my-node/v1.9.0-unstable/windows-386/go1.12.6", Impl: "Geth", Identity: "my-node", Version: "v1.9.0", Tag: "unstable", OS: "windows", Arch: "386", Runtime: "go1.12.6"},
+		{Name: "Parity-Ethereum/v2.5.1-stable-adabd81-20190514/x86_64-linux-gnu/rustc1.34.2", Impl: "Parity-Ethereum", Version: "v2.5.1", Tag: "stable", OS: "linux", Arch: "amd64", Runtime: "rustc1.34.2"},
+		{Name: "besu/v1.3.5/linux-x86_64/oracle_openjdk-java-11", Impl: "besu", Version: "v1.3.5", OS: "linux", Arch: "amd64", Runtime: "oracle_openjdk-java-11"},
+		{Name: "Nethermind/v1.4.5-0-a37f5c6/X64-Linux/Core3.1.0", Impl: "Nethermind", Version: "v1.4.5", OS: "linux", Arch: "amd64", Runtime: "Core3.1.0"},
+		{Name: "multi-geth/v1.9.2-stable-f2f4d0a3/darwin-amd64/go1.12.7", Impl: "multi-geth", Version: "v1.9.2", Tag: "stable", OS: "darwin", Arch: "amd64", Runtime: "go1.12.7"},
+		{Name: "dp2p", Impl: "dp2p"},
+	}
+	for _, want := range tests {
+		if got := ParseClient(want.Name); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseClient(%q):\ngot  %+v\nwant %+v", want.Name, got, want)
+		}
+	}
+}
+
+func TestCensus(t *testing.T) {
+	c := New()
+	c.Add(&Entry{Client: "Geth/v1.9.0-stable/linux-amd64/go1.12.6", Caps: []string{"eth/63", "eth/62"}, NetworkID: 1, Probed: true})
+	c.Add(&Entry{Client: "Geth/v1.9.0-stable/linux-amd64/go1.12.6", Caps: []string{"eth/62", "eth/63"}, NetworkID: 1, Probed: true, TooManyPeers: true})
+	c.Add(&Entry{Client: "Parity-Ethereum/v2.5.1-stable/x86_64-linux-gnu/rustc1.34.2", Caps: []string{"eth/63"}, NetworkID: 61, Probed: true, TooManyPeers: true})
+	c.Add(&Entry{Probed: true, Geo: &geoip.Info{Country: "DE", ASN: 16509, Org: "Amazon.com, Inc.", Hosting: true}})
+	c.Add(&Entry{Geo: &geoip.Info{}})
+
+	if c.Nodes != 5 || c.Identified != 3 || c.Probed != 4 || c.TooManyPeers != 2 {
+		t.Errorf("got nodes %d identified %d probed %d too many peers %d", c.Nodes, c.Identified, c.Probed, c.TooManyPeers)
+	}
+	wantImpl := []Count{{"Geth", 2}, {"Parity-Ethereum", 1}}
+	if got := c.Implementations.Sorted(); !reflect.DeepEqual(got, wantImpl) {
+		t.Errorf("implementations %v, want %v", got, wantImpl)
+	}
+	wantCaps := []Count{{"eth/62,eth/63", 2}, {"eth/63", 1}}
+	if got := c.Caps.Sorted(); !reflect.DeepEqual(got, wantCaps) {
+		t.Errorf("caps %v, want %v", got, wantCaps)
+	}
+	if c.Versions["Geth v1.9.0"] != 2 || c.OS["linux-amd64"] != 3 || c.Networks["61"] != 1 {
+		t.Errorf("versions %v os %v networks %v", c.Versions, c.OS, c.Networks)
+	}
+	if c.Located != 2 || c.Hosted != 1 || c.Countries["DE"] != 1 || c.ASNs["AS16509 Amazon.com, Inc."] != 1 || c.ASNs[Unknown] != 1 {
+		t.Errorf("located %d hosted %d countries %v asns %v", c.Located, c.Hosted, c.Countries, c.ASNs)
+	}
+	if c.ImplProbed["Geth"] != 2 || c.ImplTooManyPeers["Geth"] != 1 || c.ImplProbed[Unknown] != 1 {
+		t.Errorf("probed %v too many peers %v", c.ImplProbed, c.ImplTooManyPeers)
+	}
+}
@@ -0,0 +1,110 @@
+// Package census tallies the clients of a network: implementations, versions,
+// operating systems, runtimes, capabilities and networks, from the devp2p
+// hello and eth status of its nodes.
+package census
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Client is a client name, as sent in the devp2p hello, taken apart. Names
+// are slash separated, e.g.
+//
+//	Geth/v1.8.23-stable-c9427004/linux-amd64/go1.11.5
+//	Geth/my-node/v1.9.0-stable/linux-amd64/go1.12.6
+//	Parity-Ethereum/v2.5.1-stable-adabd81-20190514/x86_64-linux-gnu/rustc1.34.2
+//	besu/v1.3.5/linux-x86_64/oracle_openjdk-java-11
+//
+// Parts that can't be made out are left empty.
+type Client struct {
+	Name     string // the whole name
+	Impl     string // implementation, e.g. Geth
+	Identity string // optional node name set by the operator
+	Version  string // e.g. v1.8.23
+	Tag      string // e.g. stable or unstable
+	OS       string // e.g. linux
+	Arch     string // e.g. amd64
+	Runtime  string // Go or other compiler version, e.g. go1.11.5 or rustc1.34.2
+}
+
+var versionRE = regexp.MustCompile(`^v?(\d+(?:\.\d+)+)(?:-([a-zA-Z]+))?`)
+
+var oses = map[string]string{
+	"linux":   "linux",
+	"windows": "windows",
+	"darwin":  "darwin",
+	"macos":   "darwin",
+	"osx":     "darwin",
+	"freebsd": "freebsd",
+	"openbsd": "openbsd",
+	"netbsd":  "netbsd",
+	"android": "android",
+}
+
+var arches = map[string]string{
+	"amd64":   "amd64",
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"386":     "386",
+	"i686":    "386",
+	"x86":     "386",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+	"arm":     "arm",
+	"armv7":   "arm",
+	"armv7l":  "arm",
+}
+
+// ParseClient takes a client name apart.
+func ParseClient(name string) Client {
+	c := Client{Name: name}
+	parts := strings.Split(name, "/")
+	c.Impl = parts[0]
+	parts = parts[1:]
+
+	// The version comes first, after an identity if there is one.
+	for i, p := range parts {
+		if m := versionRE.FindStringSubmatch(p); m != nil {
+			c.Version = "v" + m[1]
+			c.Tag = m[2]
+			if i > 0 {
+				c.Identity = strings.Join(parts[:i], "/")
+			}
+			parts = parts[i+1:]
+			break
+		}
+	}
+	for _, p := range parts {
+		if c.OS == "" {
+			c.OS, c.Arch = parsePlatform(p)
+			if c.OS != "" || c.Arch != "" {
+				continue
+			}
+		}
+		if c.Runtime == "" && isRuntime(p) {
+			c.Runtime = p
+		}
+	}
+	return c
+}
+
+// parsePlatform finds the OS and architecture in a platform part like
+// linux-amd64 or x86_64-linux-gnu.
+func parsePlatform(s string) (os, arch string) {
+	for _, f := range strings.Split(strings.ToLower(s), "-") {
+		if v, ok := oses[f]; ok && os == "" {
+			os = v
+		}
+		if v, ok := arches[f]; ok && arch == "" {
+			arch = v
+		}
+	}
+	return os, arch
+}
+
+// isRuntime reports whether s looks like a compiler or runtime version,
+// a name followed by a version number, like go1.12.6 or oracle_openjdk-java-11.
+func isRuntime(s string) bool {
+	return strings.IndexAny(s, "0123456789") > 0
+}
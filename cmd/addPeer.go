@@ -16,159 +16,111 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/ethereum/go-ethereum/core"
-	"github.com/ethereum/go-ethereum/params"
 	"log"
-	"os"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/eth"
-	elog "github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/spf13/cobra"
 )
 
-var spInProg bool
-
 // addPeerCmd represents the addPeer command
 var addPeerCmd = &cobra.Command{
 	Aliases: []string{"addPeer"},
-	Use:     "addpeer <enode>",
-	Short:   "Add an ethereum enode as a peer",
+	Use:     "addpeer <enode...>",
+	Short:   "Add ethereum enodes as peers",
 	Long: `
-    Spins up a memory-backed p2p server and attempts to make a very basic connection with an enode.
+    Spins up a memory-backed p2p server and attempts to make a very basic connection with each enode.
+    The result is classified (see the README for the exit codes).
+
+    Given many enodes, they share one server: at most --maxpending are attempted at once, and no more than
+    --maxpeers are connected. Each gets its own result line and --timeout, starting when it is dialed.
+    The command succeeds if all of them do.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		en := mustEnodeArg(args)
-
-		pEventCh := make(chan *p2p.PeerEvent)
-		resCh := make(chan int)
-		quitCh := make(chan bool)
-
-		nodekey, _ := crypto.GenerateKey()
-		c := p2p.Config{
-			PrivateKey:      nodekey,
-			MaxPeers:        25,
-			MaxPendingPeers: 50,
-			NoDiscovery:     true,
-			Name:            "dp2p",
-			Protocols: []p2p.Protocol{p2p.Protocol{
-				Name:    eth.ProtocolName,
-				Version: eth.ProtocolVersions[0], // just eth/63 for now, no immediate need for backwards compat
-				Length:  eth.ProtocolLengths[0],
-				Run: func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
-					log.Println(peer.String())
-					log.Println(spew.Sdump(peer.Info()))
-
-					if statusProto {
-
-						log.Println("attempting status proto exchange")
-
-						// Send out own handshake in a new thread
-						errc := make(chan error, 2)
-						spInProg = true
-						defer func() { spInProg = false }()
-						var status statusData
-						go func() {
-							errc <- p2p.Send(ws, eth.StatusMsg, &statusData{
-								ProtocolVersion: uint32(eth.ProtocolVersions[0]),
-								NetworkId:       1,
-								TD:              core.DefaultGenesisBlock().Difficulty,
-								CurrentBlock:    params.MainnetGenesisHash,
-								GenesisBlock:    params.MainnetGenesisHash,
-							})
-						}()
-						go func() {
-							errc <- readStatus(ws, &status)
-						}()
-						timeout := time.NewTimer(time.Second * 2)
-						defer timeout.Stop()
-						for i := 0; i < 2; i++ {
-							select {
-							case err := <-errc:
-								if err != nil {
-									return err
-								}
-							case <-timeout.C:
-								return p2p.DiscReadTimeout
-							}
-						}
-						log.Println(spew.Sdump(status))
-
-					} else {
-						log.Println("status proto exchange not enabled")
-					}
+		nodes := mustEnodeArgs(args)
+		spec := mustChainSpec()
+
+		// Protocols report their result here before returning, without blocking.
+		resCh := make(chan peerResult, 2*len(nodes))
+		// With many targets, lines about a peer say which one.
+		prefix := func(peer *p2p.Peer) string {
+			if len(nodes) == 1 {
+				return ""
+			}
+			return "enode=" + peer.ID().String() + " "
+		}
+
+		// ethRun runs the eth protocol at the given version. The server only runs
+		// the highest version both sides have in common.
+		ethRun := func(version uint) func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+			return func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+				log.Println(peer.String())
+				log.Println(spew.Sdump(peer.Info()))
+
+				if !statusProto {
+					log.Println("status proto exchange not enabled")
 					peer.Disconnect(p2p.DiscQuitting)
-					resCh <- 0
+					resCh <- peerResult{peer.ID(), nil}
 					return nil
-				},
-			}},
-			ListenAddr:      listenAddr,
-			Logger:          elog.Root(),
-			NodeDatabase:    "", // empty for memory
-			EnableMsgEvents: true,
-		}
-		serv := p2p.Server{Config: c}
-		pSub := serv.SubscribeEvents(pEventCh)
-		if err := serv.Start(); err != nil {
-			log.Println("failed to start p2p server", err)
-			os.Exit(1)
-		}
-		go func() {
-			// We can't listen for dial failures w/o too much hacking.
-			// So we just set a timeout to wait for a positive connection.
-			t := time.NewTicker(time.Duration(int32(connectTimeout)) * time.Second)
-			defer serv.Stop()
-			for {
-				select {
-				case ev := <-pEventCh:
-					log.Println(ev)
-					if ev.Type == p2p.PeerEventTypeAdd {
-
-					}
-					if ev.Type == p2p.PeerEventTypeDrop {
-						log.Println("peer dropped")
-						resCh <- 1
-						return
-					}
-				case err := <-pSub.Err():
-					log.Println("peer event sub error", err)
-					resCh <- 1
-					return
-				case <-t.C:
-					log.Println("ticker expired", time.Now().Format(time.RFC3339))
-					resCh <- 1
-					return
-				case <-quitCh:
-					return
 				}
-			}
-		}()
-		go serv.AddPeer(en)
-		for {
-			select {
-			case c := <-resCh:
-				// log.Println("got res code", c)
-				if c == 0 {
-					fmt.Println("OK")
-				} else {
-					fmt.Println("FAIL")
+
+				log.Println("attempting status proto exchange", "eth", version)
+
+				theirs, err := exchangeStatus(ws, spec, version)
+				if err != nil {
+					resCh <- peerResult{peer.ID(), err}
+					return err
+				}
+				log.Println(spew.Sdump(theirs))
+				if status, ok := theirs.(*statusData64); ok {
+					fmt.Println(prefix(peer) + spec.describeForkID(status.ForkID, forkHead))
+				}
+				if err := checkStatus(spec, version, theirs); err != nil {
+					resCh <- peerResult{peer.ID(), err}
+					return p2p.DiscUselessPeer
 				}
-				//quitCh <- true
-				for(spInProg) {}
-				os.Exit(c)
+
+				peer.Disconnect(p2p.DiscQuitting)
+				resCh <- peerResult{peer.ID(), nil}
+				return nil
 			}
 		}
+
+		results := probeAll(nodes, ethProtocols(ethRun), resCh, time.Duration(int32(connectTimeout))*time.Second)
+		if len(nodes) == 1 {
+			err := results[nodes[0].ID()]
+			if err != nil {
+				log.Println(err)
+			}
+			classify(err).exit()
+		}
+
+		var failed int
+		for _, n := range nodes {
+			o := classify(results[n.ID()])
+			if o.Kind != outcomeSuccess {
+				failed++
+			}
+			printResult(n, o)
+		}
+		if failed > 0 {
+			(&outcome{Kind: outcomeFailure, Detail: fmt.Sprintf("%d of %d enodes failed", failed, len(nodes))}).exit()
+		}
+		succeeded(fmt.Sprintf("%d enodes", len(nodes))).exit()
 	},
 }
 
 func init() {
-	addPeerCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 30, "time in seconds to wait for node to dial a connection")
+	addPeerCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 30, "time in seconds to wait for each node to dial a connection")
 	addPeerCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
 	addPeerCmd.PersistentFlags().BoolVarP(&statusProto, "statusproto", "s", true,"if adding peer succeeds, attempt to exchange status messages")
+	addPeerCmd.PersistentFlags().IntVar(&maxPeers, "maxpeers", 25, "maximum number of enodes connected at once")
+	addPeerCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 50, "maximum number of enodes attempted at once")
+	addPeerCmd.PersistentFlags().BoolVar(&probeRetry, "retry", false, "keep redialing until the timeout when a connection attempt fails")
+	addPeerCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	addPeerCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
 
 	rootCmd.AddCommand(addPeerCmd)
 
@@ -0,0 +1,223 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/etclabscore/dp2p/tableaudit"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditPing     bool
+	auditList     bool
+	auditASNShare float64
+)
+
+// reconstructTable asks n for the entries of each bucket of its table, with
+// a findnode request targeting an ID in the bucket, and with auditPing pings
+// them, up to maxPendingPeers at once. Our own node, self, which n knows
+// from the ping, isn't an entry.
+func reconstructTable(u *discover.Dual, self enode.ID, n *enode.Node) ([]*enode.Node, []tableaudit.Entry, error) {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	if err := <-u.SendPing(n.ID(), addr, nil); err != nil {
+		return nil, nil, err
+	}
+	var (
+		nodes   []*enode.Node
+		seen    = make(map[enode.ID]bool)
+		lastErr error
+	)
+	for b := tableaudit.NumBuckets - 1; b >= 0; b-- {
+		target, err := tableaudit.TargetKey(n.ID(), b)
+		if err != nil {
+			return nil, nil, err
+		}
+		ns, err := u.Findnode(n.ID(), addr, target)
+		// The closest entries are returned whatever their bucket, only a
+		// node with an empty table doesn't reply.
+		if err != nil && !discover.IsTimeout(err) {
+			lastErr = err
+		}
+		for _, nb := range ns {
+			if !seen[nb.ID()] && nb.ID() != n.ID() && nb.ID() != self {
+				seen[nb.ID()] = true
+				nodes = append(nodes, &nb.Node)
+			}
+		}
+	}
+	if len(nodes) == 0 && lastErr != nil {
+		return nil, nil, lastErr
+	}
+	recordNeighbors(n, nodes)
+
+	entries := make([]tableaudit.Entry, len(nodes))
+	for i, nb := range nodes {
+		entries[i] = tableaudit.Entry{ID: nb.ID(), IP: nb.IP(), Bucket: tableaudit.BucketOf(n.ID(), nb.ID())}
+		if info := geoInfo(nb.IP()); info != nil {
+			entries[i].AS = info.AS()
+		}
+	}
+	if auditPing {
+		for i, p := range pingNeighbors(u, nodes) {
+			entries[i].Pinged, entries[i].Dead = true, p.err != nil
+		}
+	}
+	return nodes, entries, nil
+}
+
+// printAudit prints the reconstructed table of n and its assessment.
+func printAudit(n *enode.Node, nodes []*enode.Node, entries []tableaudit.Entry, r *tableaudit.Report) {
+	fmt.Println("table", n.ID().TerminalString(), addrString(n), "entries", r.Entries, "subnets", r.Subnets, "asns", r.ASNs, "dead", fmt.Sprintf("%d/%d", r.Dead, r.Pinged))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if auditList {
+		fmt.Fprintln(tw, "bucket\tnode\taddr\tping\tlocation")
+		for i, nb := range nodes {
+			ping, location := "-", "-"
+			if entries[i].Pinged {
+				ping = "ok"
+				if entries[i].Dead {
+					ping = "dead"
+				}
+			}
+			if info := geoInfo(nb.IP()); info != nil && info.String() != "" {
+				location = info.String()
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", entries[i].Bucket, nb.ID().TerminalString(), addrString(nb), ping, location)
+		}
+		fmt.Fprintln(tw)
+	}
+	dead := make([]int, tableaudit.NumBuckets)
+	for _, e := range entries {
+		if e.Dead {
+			dead[e.Bucket]++
+		}
+	}
+	fmt.Fprintln(tw, "bucket\tdistance\tentries\tdead")
+	for b := tableaudit.NumBuckets - 1; b >= 0; b-- {
+		dist := fmt.Sprint(tableaudit.MinDistance + 1 + b)
+		if b == 0 {
+			dist = "<=" + dist
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\n", b, dist, r.Buckets[b], dead[b])
+	}
+	tw.Flush()
+	for _, c := range r.Clusters {
+		fmt.Println("cluster", c)
+	}
+	asn := "-"
+	if r.ASNHHI >= 0 {
+		asn = fmt.Sprintf("%.2f", r.ASNHHI)
+	}
+	deadShare := "-"
+	if r.Pinged > 0 {
+		deadShare = percent(r.Dead, r.Pinged)
+	}
+	fmt.Printf("shared-subnet %.1f%% asn-hhi %s shared-ip %.1f%% dead %s close-ids %.1f%% (network ~%d)\n", 100*r.SubnetShare, asn, 100*r.SharedIPShare, deadShare, 100*r.CloseIDShare, r.NetworkSize)
+	fmt.Println("risk", r.Score, tableaudit.Level(r.Score))
+}
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit <enode...>",
+	Short: "Reconstruct enodes' discovery tables and assess their diversity and eclipse risk",
+	Long: `
+    Reconstructs the discovery table of each enode with one findnode request per bucket, targeting a key ground
+    to fall into that bucket, so the bucket's entries come first in the reply. With --ping (default) the entries
+    are pinged, --maxpending at a time, to find the dead ones.
+
+    The table is then assessed as the geth table would be limited (at most 2 entries of a /24 per bucket, 10 in
+    the table) and for concentration: the share of entries sharing a /24, the Herfindahl-Hirschman index of their
+    autonomous systems (with --geoip), the share of entries sharing an address with another node ID, and the
+    share of dead entries. These make up a risk score from 0 to 100 (low below 15, high from 35).
+
+    Node IDs are checked, too: the farthest bucket that isn't full gives the network size, and each bucket
+    closer should hold half the entries of the one before. The share of entries beyond that (close IDs,
+    likely ground to get near the enode) is added to the score.
+
+    The clusters standing out are listed: subnets over the limits or with more than 2 entries, addresses with
+    several node IDs, autonomous systems with more than --asn-share of the entries and crowded buckets (id).
+    An enode returning no entries at all fails with a timeout: its risk is unknown.
+
+    A findnode reply holds up to 16 nodes, a full bucket, so every bucket is seen whole; replacement lists aren't.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		nodes := mustEnodeArgs(args)
+		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		u := mustUdpConfig(discover.Config{PrivateKey: key})
+		self := enode.PubkeyToIDV4(&key.PublicKey)
+
+		var failed, high int
+		for i, n := range nodes {
+			if i > 0 {
+				fmt.Println()
+			}
+			table, entries, err := reconstructTable(u, self, n)
+			if err == nil && len(entries) == 0 {
+				// Every findnode timed out: the risk is unknown, not low.
+				err = &outcome{Kind: outcomeTimeout, Detail: "no table entries returned"}
+			}
+			if err != nil {
+				log.Println(n.ID().TerminalString(), err)
+				if len(nodes) == 1 {
+					classify(err).exit()
+				}
+				failed++
+				printResult(n, classify(err))
+				continue
+			}
+			r := tableaudit.Analyze(entries, auditASNShare)
+			printAudit(n, table, entries, r)
+			if tableaudit.Level(r.Score) == "high" {
+				high++
+			}
+			o := succeeded(fmt.Sprintf("risk %d %s, %d entries", r.Score, tableaudit.Level(r.Score), r.Entries))
+			if len(nodes) == 1 {
+				o.exit()
+			}
+			printResult(n, o)
+		}
+		if failed > 0 {
+			(&outcome{Kind: outcomeFailure, Detail: fmt.Sprintf("%d of %d enodes not audited", failed, len(nodes))}).exit()
+		}
+		succeeded(fmt.Sprintf("%d enodes, %d at high risk", len(nodes), high)).exit()
+	},
+}
+
+func init() {
+	auditCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	auditCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
+	auditCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 500, "milliseconds for devp2p response timeout allowance")
+	auditCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 16, "maximum number of entries pinged at once")
+	auditCmd.PersistentFlags().BoolVar(&auditPing, "ping", true, "ping the entries to find the dead ones")
+	auditCmd.PersistentFlags().BoolVar(&auditList, "list", false, "list the entries of the table")
+	auditCmd.PersistentFlags().Float64Var(&auditASNShare, "asn-share", 0.25, "share of the entries above which an autonomous system is reported as a cluster (with --geoip)")
+	rootCmd.AddCommand(auditCmd)
+}
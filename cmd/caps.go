@@ -0,0 +1,206 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/les"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/spf13/cobra"
+)
+
+var (
+	capSpecs   []string
+	capsMatrix bool
+)
+
+// knownCapLengths holds the number of message codes of capabilities we know
+// about, so they can be given as just name/version.
+var knownCapLengths = map[string]uint64{
+	fmt.Sprintf("%s/%d", eth.ProtocolName, 62):                              eth.ProtocolLengths[1],
+	fmt.Sprintf("%s/%d", eth.ProtocolName, 63):                              eth.ProtocolLengths[0],
+	fmt.Sprintf("%s/%d", eth.ProtocolName, eth64):                           eth.ProtocolLengths[0],
+	fmt.Sprintf("%s/%d", lesProtocolName, 1):                                les.ProtocolLengths[1],
+	fmt.Sprintf("%s/%d", lesProtocolName, 2):                                les.ProtocolLengths[2],
+	fmt.Sprintf("%s/%d", whisperv6.ProtocolName, whisperv6.ProtocolVersion): whisperv6.NumberOfMessageCodes,
+	"bzz/8":  1, // swarm handshake protocol
+	"hive/8": 2, // swarm peer discovery protocol
+}
+
+// parseCap parses a capability given as name/version, or name/version/length
+// for capabilities we don't know.
+func parseCap(s string) (p2p.Cap, uint64, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return p2p.Cap{}, 0, fmt.Errorf("want name/version[/length], got %q", s)
+	}
+	version, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return p2p.Cap{}, 0, fmt.Errorf("bad version in %q: %v", s, err)
+	}
+	cap := p2p.Cap{Name: parts[0], Version: uint(version)}
+	if len(parts) == 3 {
+		length, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil || length == 0 {
+			return p2p.Cap{}, 0, fmt.Errorf("bad length in %q", s)
+		}
+		return cap, length, nil
+	}
+	length, ok := knownCapLengths[cap.String()]
+	if !ok {
+		return p2p.Cap{}, 0, fmt.Errorf("unknown capability %q, give its length as name/version/length", s)
+	}
+	return cap, length, nil
+}
+
+func mustCapArgs() []p2p.Protocol {
+	var protocols []p2p.Protocol
+	for _, s := range capSpecs {
+		cap, length, err := parseCap(s)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		protocols = append(protocols, p2p.Protocol{Name: cap.Name, Version: cap.Version, Length: length})
+	}
+	if len(protocols) == 0 {
+		log.Println("need at least one capability")
+		os.Exit(1)
+	}
+	return protocols
+}
+
+// capsReport is what we learn about a remote's capabilities from one connection.
+type capsReport struct {
+	Name   string
+	Remote []p2p.Cap
+	Shared []sharedCap
+}
+
+// probeCaps connects to en advertising the given protocols. The protocols don't
+// speak their wire protocols, they only report the negotiated result.
+func probeCaps(en *enode.Node, protocols []p2p.Protocol) (*capsReport, error) {
+	var (
+		resCh  = make(chan peerResult, len(protocols))
+		report = make(chan *capsReport, len(protocols))
+	)
+	protocols = append([]p2p.Protocol{}, protocols...)
+	for i := range protocols {
+		protocols[i].Run = func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+			report <- &capsReport{Name: peer.Name(), Remote: peer.Caps()}
+			resCh <- peerResult{peer.ID(), nil}
+			peer.Disconnect(p2p.DiscQuitting)
+			return nil
+		}
+	}
+	if err := probe(en, protocols, resCh, time.Duration(int32(connectTimeout))*time.Second); err != nil {
+		return nil, err
+	}
+	r := <-report
+	r.Shared = matchCaps(protocols, r.Remote)
+	return r, nil
+}
+
+// capsCmd represents the caps command
+var capsCmd = &cobra.Command{
+	Use:   "caps <enode>",
+	Short: "Advertise a set of capabilities to an enode and report the negotiated protocols",
+	Long: `
+    Spins up a memory-backed p2p server advertising the given capabilities on one connection
+    and reports the remote's capabilities, the shared ones, their negotiated versions and message code offsets.
+
+    Capabilities are given as name/version (for eth/62, eth/63, eth/64, les/1, les/2, shh/6, bzz/8, hive/8)
+    or name/version/length for anything else.
+
+    With --matrix, a separate connection is made for each single capability, showing exactly which
+    protocol versions the remote supports.
+
+    The result is classified as for addpeer. With --matrix, the command succeeds if any capability is supported.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		en := mustEnodeArg(args)
+		protocols := mustCapArgs()
+
+		if !capsMatrix {
+			r, err := probeCaps(en, protocols)
+			if err != nil {
+				log.Println(err)
+				classify(err).exit()
+			}
+			fmt.Println("name", r.Name)
+			var remote []string
+			for _, c := range r.Remote {
+				remote = append(remote, c.String())
+			}
+			fmt.Println("caps", strings.Join(remote, ","))
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "shared\tversion\toffset\tlength")
+			for _, c := range r.Shared {
+				fmt.Fprintf(w, "%s\t%d\t%#x\t%d\n", c.Name, c.Version, c.Offset, c.Length)
+			}
+			w.Flush()
+			succeeded("").exit()
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "cap\tsupported\tdetail")
+		var (
+			supported int
+			lastErr   error
+		)
+		for _, proto := range protocols {
+			r, err := probeCaps(en, []p2p.Protocol{proto})
+			// A protocol only runs if the capability is shared, otherwise
+			// the connection is dropped as useless.
+			switch o := classify(err); {
+			case o.Kind == outcomeDisconnected && o.Reason == p2p.DiscUselessPeer:
+				fmt.Fprintf(w, "%s/%d\tno\t%s\n", proto.Name, proto.Version, "not negotiated")
+			case err != nil:
+				lastErr = err
+				fmt.Fprintf(w, "%s/%d\tno\t%v\n", proto.Name, proto.Version, err)
+			default:
+				supported++
+				fmt.Fprintf(w, "%s/%d\tyes\toffset %#x\n", proto.Name, proto.Version, r.Shared[0].Offset)
+			}
+		}
+		w.Flush()
+		if supported == 0 && lastErr != nil {
+			classify(lastErr).exit()
+		}
+		if supported == 0 {
+			(&outcome{Kind: outcomeFailure, Detail: "no capability negotiated"}).exit()
+		}
+		succeeded(fmt.Sprintf("%d of %d capabilities supported", supported, len(protocols))).exit()
+	},
+}
+
+func init() {
+	capsCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 30, "time in seconds to wait for node to dial a connection (per connection)")
+	capsCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	capsCmd.PersistentFlags().StringSliceVar(&capSpecs, "cap", []string{"eth/62", "eth/63", "eth/64", "les/1", "les/2", "shh/6"}, "capability to advertise, as name/version or name/version/length (repeatable)")
+	capsCmd.PersistentFlags().BoolVarP(&capsMatrix, "matrix", "m", false, "connect once per single capability")
+	rootCmd.AddCommand(capsCmd)
+}
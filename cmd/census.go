@@ -0,0 +1,260 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/census"
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	censusSeen  time.Duration
+	censusProbe bool
+	censusTop   int
+	censusJSON  bool
+)
+
+// udpProbes are the probe kinds of the discovery endpoint, all others
+// probe the RLPx endpoint.
+var udpProbes = map[string]bool{"ping": true, "enr": true, "findnode": true}
+
+// lastTCPProbes returns the last probe of the RLPx endpoint of each node since t.
+func lastTCPProbes(db *crawldb.DB, since time.Time) (map[enode.ID]*crawldb.Probe, error) {
+	last := make(map[enode.ID]*crawldb.Probe)
+	err := db.Probes(since, func(p *crawldb.Probe) bool {
+		if !udpProbes[p.Kind] {
+			last[p.ID] = p
+		}
+		return true
+	})
+	return last, err
+}
+
+// seenSince reports whether n answered a probe since t.
+func seenSince(n *crawldb.Node, t time.Time) bool {
+	return !n.FirstSeen.IsZero() && !n.LastSeen.Before(t)
+}
+
+// probeUnprobed checks the RLPx endpoints of the nodes seen since t whose
+// endpoint wasn't probed since, with up to workers at once.
+func probeUnprobed(db *crawldb.DB, key *ecdsa.PrivateKey, spec *chainSpec, since time.Time, workers int, timeout time.Duration) error {
+	probed, err := lastTCPProbes(db, since)
+	if err != nil {
+		return err
+	}
+	var todo []*enode.Node
+	err = db.Nodes(func(n *crawldb.Node) bool {
+		if !seenSince(n, since) || probed[n.ID] != nil {
+			return true
+		}
+		if en, err := enode.ParseV4(n.URL); err == nil && en.TCP() != 0 {
+			todo = append(todo, en)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	end, release := sessionEnd()
+	defer release()
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan *enode.Node)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				o := probeStatus(key, spec, n, timeout)
+				fmt.Println(withGeo(n.IP(), n.ID().TerminalString(), addrString(n), "status", cell(o))...)
+			}
+		}()
+	}
+loop:
+	for _, n := range todo {
+		select {
+		case jobs <- n:
+		case <-end:
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return nil
+}
+
+// takeCensus tallies the nodes seen since t.
+func takeCensus(db *crawldb.DB, since time.Time) (*census.Census, error) {
+	probes, err := lastTCPProbes(db, since)
+	if err != nil {
+		return nil, err
+	}
+	tooManyPeers := discExitBase + int(p2p.DiscTooManyPeers)
+	c := census.New()
+	err = db.Nodes(func(n *crawldb.Node) bool {
+		if !seenSince(n, since) {
+			return true
+		}
+		e := &census.Entry{Client: n.Client, Caps: n.Caps}
+		if n.Status != nil {
+			e.NetworkID = n.Status.NetworkID
+		}
+		if ep := n.Endpoint(); ep != nil {
+			e.Geo = geoInfo(ep.IP)
+		}
+		if p := probes[n.ID]; p != nil {
+			e.Probed = true
+			e.TooManyPeers = p.Exit == tooManyPeers
+		}
+		c.Add(e)
+		return true
+	})
+	return c, err
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// printTally prints the top values of t with their share of total,
+// and the rest as other. Empty tallies are left out.
+func printTally(tw *tabwriter.Writer, name string, t census.Tally, total int) {
+	if len(t) == 0 {
+		return
+	}
+	fmt.Fprintf(tw, "%s\tnodes\tshare\n", name)
+	var rest int
+	for i, c := range t.Sorted() {
+		if i < censusTop {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", c.Value, c.Nodes, percent(c.Nodes, total))
+		} else {
+			rest += c.Nodes
+		}
+	}
+	if rest > 0 {
+		fmt.Fprintf(tw, "other\t%d\t%s\n", rest, percent(rest, total))
+	}
+	fmt.Fprintln(tw)
+}
+
+// censusCmd represents the census command
+var censusCmd = &cobra.Command{
+	Use:   "census",
+	Short: "Tally the clients, versions, platforms, capabilities and networks of the crawled nodes",
+	Long: `
+    Takes a census of the nodes in the crawl database (--db, by default ./crawl.db) that answered a probe within
+    --seen: their client names, from the devp2p hello recorded by crawl --probe, hello, reach or census --probe,
+    taken apart into implementation, version, OS and architecture, and Go (or other runtime) version, and their
+    capability sets and network ids, from the eth status.
+
+    The too many peers rate is the share of the nodes whose RLPx endpoint was probed that turned us away, with
+    the last probe, because they had too many peers; in total and per implementation.
+
+    With --probe, the RLPx endpoints of the nodes not probed within --seen are checked first, --maxpending at a time.
+    With --geoip, the nodes are also tallied by the country and autonomous system of their last address.
+    Hosting providers are only known from a GeoIP2 Anonymous IP database; without one, hosting is 0.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		db := mustCrawlDB()
+		var since time.Time
+		if censusSeen > 0 {
+			since = time.Now().Add(-censusSeen)
+		}
+		if censusProbe {
+			spec := mustChainSpec()
+			key, err := crypto.GenerateKey()
+			if err == nil {
+				err = probeUnprobed(db, key, spec, since, maxPendingPeers, time.Duration(int32(connectTimeout))*time.Second)
+			}
+			if err != nil {
+				log.Println(err)
+				classify(err).exit()
+			}
+		}
+
+		c, err := takeCensus(db, since)
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		summary := fmt.Sprintf("%d nodes, %d identified", c.Nodes, c.Identified)
+		if censusJSON {
+			printJSON(c)
+			succeeded(summary).exit()
+		}
+
+		fmt.Printf("nodes %d identified %d probed %d too-many-peers %d (%s)", c.Nodes, c.Identified, c.Probed, c.TooManyPeers, percent(c.TooManyPeers, c.Probed))
+		if c.Located > 0 {
+			fmt.Printf(" located %d hosting %d (%s)", c.Located, c.Hosted, percent(c.Hosted, c.Located))
+		}
+		fmt.Print("\n\n")
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "client\tnodes\tshare\tprobed\ttoo many peers")
+		for _, impl := range c.Implementations.Sorted() {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", impl.Value, impl.Nodes, percent(impl.Nodes, c.Identified),
+				c.ImplProbed[impl.Value], percent(c.ImplTooManyPeers[impl.Value], c.ImplProbed[impl.Value]))
+		}
+		if n := c.ImplProbed[census.Unknown]; n > 0 {
+			fmt.Fprintf(tw, "-\t-\t-\t%d\t%s\n", n, percent(c.ImplTooManyPeers[census.Unknown], n))
+		}
+		fmt.Fprintln(tw)
+		printTally(tw, "version", c.Versions, c.Identified)
+		printTally(tw, "os", c.OS, c.Identified)
+		printTally(tw, "runtime", c.Runtimes, c.Identified)
+		var withCaps, withStatus int
+		for _, n := range c.Caps {
+			withCaps += n
+		}
+		for _, n := range c.Networks {
+			withStatus += n
+		}
+		printTally(tw, "caps", c.Caps, withCaps)
+		printTally(tw, "network", c.Networks, withStatus)
+		printTally(tw, "country", c.Countries, c.Located)
+		printTally(tw, "asn", c.ASNs, c.Located)
+		tw.Flush()
+		succeeded(summary).exit()
+	},
+}
+
+func init() {
+	censusCmd.PersistentFlags().DurationVar(&censusSeen, "seen", 24*time.Hour, "count the nodes that answered within this duration (0 = all)")
+	censusCmd.PersistentFlags().BoolVar(&censusProbe, "probe", false, "first check the RLPx endpoints of the nodes not probed within --seen")
+	censusCmd.PersistentFlags().IntVar(&censusTop, "top", 10, "number of values listed per table, the rest is summed up as other")
+	censusCmd.PersistentFlags().BoolVar(&censusJSON, "json", false, "print the census as JSON")
+	censusCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 16, "maximum number of nodes probed at once (with --probe)")
+	censusCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 10, "time in seconds to wait for TCP connections (with --probe)")
+	censusCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in status exchanges (with --probe) ("+chainNames()+")")
+	censusCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	rootCmd.AddCommand(censusCmd)
+}
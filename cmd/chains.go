@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/etclabscore/dp2p/forkid"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// chainSpec holds what we need to know about a chain to pass as one of its
+// nodes in an eth status handshake.
+type chainSpec struct {
+	NetworkId uint64
+	Genesis   common.Hash
+	TD        *big.Int // genesis difficulty, advertised as our total difficulty
+	Forks     []uint64 // ordered fork blocks, as used by EIP-2124
+	Bootnodes []string // default discovery bootstrap nodes
+}
+
+var chainSpecs = map[string]*chainSpec{
+	// The vendored ChainConfig stops at Petersburg and has no notion of the
+	// ECIP forks, so the mainnet and classic forks come from package forkid.
+	// A --head block number is below mainnet's forks scheduled by timestamp.
+	"mainnet": {
+		NetworkId: 1,
+		Genesis:   params.MainnetGenesisHash,
+		TD:        core.DefaultGenesisBlock().Difficulty,
+		Forks:     forkid.MainnetForks,
+		Bootnodes: params.MainnetBootnodes,
+	},
+	// Classic's bootnodes aren't vendored.
+	"classic": {
+		NetworkId: 1,
+		Genesis:   params.MainnetGenesisHash,
+		TD:        core.DefaultGenesisBlock().Difficulty,
+		Forks:     forkid.ClassicForks,
+	},
+	// Ropsten and Rinkeby are shut down, their forks stop where the vendored
+	// ChainConfig does.
+	"testnet": {
+		NetworkId: 3,
+		Genesis:   params.TestnetGenesisHash,
+		TD:        core.DefaultTestnetGenesisBlock().Difficulty,
+		Forks:     forkid.GatherForks(params.TestnetChainConfig),
+		Bootnodes: params.TestnetBootnodes,
+	},
+	"rinkeby": {
+		NetworkId: 4,
+		Genesis:   params.RinkebyGenesisHash,
+		TD:        core.DefaultRinkebyGenesisBlock().Difficulty,
+		Forks:     forkid.GatherForks(params.RinkebyChainConfig),
+		Bootnodes: params.RinkebyBootnodes,
+	},
+}
+
+func chainNames() string {
+	var names []string
+	for name := range chainSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func mustChainSpec() *chainSpec {
+	spec, ok := chainSpecs[chainName]
+	if !ok {
+		log.Println("unknown chain", chainName, "(available:", chainNames()+")")
+		os.Exit(1)
+	}
+	return spec
+}
+
+// forkID returns the fork id we advertise. Since our status claims the genesis
+// block as our head, so does our fork id.
+func (c *chainSpec) forkID() forkid.ID {
+	return forkid.NewIDFromForks(c.Forks, c.Genesis, 0)
+}
+
+// forkFilter returns a filter validating remote fork ids as seen from the
+// given local head. A zero head is taken to mean "past all known forks".
+func (c *chainSpec) forkFilter(head uint64) forkid.Filter {
+	if head == 0 && len(c.Forks) > 0 {
+		head = c.Forks[len(c.Forks)-1]
+	}
+	return forkid.NewFilterFromForks(c.Forks, c.Genesis, head)
+}
+
+// knowsForkHash reports whether hash is the fork checksum of the chain at
+// some point, before any of its forks or after one.
+func (c *chainSpec) knowsForkHash(hash [4]byte) bool {
+	heads := append([]uint64{0}, c.Forks...)
+	for _, head := range heads {
+		if forkid.NewIDFromForks(c.Forks, c.Genesis, head).Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// describeForkID renders a remote fork id together with its verdict.
+func (c *chainSpec) describeForkID(id forkid.ID, head uint64) string {
+	verdict, err := c.forkFilter(head)(id)
+	if err != nil {
+		return fmt.Sprintf("forkid=%v %v (%v)", id, verdict, err)
+	}
+	return fmt.Sprintf("forkid=%v %v", id, verdict)
+}
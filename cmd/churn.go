@@ -0,0 +1,186 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	churnFrom   string
+	churnTo     string
+	churnWindow time.Duration
+	churnGap    time.Duration
+	churnList   bool
+)
+
+// parseTime parses an RFC 3339 time, a date, or a duration before now.
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func mustTime(flag, s string) time.Time {
+	t, err := parseTime(s)
+	if err != nil {
+		log.Println("bad --"+flag, err)
+		os.Exit(1)
+	}
+	return t
+}
+
+func mustSnapshot(file string) *crawldb.Snapshot {
+	s, err := crawldb.ReadSnapshot(file)
+	if err != nil {
+		log.Println("failed to read snapshot", err)
+		os.Exit(1)
+	}
+	return s
+}
+
+func clientOrDash(s *crawldb.NodeState) string {
+	if s.Client == "" {
+		return "-"
+	}
+	return s.Client
+}
+
+// printAges prints the age distribution of v on one line.
+func printAges(v *crawldb.View) {
+	counts := crawldb.Ages(v)
+	fields := make([]string, len(counts))
+	lower := "0"
+	for i, n := range counts {
+		if i < len(crawldb.AgeBuckets) {
+			upper := shortDuration(crawldb.AgeBuckets[i])
+			fields[i] = fmt.Sprintf("%s-%s:%d", lower, upper, n)
+			lower = upper
+		} else {
+			fields[i] = fmt.Sprintf(">%s:%d", lower, n)
+		}
+	}
+	fmt.Println("age", strings.Join(fields, " "))
+}
+
+// shortDuration renders whole days as such, e.g. 7d.
+func shortDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(d.String(), "0s"), "0m")
+}
+
+// churnCmd represents the churn command
+var churnCmd = &cobra.Command{
+	Use:   "churn [<snapshot> <snapshot>]",
+	Short: "Compare two crawl snapshots, or two times in the crawl database",
+	Long: `
+    Reports the nodes that joined, left, changed IP or port, or changed client between two populations, with the
+    churn rate, the median session lifetime and the age distribution (time since first seen) of the later population.
+
+    Given two snapshot files (see 'crawl --snapshot' and 'db snapshot'), the populations are their nodes. Otherwise
+    they come from the crawl database (--db): the nodes answering a probe within --window before --from and --to.
+    Times are RFC 3339, dates (2019-07-01) or durations before now (168h).
+
+    Churn is the nodes joining and leaving over twice the mean population, also given per day. Sessions are runs of
+    answered probes in the database, ended by an unanswered probe (other than findnode and status) or no answer
+    for longer than --gap; with snapshots the lifetime of the nodes that left is used instead.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		var (
+			from, to  *crawldb.View
+			lifetimes []time.Duration
+			lifetime  string
+		)
+		switch len(args) {
+		case 2:
+			from, to = mustSnapshot(args[0]).View(), mustSnapshot(args[1]).View()
+		case 0:
+			db := mustCrawlDB()
+			fromTime, toTime := mustTime("from", churnFrom), mustTime("to", churnTo)
+			var err error
+			if from, err = db.ViewAt(fromTime, churnWindow); err == nil {
+				to, err = db.ViewAt(toTime, churnWindow)
+			}
+			if err != nil {
+				log.Println(err)
+				classify(err).exit()
+			}
+			sessions, err := db.Sessions(fromTime.Add(-churnWindow), toTime, churnGap)
+			if err != nil {
+				log.Println(err)
+				classify(err).exit()
+			}
+			for _, s := range sessions {
+				if s.Ended {
+					lifetimes = append(lifetimes, s.End.Sub(s.Start))
+				}
+			}
+			lifetime = fmt.Sprintf("median session lifetime %v (%d sessions ended, %d open)", crawldb.Median(lifetimes).Round(time.Second), len(lifetimes), len(sessions)-len(lifetimes))
+		default:
+			log.Println("need two snapshots, or none to use the crawl database")
+			os.Exit(1)
+		}
+		d := crawldb.Compare(from, to)
+		if lifetime == "" {
+			lifetimes = d.LeftLifetimes()
+			lifetime = fmt.Sprintf("median lifetime of left nodes %v (%d nodes)", crawldb.Median(lifetimes).Round(time.Second), len(lifetimes))
+		}
+
+		if churnList {
+			for _, s := range d.Joined {
+				fmt.Println("joined", s.ID.TerminalString(), s.Addr(), clientOrDash(s))
+			}
+			for _, s := range d.Left {
+				fmt.Println("left", s.ID.TerminalString(), s.Addr(), clientOrDash(s), "last seen", s.LastSeen.Format(time.RFC3339))
+			}
+			for _, c := range d.Moved {
+				fmt.Println("moved", c.ID.TerminalString(), c.From, "->", c.To)
+			}
+			for _, c := range d.Upgraded {
+				fmt.Println("client", c.ID.TerminalString(), c.From, "->", c.To)
+			}
+		}
+		fmt.Println("from", from.Time.Format(time.RFC3339), len(from.Nodes), "nodes")
+		fmt.Println("to", to.Time.Format(time.RFC3339), len(to.Nodes), "nodes")
+		fmt.Printf("joined %d left %d moved %d client %d\n", len(d.Joined), len(d.Left), len(d.Moved), len(d.Upgraded))
+		fmt.Printf("churn %.1f%% (%.1f%%/day)\n", 100*d.Churn(), 100*d.ChurnPerDay())
+		fmt.Println(lifetime)
+		printAges(to)
+		succeeded(fmt.Sprintf("%d joined, %d left", len(d.Joined), len(d.Left))).exit()
+	},
+}
+
+func init() {
+	churnCmd.PersistentFlags().StringVar(&churnFrom, "from", "168h", "start of the range in the crawl database")
+	churnCmd.PersistentFlags().StringVar(&churnTo, "to", "0s", "end of the range in the crawl database")
+	churnCmd.PersistentFlags().DurationVar(&churnWindow, "window", 24*time.Hour, "nodes answering within this long before a time make its population")
+	churnCmd.PersistentFlags().DurationVar(&churnGap, "gap", 48*time.Hour, "a node not answering for longer ends its session")
+	churnCmd.PersistentFlags().BoolVar(&churnList, "list", true, "list the nodes that joined, left, moved or changed client")
+	rootCmd.AddCommand(churnCmd)
+}
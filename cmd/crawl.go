@@ -0,0 +1,339 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	crawlLookups int
+	crawlProbe   bool
+	crawlNew     bool
+	crawlOut     string
+	crawlStale   float64
+)
+
+// crawler visits nodes, recording what it learns in the database.
+type crawler struct {
+	db      *crawldb.DB
+	u       *discover.Dual
+	key     *ecdsa.PrivateKey
+	self    enode.ID
+	spec    *chainSpec
+	timeout time.Duration
+
+	mu         sync.Mutex
+	visited    int
+	answered   int
+	discovered int
+	queued     int // left to visit when interrupted
+
+	// For the staleness of the neighbors each node handed out.
+	nodes  map[enode.ID]*enode.Node
+	alive  map[enode.ID]bool
+	handed map[enode.ID][]enode.ID
+}
+
+// visit pings n, requests its record and asks it for neighbors of random
+// targets, and with --probe checks its RLPx endpoint. It returns the neighbors.
+// run records the visit once they are queued.
+func (c *crawler) visit(n *enode.Node) []*enode.Node {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	tstart := time.Now()
+	err := <-c.u.SendPing(n.ID(), addr, nil)
+	ping := classify(err)
+	if err == nil {
+		ping.Detail = time.Since(tstart).Round(time.Millisecond).String()
+	}
+	recordBond(n, err)
+	recordProbe("ping", n, ping)
+	c.mu.Lock()
+	c.visited++
+	if err == nil {
+		c.answered++
+	}
+	c.nodes[n.ID()] = n
+	c.alive[n.ID()] = err == nil
+	c.mu.Unlock()
+	if err != nil {
+		fmt.Println(withGeo(n.IP(), n.ID().TerminalString(), addr, "ping", cell(ping))...)
+		return nil
+	}
+
+	var seq uint64
+	if rec, err := c.u.RequestENR(n); err == nil {
+		seq = rec.Seq()
+		recordNode(n, func(r *crawldb.Node) { r.Seq = seq })
+	}
+
+	found := make(map[enode.ID]*enode.Node)
+	for i := 0; i < crawlLookups; i++ {
+		target, _ := crypto.GenerateKey()
+		ns, err := c.u.Findnode(n.ID(), addr, &target.PublicKey)
+		for _, nb := range ns {
+			found[nb.ID()] = &nb.Node
+		}
+		if err != nil {
+			recordProbe("findnode", n, classify(err))
+			break
+		}
+	}
+	neighbors := make([]*enode.Node, 0, len(found))
+	ids := make([]enode.ID, 0, len(found))
+	for _, nb := range found {
+		neighbors = append(neighbors, nb)
+		if nb.ID() != c.self {
+			ids = append(ids, nb.ID())
+		}
+	}
+	c.mu.Lock()
+	c.handed[n.ID()] = ids
+	c.mu.Unlock()
+	if len(found) > 0 {
+		recordProbe("findnode", n, succeeded(fmt.Sprintf("%d nodes", len(found))))
+		recordNeighbors(n, neighbors)
+	}
+
+	status := "-"
+	if crawlProbe && n.TCP() != 0 {
+		status = cell(probeStatus(c.key, c.spec, n, c.timeout))
+	}
+	fmt.Println(withGeo(n.IP(), n.ID().TerminalString(), addr, "ping", cell(ping), "seq", seq, "neighbors", len(neighbors), "status", status)...)
+	return neighbors
+}
+
+// probeStatus checks the RLPx endpoint of n for its hello and eth status,
+// and records what it learns.
+func probeStatus(key *ecdsa.PrivateKey, spec *chainSpec, n *enode.Node, timeout time.Duration) *outcome {
+	r := &reachReport{}
+	reachTCP(r, key, spec, n, timeout)
+	recordReach(n, r)
+	o := tcpResult(r)
+	recordProbe("status", n, o)
+	return o
+}
+
+// tcpResult is the first failed TCP stage of r, or its status.
+func tcpResult(r *reachReport) *outcome {
+	for _, o := range []*outcome{r.TCP, r.RLPx, r.Status} {
+		if o != nil && o.Kind != outcomeSuccess {
+			return o
+		}
+	}
+	return succeeded(r.Hello.Name)
+}
+
+// visited is a node visited and the neighbors it returned.
+type visited struct {
+	node      *enode.Node
+	neighbors []*enode.Node
+}
+
+// run visits the queue and the nodes it leads to, with up to workers at once,
+// until all are visited or end is closed. It reports whether the crawl finished.
+// Once end is closed no more visits start, but those under way are waited for,
+// so their neighbors get queued before they count as visited.
+func (c *crawler) run(queue []*enode.Node, workers int, end <-chan struct{}) bool {
+	results := make(chan visited, workers)
+	busy := 0
+	ended := false
+	for (len(queue) > 0 && !ended) || busy > 0 {
+		for busy < workers && len(queue) > 0 && !ended {
+			n := queue[0]
+			queue = queue[1:]
+			busy++
+			go func() { results <- visited{n, c.visit(n)} }()
+		}
+		select {
+		case v := <-results:
+			busy--
+			for _, n := range v.neighbors {
+				if n.ID() == c.self {
+					// Nodes the crawler talked to report it, too.
+					continue
+				}
+				ok, err := c.db.Enqueue(n)
+				if err != nil {
+					log.Println("failed to queue node", err)
+				}
+				if ok {
+					c.mu.Lock()
+					c.discovered++
+					c.mu.Unlock()
+					queue = append(queue, n)
+				}
+			}
+			if err := c.db.Visited(v.node.ID(), time.Now()); err != nil {
+				log.Println("failed to record visit", err)
+			}
+		case <-end:
+			ended = true
+			end = nil
+		}
+	}
+	c.queued = len(queue)
+	return len(queue) == 0
+}
+
+// mustWriteCrawlSnapshot writes the nodes that answered during the crawl to file.
+func mustWriteCrawlSnapshot(db *crawldb.DB, file string) {
+	s, err := db.Snapshot(db.Crawl().Started)
+	if err == nil {
+		err = crawldb.WriteSnapshot(file, s)
+	}
+	if err != nil {
+		log.Println("failed to write snapshot", err)
+		classify(err).exit()
+	}
+}
+
+// crawlCmd represents the crawl command
+var crawlCmd = &cobra.Command{
+	Use:   "crawl [<enode...>]",
+	Short: "Crawl the discovery network, recording every node in the crawl database",
+	Long: `
+    Starting from the given enodes, or the chain's bootnodes, pings each node, requests its node record and
+    asks it for neighbors of --lookups random targets, then visits the neighbors not seen yet in this crawl.
+    With --probe, each node's RLPx endpoint is checked too: its client name, capabilities and eth status.
+
+    Everything learned is recorded in the crawl database (--db, by default ./crawl.db): first and last seen,
+    IP and port history, bond state, ENR sequence number, client, capabilities and status of each node, and
+    a log of every probe result. Query it with 'dp2p db'.
+
+    The crawl runs until no node is left to visit, --duration seconds have passed or it is interrupted.
+    When interrupted, the visits under way are finished first, and the crawl is resumed from the database
+    by the next crawl, unless --new is given.
+    Once done, the nodes that answered are written to the --snapshot file, to compare crawls with churn.
+
+    With --staleness, the nodes handing out stale neighbors are reported last: those with at least the given share
+    of dead nodes among the neighbors they returned (and at least 4 neighbors pinged in this run of the crawl).
+
+    With --geoip, the nodes that answered in this run are also tallied by country and autonomous system.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		db := mustCrawlDB()
+		spec := mustChainSpec()
+
+		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		c := &crawler{
+			db:      db,
+			u:       mustUdpConfig(discover.Config{PrivateKey: key}),
+			key:     key,
+			self:    enode.PubkeyToIDV4(&key.PublicKey),
+			spec:    spec,
+			timeout: time.Duration(int32(connectTimeout)) * time.Second,
+			nodes:   make(map[enode.ID]*enode.Node),
+			alive:   make(map[enode.ID]bool),
+			handed:  make(map[enode.ID][]enode.ID),
+		}
+
+		var queue []*enode.Node
+		if state := db.Crawl(); state != nil && state.Finished.IsZero() && !crawlNew {
+			queue, err = db.Queue()
+			if err != nil {
+				log.Println("failed to read crawl queue", err)
+				classify(err).exit()
+			}
+			if len(args) > 0 {
+				log.Println("resuming an interrupted crawl, the given enodes are ignored (start over with --new)")
+			}
+			visited, _ := db.CountVisited()
+			fmt.Printf("resuming crawl started %v: %d visited, %d queued\n", state.Started.Format(time.RFC3339), visited, len(queue))
+		} else {
+			seeds := mustSeeds(args)
+			if err := db.StartCrawl(time.Now()); err != nil {
+				log.Println("failed to start crawl", err)
+				classify(err).exit()
+			}
+			for _, n := range seeds {
+				recordNode(n, nil)
+				if ok, _ := db.Enqueue(n); ok {
+					queue = append(queue, n)
+				}
+			}
+		}
+
+		end, release := sessionEnd()
+		finished := c.run(queue, maxPendingPeers, end)
+		release()
+
+		c.mu.Lock()
+		summary := fmt.Sprintf("visited %d, answered %d, discovered %d", c.visited, c.answered, c.discovered)
+		if crawlStale > 0 {
+			stale := staleNodes(c.nodes, c.handed, c.alive, crawlStale)
+			for _, s := range stale {
+				fmt.Println(withGeo(s.node.IP(), "stale", s.node.ID().TerminalString(), addrString(s.node), s)...)
+			}
+			summary += fmt.Sprintf(", %d handing out stale neighbors", len(stale))
+		}
+		var alive []*enode.Node
+		for id, n := range c.nodes {
+			if c.alive[id] {
+				alive = append(alive, n)
+			}
+		}
+		printGeoSummary(alive)
+		c.mu.Unlock()
+		if finished {
+			if err := db.FinishCrawl(time.Now()); err != nil {
+				log.Println("failed to finish crawl", err)
+			}
+			if crawlOut != "" {
+				mustWriteCrawlSnapshot(db, crawlOut)
+			}
+		} else {
+			summary += fmt.Sprintf(", %d left to visit (run crawl again to resume)", c.queued)
+		}
+		if c.answered == 0 && c.visited > 0 {
+			(&outcome{Kind: outcomeTimeout, Detail: summary}).exit()
+		}
+		succeeded(summary).exit()
+	},
+}
+
+func init() {
+	crawlCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	crawlCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
+	crawlCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 500, "milliseconds for devp2p response timeout allowance")
+	crawlCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 10, "time in seconds to wait for TCP connections (with --probe)")
+	crawlCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 16, "maximum number of nodes visited at once")
+	crawlCmd.PersistentFlags().IntVar(&crawlLookups, "lookups", 8, "findnode requests with random targets per node")
+	crawlCmd.PersistentFlags().BoolVar(&crawlProbe, "probe", false, "also check each node's RLPx endpoint: client, capabilities and eth status")
+	crawlCmd.PersistentFlags().BoolVar(&crawlNew, "new", false, "start a new crawl instead of resuming an interrupted one")
+	crawlCmd.PersistentFlags().Float64Var(&crawlStale, "staleness", 0, "report the nodes at least this share of whose neighbors didn't answer the crawl's ping (0 = don't)")
+	crawlCmd.PersistentFlags().StringVar(&crawlOut, "snapshot", "", "snapshot file to write the answering nodes to once the crawl is done")
+	crawlCmd.PersistentFlags().IntVarP(&sessionDuration, "duration", "d", 0, "seconds to crawl for (0 = until done or interrupted)")
+	crawlCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain whose bootnodes to start from and to claim in status exchanges ("+chainNames()+")")
+	crawlCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	rootCmd.AddCommand(crawlCmd)
+}
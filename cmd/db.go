@@ -0,0 +1,251 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dbSeen    time.Duration
+	dbNetwork uint64
+	dbGenesis string
+	dbClient  string
+	dbNet     string
+	dbJSON    bool
+	dbOut     string
+)
+
+// parseNodeID parses a hex node ID or an enode URL.
+func parseNodeID(s string) (enode.ID, error) {
+	var id enode.ID
+	if strings.HasPrefix(s, "enode://") {
+		n, err := enode.ParseV4(s)
+		if err != nil {
+			return id, err
+		}
+		return n.ID(), nil
+	}
+	err := id.UnmarshalText([]byte(s))
+	return id, err
+}
+
+func mustNodeIDArg(args []string) enode.ID {
+	if len(args) == 0 {
+		log.Println("need a node ID or enode as first argument")
+		os.Exit(1)
+	}
+	id, err := parseNodeID(args[0])
+	if err != nil {
+		log.Println("bad node ID", args[0], err)
+		os.Exit(1)
+	}
+	return id
+}
+
+// mustQuery builds the query of the db query flags.
+func mustQuery() *crawldb.Query {
+	q := &crawldb.Query{NetworkID: dbNetwork, Client: dbClient}
+	if dbSeen > 0 {
+		q.Since = time.Now().Add(-dbSeen)
+	}
+	if dbGenesis != "" {
+		q.Genesis = common.HexToHash(dbGenesis)
+	}
+	if dbNet != "" {
+		_, n, err := net.ParseCIDR(dbNet)
+		if err != nil {
+			log.Println("bad --net", err)
+			os.Exit(1)
+		}
+		q.Net = n
+	}
+	return q
+}
+
+func printJSON(v interface{}) {
+	enc, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(string(enc))
+}
+
+// ago renders the time since t, or - if t is zero.
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String()
+}
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Query the crawl database",
+	Long: `
+    Queries the crawl database (--db, by default ./crawl.db) filled by crawl and by probes run with --db.
+`,
+}
+
+var dbQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "List the nodes matching the filters",
+	Long: `
+    Lists the recorded nodes matching all given filters, e.g. the nodes seen in the last 24h on network 61:
+
+        dp2p db query --seen 24h --network 61
+
+    Seen means the node answered a probe. The network, genesis and client are known from the eth status
+    and hello of crawl --probe, reach or hello.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		db := mustCrawlDB()
+		nodes, err := db.Select(mustQuery())
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		if dbJSON {
+			for _, n := range nodes {
+				printJSON(n)
+			}
+			succeeded(fmt.Sprintf("%d nodes", len(nodes))).exit()
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "node\taddr\tfirst seen\tlast seen\tendpoints\tbonded\tseq\tnetwork\tclient")
+		for _, n := range nodes {
+			addr, network, client := "-", "-", n.Client
+			if e := n.Endpoint(); e != nil {
+				addr = (&net.UDPAddr{IP: e.IP, Port: e.UDP}).String()
+			}
+			if n.Status != nil {
+				network = fmt.Sprint(n.Status.NetworkID)
+			}
+			if client == "" {
+				client = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%d\t%s\t%s\n", n.ID.TerminalString(), addr,
+				ago(n.FirstSeen), ago(n.LastSeen), len(n.Endpoints), ago(n.Bonded), n.Seq, network, client)
+		}
+		tw.Flush()
+		succeeded(fmt.Sprintf("%d nodes", len(nodes))).exit()
+	},
+}
+
+var dbShowCmd = &cobra.Command{
+	Use:   "show <node id|enode>",
+	Short: "Print everything recorded about a node as JSON",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		id := mustNodeIDArg(args)
+		n := mustCrawlDB().Node(id)
+		if n == nil {
+			(&outcome{Kind: outcomeFailure, Detail: "unknown node " + id.String()}).exit()
+		}
+		printJSON(n)
+		succeeded("").exit()
+	},
+}
+
+var dbProbesCmd = &cobra.Command{
+	Use:   "probes [<node id|enode>]",
+	Short: "Print the probe log, of all nodes or one",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		var (
+			id  enode.ID
+			one = len(args) > 0
+		)
+		if one {
+			id = mustNodeIDArg(args)
+		}
+		var since time.Time
+		if dbSeen > 0 {
+			since = time.Now().Add(-dbSeen)
+		}
+		var count int
+		err := mustCrawlDB().Probes(since, func(p *crawldb.Probe) bool {
+			if one && p.ID != id {
+				return true
+			}
+			count++
+			if dbJSON {
+				printJSON(p)
+				return true
+			}
+			fields := fmt.Sprintf("result=%s exit=%d detail=%q", p.Result, p.Exit, p.Detail)
+			if p.Egress != "" {
+				fields = "egress=" + p.Egress + " " + fields
+			}
+			fmt.Println(p.Time.Format(time.RFC3339), p.ID.TerminalString(), p.Kind, p.Addr, fields)
+			return true
+		})
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		succeeded(fmt.Sprintf("%d probes", count)).exit()
+	},
+}
+
+var dbSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Write the nodes seen recently to a snapshot file",
+	Long: `
+    Writes the records of the nodes seen within --seen to a JSON snapshot file, to compare with churn.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		s, err := mustCrawlDB().Snapshot(time.Now().Add(-dbSeen))
+		if err == nil {
+			err = crawldb.WriteSnapshot(dbOut, s)
+		}
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		succeeded(fmt.Sprintf("%d nodes", len(s.Nodes))).exit()
+	},
+}
+
+func init() {
+	dbQueryCmd.Flags().DurationVar(&dbSeen, "seen", 0, "only nodes seen within this duration, e.g. 24h")
+	dbQueryCmd.Flags().Uint64Var(&dbNetwork, "network", 0, "only nodes on this eth network ID")
+	dbQueryCmd.Flags().StringVar(&dbGenesis, "genesis", "", "only nodes with this genesis hash")
+	dbQueryCmd.Flags().StringVar(&dbClient, "client", "", "only nodes whose client name contains this")
+	dbQueryCmd.Flags().StringVar(&dbNet, "net", "", "only nodes with their latest IP in this network (CIDR)")
+	dbQueryCmd.Flags().BoolVar(&dbJSON, "json", false, "print one JSON record per line")
+	dbProbesCmd.Flags().DurationVar(&dbSeen, "since", 0, "only probes within this duration, e.g. 1h")
+	dbProbesCmd.Flags().BoolVar(&dbJSON, "json", false, "print one JSON record per line")
+	dbSnapshotCmd.Flags().DurationVar(&dbSeen, "seen", 24*time.Hour, "nodes seen within this duration")
+	dbSnapshotCmd.Flags().StringVarP(&dbOut, "out", "o", "snapshot.json", "snapshot file to write")
+	dbCmd.AddCommand(dbQueryCmd, dbShowCmd, dbProbesCmd, dbSnapshotCmd)
+	rootCmd.AddCommand(dbCmd)
+}
@@ -0,0 +1,88 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/etclabscore/dp2p/forkid"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/spf13/cobra"
+)
+
+// enrCmd represents the enr command
+var enrCmd = &cobra.Command{
+	Use:   "enr <enode>",
+	Short: "Request an enode's node record (EIP-868) and evaluate its eth fork id",
+	Long: `
+    Sends a devp2p ENRREQUEST (with preliminary PING/PONG) and prints the returned record.
+    If the record carries an 'eth' entry, its fork id (EIP-2124) is checked against the given chain.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		en := mustEnodeArg(args)
+		spec := mustChainSpec()
+
+		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
+
+		u := mustUdp()
+
+		var n *enode.Node
+		err := tryAddrs(en, func(a *enode.Node) error {
+			rn, err := u.RequestENR(a)
+			if err == nil && n == nil {
+				n = rn
+			}
+			return err
+		})
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+
+		enc, err := rlp.EncodeToBytes(n.Record())
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		fmt.Println(n.String())
+		fmt.Println("enr:" + base64.RawURLEncoding.EncodeToString(enc))
+		fmt.Println("seq", n.Seq())
+		recordNode(en, func(rec *crawldb.Node) { rec.Seq = n.Seq() })
+
+		var eth forkid.ENREntry
+		if err := n.Load(&eth); err != nil {
+			fmt.Println("no eth entry")
+			succeeded("").exit()
+		}
+		fmt.Println(spec.describeForkID(eth.ForkID, forkHead))
+		succeeded("").exit()
+	},
+}
+
+func init() {
+	enrCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	enrCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
+	enrCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "t", 500, "milliseconds for devp2p response timeout allowance")
+	enrCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to validate the eth fork id against ("+chainNames()+")")
+	enrCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	rootCmd.AddCommand(enrCmd)
+}
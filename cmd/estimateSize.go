@@ -0,0 +1,208 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/etclabscore/dp2p/netsize"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+// lookupResults is the number of closest nodes a lookup returns.
+const lookupResults = 16
+
+var (
+	estimateLookups    int
+	estimateRounds     int
+	estimateConfidence float64
+	estimateSnapshots  []string
+)
+
+// sizeSample is what the lookups of estimate-size found.
+type sizeSample struct {
+	mu        sync.Mutex
+	rounds    []map[enode.ID]bool // nodes returned by the lookups of each round
+	distances []float64           // of the farthest of the closest nodes to each target
+	logDists  map[int]int         // log distance of the closest node to each target
+	short     int                 // lookups returning fewer than lookupResults nodes
+}
+
+// lookup looks up a random target and adds the result to round.
+func (s *sizeSample) lookup(u *discover.Dual, self enode.ID, round map[enode.ID]bool) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	target := enode.PubkeyToIDV4(&key.PublicKey)
+	var found []*enode.Node
+	res := u.Lookup(&key.PublicKey)
+	for _, n := range res {
+		if n.ID() != self {
+			found = append(found, n)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return enode.DistCmp(target, found[i].ID(), found[j].ID()) < 0 })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range found {
+		round[n.ID()] = true
+	}
+	if len(found) > 0 {
+		s.logDists[enode.LogDist(target, found[0].ID())]++
+	}
+	if len(found) < lookupResults {
+		s.short++
+		return
+	}
+	s.distances = append(s.distances, netsize.Distance(target, found[lookupResults-1].ID()))
+}
+
+func printEstimate(name string, e netsize.Estimate, detail string) {
+	fmt.Printf("%s %.0f (%.0f%% ci %.0f-%.0f) %s\n", name, e.N, 100*e.Confidence, e.Low, e.High, detail)
+}
+
+// estimateSizeCmd represents the estimate-size command
+var estimateSizeCmd = &cobra.Command{
+	Use:   "estimate-size [<bootnode enode...>]",
+	Short: "Estimate the number of nodes in the discovery network from random lookups",
+	Long: `
+    Runs --rounds rounds of --lookups lookups of random targets, joining the network through the given enodes or
+    the chain's bootnodes, and estimates how many nodes there are in two ways:
+
+    From the distances: with node IDs spread uniformly, the 16 nodes closest to a random target lie within a
+    distance of about 16/N of it (as a fraction of the ID space). The distances of the 16th closest node of all
+    lookups give an estimate of N with a confidence interval (--confidence). The log distances of the closest
+    node of each lookup are printed too.
+
+    By capture-recapture: each round's lookups sample the network, and how many nodes later rounds find again
+    gives a Schnabel estimate. Snapshots of repeated crawls (crawl --snapshot) given with --snapshots give another.
+
+    Both are biased low by nodes that lookups rarely find, e.g. those behind NAT that are in few tables, and
+    high by dead nodes lingering in tables. A full crawl counts exactly; this is cheap enough to run regularly.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		seeds := mustSeeds(args)
+
+		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		self := enode.PubkeyToIDV4(&key.PublicKey)
+		u := mustUdpConfig(discover.Config{PrivateKey: key, Bootnodes: seeds})
+
+		s := &sizeSample{logDists: make(map[int]int)}
+		seen := make(map[enode.ID]bool)
+		for r := 0; r < estimateRounds; r++ {
+			round := make(map[enode.ID]bool)
+			sem := make(chan struct{}, maxPendingPeers)
+			var wg sync.WaitGroup
+			for i := 0; i < estimateLookups; i++ {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func() {
+					defer wg.Done()
+					s.lookup(u, self, round)
+					<-sem
+				}()
+			}
+			wg.Wait()
+			var fresh int
+			for id := range round {
+				if !seen[id] {
+					seen[id] = true
+					fresh++
+				}
+			}
+			s.rounds = append(s.rounds, round)
+			fmt.Println("round", r+1, "lookups", estimateLookups, "nodes", len(round), "new", fresh)
+		}
+
+		var dists []int
+		for d := range s.logDists {
+			dists = append(dists, d)
+		}
+		sort.Ints(dists)
+		fields := []string{"closest log-distance"}
+		for _, d := range dists {
+			fields = append(fields, fmt.Sprintf("%d:%d", d, s.logDists[d]))
+		}
+		fmt.Println(strings.Join(fields, " "))
+		fmt.Println("nodes found", len(seen))
+
+		var best *netsize.Estimate
+		e, err := netsize.FromDistances(s.distances, lookupResults, estimateConfidence)
+		if err == nil {
+			best = &e
+			printEstimate("distance estimate", e, fmt.Sprintf("from %d lookups, %d found fewer than %d nodes", len(s.distances), s.short, lookupResults))
+		} else {
+			fmt.Printf("distance estimate - %v: %d lookups found fewer than %d nodes, the network may have as few as %d\n", err, s.short, lookupResults, len(seen))
+		}
+		if e, recaps, err := netsize.CaptureRecapture(s.rounds, estimateConfidence); err == nil {
+			if best == nil {
+				best = &e
+			}
+			printEstimate("capture-recapture lookups", e, fmt.Sprintf("from %d rounds, %d recaptures", len(s.rounds), recaps))
+		} else {
+			fmt.Println("capture-recapture lookups -", err)
+		}
+		if len(estimateSnapshots) > 0 {
+			var samples []map[enode.ID]bool
+			for _, file := range estimateSnapshots {
+				sample := make(map[enode.ID]bool)
+				for _, n := range mustSnapshot(file).Nodes {
+					sample[n.ID] = true
+				}
+				samples = append(samples, sample)
+			}
+			if e, recaps, err := netsize.CaptureRecapture(samples, estimateConfidence); err == nil {
+				printEstimate("capture-recapture snapshots", e, fmt.Sprintf("from %d snapshots, %d recaptures", len(samples), recaps))
+			} else {
+				fmt.Println("capture-recapture snapshots -", err)
+			}
+		}
+
+		if best == nil {
+			(&outcome{Kind: outcomeFailure, Detail: fmt.Sprintf("no estimate, %d nodes found", len(seen))}).exit()
+		}
+		succeeded(fmt.Sprintf("about %.0f nodes", best.N)).exit()
+	},
+}
+
+func init() {
+	estimateSizeCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	estimateSizeCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
+	estimateSizeCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 500, "milliseconds for devp2p response timeout allowance")
+	estimateSizeCmd.PersistentFlags().IntVar(&estimateLookups, "lookups", 16, "random lookups per round")
+	estimateSizeCmd.PersistentFlags().IntVar(&estimateRounds, "rounds", 4, "rounds of lookups, the samples for capture-recapture")
+	estimateSizeCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 4, "maximum number of lookups at once")
+	estimateSizeCmd.PersistentFlags().Float64Var(&estimateConfidence, "confidence", 0.95, "confidence level of the intervals")
+	estimateSizeCmd.PersistentFlags().StringSliceVar(&estimateSnapshots, "snapshots", nil, "crawl snapshot files to estimate the size from by capture-recapture too")
+	estimateSizeCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain whose bootnodes to start from ("+chainNames()+")")
+	rootCmd.AddCommand(estimateSizeCmd)
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/etclabscore/dp2p/forkid"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// statusTimeout is how long we wait for the remote's eth status.
+const statusTimeout = 2 * time.Second
+
+// ethProtocols returns the eth protocol versions we speak, eth/64 and eth/63,
+// each running run(version). The server only runs the highest version both
+// sides have in common.
+func ethProtocols(run func(version uint) func(peer *p2p.Peer, ws p2p.MsgReadWriter) error) []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    eth.ProtocolName,
+			Version: eth64,
+			Length:  eth.ProtocolLengths[0],
+			Run:     run(eth64),
+		},
+		{
+			Name:    eth.ProtocolName,
+			Version: eth.ProtocolVersions[0],
+			Length:  eth.ProtocolLengths[0],
+			Run:     run(eth.ProtocolVersions[0]),
+		},
+	}
+}
+
+// exchangeStatus sends our status for spec and returns the remote's, either
+// a *statusData or, for eth/64, a *statusData64.
+func exchangeStatus(ws p2p.MsgReadWriter, spec *chainSpec, version uint) (interface{}, error) {
+	// eth/64 extends the status with a fork id, earlier versions don't know it.
+	var ours, theirs interface{}
+	if version >= eth64 {
+		ours = &statusData64{
+			ProtocolVersion: uint32(version),
+			NetworkId:       spec.NetworkId,
+			TD:              spec.TD,
+			CurrentBlock:    spec.Genesis,
+			GenesisBlock:    spec.Genesis,
+			ForkID:          spec.forkID(),
+		}
+		theirs = new(statusData64)
+	} else {
+		ours = &statusData{
+			ProtocolVersion: uint32(version),
+			NetworkId:       spec.NetworkId,
+			TD:              spec.TD,
+			CurrentBlock:    spec.Genesis,
+			GenesisBlock:    spec.Genesis,
+		}
+		theirs = new(statusData)
+	}
+
+	// Send out own handshake in a new thread
+	errc := make(chan error, 2)
+	go func() {
+		errc <- p2p.Send(ws, eth.StatusMsg, ours)
+	}()
+	go func() {
+		errc <- readStatus(ws, theirs)
+	}()
+	timeout := time.NewTimer(statusTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return nil, err
+			}
+		case <-timeout.C:
+			return nil, &outcome{Kind: outcomeTimeout, Detail: "no status message"}
+		}
+	}
+	return theirs, nil
+}
+
+// describeStatus renders a remote's eth status on one line.
+func describeStatus(spec *chainSpec, theirs interface{}) string {
+	switch status := theirs.(type) {
+	case *statusData:
+		return fmt.Sprintf("eth/%d network=%d td=%v head=%s genesis=%s", status.ProtocolVersion, status.NetworkId, status.TD, status.CurrentBlock.Hex(), status.GenesisBlock.Hex())
+	case *statusData64:
+		return fmt.Sprintf("eth/%d network=%d td=%v head=%s genesis=%s %s", status.ProtocolVersion, status.NetworkId, status.TD, status.CurrentBlock.Hex(), status.GenesisBlock.Hex(), spec.describeForkID(status.ForkID, forkHead))
+	}
+	return fmt.Sprint(theirs)
+}
+
+// checkStatus compares the remote's eth status with what we claim for spec.
+// The fork id is checked last: on another network or genesis it can't match.
+func checkStatus(spec *chainSpec, version uint, theirs interface{}) error {
+	var (
+		theirVersion uint32
+		theirNetwork uint64
+		theirGenesis common.Hash
+		theirForkID  *forkid.ID
+	)
+	switch status := theirs.(type) {
+	case *statusData:
+		theirVersion, theirNetwork, theirGenesis = status.ProtocolVersion, status.NetworkId, status.GenesisBlock
+	case *statusData64:
+		theirVersion, theirNetwork, theirGenesis = status.ProtocolVersion, status.NetworkId, status.GenesisBlock
+		theirForkID = &status.ForkID
+	}
+	switch {
+	case theirVersion != uint32(version):
+		return statusMismatch("version", version, theirVersion)
+	case theirNetwork != spec.NetworkId:
+		return statusMismatch("network", spec.NetworkId, theirNetwork)
+	case theirGenesis != spec.Genesis:
+		return statusMismatch("genesis", spec.Genesis.Hex(), theirGenesis.Hex())
+	}
+	if theirForkID != nil {
+		if verdict, err := spec.forkFilter(forkHead)(*theirForkID); verdict == forkid.Incompatible {
+			return statusMismatch("forkid", spec.forkID(), fmt.Sprintf("%v (%v)", *theirForkID, err))
+		}
+	}
+	return nil
+}
@@ -17,39 +17,108 @@ package cmd
 import (
 	"fmt"
 	"github.com/etclabscore/dp2p/discover"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/spf13/cobra"
 	"log"
 	"net"
 	"time"
 )
 
+var findnodePing bool
+
 // findnodeCmd represents the neighbors command
 var findnodeCmd = &cobra.Command{
 	Use:   "findnode",
 	Short: "Send a devp2p FINDNODE request to an enode (with preliminary PING/PONG)",
-	Long: ``,
+	Long: `
+    Prints the neighbors the enode returns for a random target. With --ping each of them is pinged, --maxpending
+    at once, and annotated with its round-trip time or dead; the share of dead ones is the enode's staleness,
+    how out of date the table it hands out is.
+
+    With --geoip, the neighbors are also tallied by country and autonomous system, last.
+`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		en := mustEnodeArg(args)
 
 		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
 
-		u := mustUdp()
+		// Neighbors failing the relay rules (netutil.CheckRelayIP) for either family are reported, not dropped silently.
+		var rejected int
+		key, _ := crypto.GenerateKey()
+		u := mustUdpConfig(discover.Config{
+			PrivateKey: key,
+			Rejected: func(from, addr *net.UDPAddr, err error) {
+				rejected++
+				fmt.Println("rejected", addr, err)
+			},
+		})
 
-		nodes, err := u.Findnode(en.ID(), &net.UDPAddr{IP: en.IP(), Port: en.UDP()}, en.Pubkey())
+		// Every address of a DNS name is asked, the same neighbors count once.
+		var nodes []*enode.Node
+		var ip4, ip6 int
+		seen := make(map[enode.ID]bool)
+		err := tryAddrs(en, func(a *enode.Node) error {
+			ns, err := u.Findnode(a.ID(), &net.UDPAddr{IP: a.IP(), Port: a.UDP()}, a.Pubkey())
+			for _, n := range ns {
+				if seen[n.ID()] {
+					continue
+				}
+				seen[n.ID()] = true
+				if n.IP().To4() != nil {
+					ip4++
+				} else {
+					ip6++
+				}
+				nodes = append(nodes, &n.Node)
+			}
+			return err
+		})
 		if err != nil {
-			log.Fatalln(err)
+			log.Println(err)
+			classify(err).exit()
 		}
 
+		recordNeighbors(en, nodes)
+		if !findnodePing {
+			for _, n := range nodes {
+				fmt.Println(withGeo(n.IP(), n)...)
+			}
+			printGeoSummary(nodes)
+			succeeded(fmt.Sprintf("%d nodes (%d IPv4, %d IPv6), %d rejected", len(nodes), ip4, ip6, rejected)).exit()
+		}
+
+		self := enode.PubkeyToIDV4(&key.PublicKey)
+		var others []*enode.Node
 		for _, n := range nodes {
-			fmt.Println(n.String())
+			if n.ID() == self {
+				// The enode knows us from the ping before the request.
+				fmt.Println(withGeo(n.IP(), n, "self")...)
+				continue
+			}
+			others = append(others, n)
+		}
+		s := &staleness{node: en}
+		for i, p := range pingNeighbors(u, others) {
+			s.checked++
+			if p.err != nil {
+				s.dead++
+			}
+			fmt.Println(withGeo(others[i].IP(), others[i], p)...)
 		}
+		fmt.Println("staleness", s)
+		printGeoSummary(nodes)
+		succeeded(fmt.Sprintf("%d nodes (%d IPv4, %d IPv6), %d rejected, %s", len(nodes), ip4, ip6, rejected, s)).exit()
 	},
 }
 
 func init() {
-	findnodeCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	findnodeCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	findnodeCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
 	findnodeCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "t", 500, "milliseconds for devp2p response timeout allowance")
+	findnodeCmd.PersistentFlags().BoolVar(&findnodePing, "ping", false, "ping the neighbors and report the share of dead ones")
+	findnodeCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 16, "maximum number of neighbors pinged at once")
 	rootCmd.AddCommand(findnodeCmd)
 
 	// Here you will define your flags and configuration settings.
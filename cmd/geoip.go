@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/etclabscore/dp2p/census"
+	"github.com/etclabscore/dp2p/geoip"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// geoipPaths are the MaxMind DB files (--geoip) node addresses are annotated
+// from. Addresses aren't annotated if it's empty.
+var geoipPaths []string
+
+var (
+	geoDB     *geoip.DB
+	geoDBOnce sync.Once
+)
+
+// mustGeoIP returns the databases of --geoip, nil if it isn't set.
+func mustGeoIP() *geoip.DB {
+	geoDBOnce.Do(func() {
+		if len(geoipPaths) == 0 {
+			return
+		}
+		db, err := geoip.OpenDB(geoipPaths...)
+		if err != nil {
+			log.Println("failed to open --geoip", err)
+			os.Exit(1)
+		}
+		geoDB = db
+	})
+	return geoDB
+}
+
+// geoInfo looks ip up in the --geoip databases, nil if there are none.
+func geoInfo(ip net.IP) *geoip.Info {
+	db := mustGeoIP()
+	if db == nil || ip == nil {
+		return nil
+	}
+	info, err := db.Lookup(ip)
+	if err != nil {
+		log.Println("geoip lookup of", ip, "failed:", err)
+		return nil
+	}
+	return info
+}
+
+// withGeo appends what the --geoip databases know about ip to the fields of
+// a line about it: nothing without databases, - if they know nothing.
+func withGeo(ip net.IP, fields ...interface{}) []interface{} {
+	info := geoInfo(ip)
+	if info == nil {
+		return fields
+	}
+	if s := info.String(); s != "" {
+		return append(fields, s)
+	}
+	return append(fields, "-")
+}
+
+// printGeoSummary tallies nodes by country and autonomous system as census
+// does, and prints the tables. It prints nothing without --geoip.
+func printGeoSummary(nodes []*enode.Node) {
+	if mustGeoIP() == nil || len(nodes) == 0 {
+		return
+	}
+	c := census.New()
+	for _, n := range nodes {
+		c.Add(&census.Entry{Geo: geoInfo(n.IP())})
+	}
+	fmt.Printf("\nlocated %d hosting %d (%s)\n\n", c.Located, c.Hosted, percent(c.Hosted, c.Located))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	printTally(tw, "country", c.Countries, c.Located)
+	printTally(tw, "asn", c.ASNs, c.Located)
+	tw.Flush()
+}
@@ -0,0 +1,174 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/etclabscore/dp2p/nodegraph"
+	"github.com/spf13/cobra"
+)
+
+var (
+	graphSince  time.Duration
+	graphFormat string
+	graphOut    string
+	graphTop    int
+)
+
+// loadGraph builds the neighbor graph from the edges in db last seen
+// within since (all if zero), with the addresses and clients of its nodes.
+func loadGraph(db *crawldb.DB, since time.Duration) (*nodegraph.Graph, error) {
+	var start time.Time
+	if since > 0 {
+		start = time.Now().Add(-since)
+	}
+	g := nodegraph.New()
+	err := db.Edges(start, func(e *crawldb.Edge) bool {
+		g.AddEdge(e.From, e.To)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range g.Vertices {
+		if n := db.Node(v.ID); n != nil {
+			if e := n.Endpoint(); e != nil {
+				v.Addr = (&net.UDPAddr{IP: e.IP, Port: e.UDP}).String()
+			}
+			v.Client = n.Client
+		}
+	}
+	return g, nil
+}
+
+// degreeLine renders a degree distribution with counts in power of two classes.
+func degreeLine(name string, d *nodegraph.Distribution) string {
+	classes := make(map[int]int)
+	var top int
+	for k, n := range d.Counts {
+		c := 0
+		for 1<<uint(c) <= k {
+			c++
+		}
+		classes[c] += n
+		if c > top {
+			top = c
+		}
+	}
+	fields := []string{fmt.Sprintf("%s min %d median %d max %d mean %.1f", name, d.Min, d.Median, d.Max, d.Mean)}
+	for c := 0; c <= top; c++ {
+		var label string
+		switch c {
+		case 0:
+			label = "0"
+		case 1:
+			label = "1"
+		default:
+			label = fmt.Sprintf("%d-%d", 1<<uint(c-1), 1<<uint(c)-1)
+		}
+		fields = append(fields, fmt.Sprintf("%s:%d", label, classes[c]))
+	}
+	return strings.Join(fields, " ")
+}
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Export the neighbor graph of the crawl database and report its topology",
+	Long: `
+    Every NEIGHBORS reply recorded by crawl, or by findnode with --db, is an edge from the reporting node to each
+    reported node. graph loads the edges (last seen within --since, if given) from the crawl database and reports
+    the in-degree (how many tables a node is in) and out-degree distributions, the strongly connected components,
+    and the --top nodes appearing in the most tables, with their share of the reporting nodes.
+
+    With --out the graph is exported as dot (Graphviz), graphml, gexf (Gephi) or json (--format), with the
+    address and client name of each node as attributes.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if graphOut != "" && !containsString(nodegraph.Formats, graphFormat) {
+			log.Println("unknown --format", graphFormat, "(want "+strings.Join(nodegraph.Formats, ", ")+")")
+			os.Exit(1)
+		}
+
+		g, err := loadGraph(mustCrawlDB(), graphSince)
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		if graphOut != "" {
+			f, err := os.Create(graphOut)
+			if err == nil {
+				err = g.Write(f, graphFormat)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil {
+				log.Println("failed to export graph", err)
+				os.Exit(1)
+			}
+		}
+
+		reporters := g.Reporters()
+		fmt.Println("nodes", len(g.Vertices), "edges", g.NumEdges(), "reporting", reporters)
+		fmt.Println(degreeLine("out-degree", g.OutDegrees()))
+		fmt.Println(degreeLine("in-degree", g.InDegrees()))
+		comps := g.Components()
+		var singletons int
+		for _, c := range comps {
+			if len(c) == 1 {
+				singletons++
+			}
+		}
+		if len(comps) > 0 {
+			fmt.Printf("components %d largest %d (%.1f%%) singletons %d\n", len(comps), len(comps[0]), 100*float64(len(comps[0]))/float64(len(g.Vertices)), singletons)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "node\taddr\tin tables\tshare\tclient")
+		for _, v := range g.MostReported(graphTop) {
+			var share float64
+			if reporters > 0 {
+				share = 100 * float64(v.InDegree()) / float64(reporters)
+			}
+			addr, client := v.Addr, v.Client
+			if addr == "" {
+				addr = "-"
+			}
+			if client == "" {
+				client = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%s\n", v.ID.TerminalString(), addr, v.InDegree(), share, client)
+		}
+		tw.Flush()
+		succeeded(fmt.Sprintf("%d nodes, %d edges", len(g.Vertices), g.NumEdges())).exit()
+	},
+}
+
+func init() {
+	graphCmd.PersistentFlags().DurationVar(&graphSince, "since", 0, "only edges seen within this duration, e.g. 24h (0 = all)")
+	graphCmd.PersistentFlags().StringVarP(&graphFormat, "format", "f", "dot", "export format ("+strings.Join(nodegraph.Formats, ", ")+")")
+	graphCmd.PersistentFlags().StringVarP(&graphOut, "out", "o", "", "file to export the graph to")
+	graphCmd.PersistentFlags().IntVar(&graphTop, "top", 10, "number of most reported nodes to list")
+	rootCmd.AddCommand(graphCmd)
+}
@@ -0,0 +1,140 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/etclabscore/dp2p/rlpx"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+// helloReport is what we learn about a remote from the devp2p handshake alone.
+type helloReport struct {
+	Hello  *rlpx.ProtoHandshake // nil if the remote disconnected before its hello
+	Reason *p2p.DiscReason      // nil if the remote didn't disconnect us
+}
+
+// dialHello runs the RLPx encryption and protocol handshakes with en, advertising
+// no capabilities. After the remote's hello it waits up to grace for a disconnect,
+// answering pings meanwhile.
+func dialHello(en *enode.Node, timeout, grace time.Duration) (*helloReport, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dialAddrs(en, func(a *enode.Node) (net.Conn, error) {
+		return dialTCP(a, timeout)
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := rlpx.NewConn(fd)
+	defer conn.Close(p2p.DiscQuitting)
+
+	if _, err := conn.DoEncHandshake(key, en.Pubkey()); err != nil {
+		return nil, &outcome{Kind: outcomeHandshakeFailed, Detail: fmt.Sprint("encryption handshake: ", err)}
+	}
+	our := &rlpx.ProtoHandshake{
+		Version: rlpx.BaseProtocolVersion,
+		Name:    "dp2p",
+		ID:      crypto.FromECDSAPub(&key.PublicKey)[1:],
+	}
+	report := &helloReport{}
+	report.Hello, err = conn.DoProtoHandshake(our)
+	if reason, ok := err.(p2p.DiscReason); ok {
+		report.Reason = &reason
+		return report, nil
+	}
+	if err != nil {
+		return nil, &outcome{Kind: outcomeHandshakeFailed, Detail: fmt.Sprint("protocol handshake: ", err)}
+	}
+
+	deadline := time.Now().Add(grace)
+	for {
+		msg, err := conn.ReadMsgDeadline(deadline)
+		if err != nil {
+			// Most likely the grace period expired. Either way the
+			// remote has had its chance to tell us why it hung up.
+			return report, nil
+		}
+		switch msg.Code {
+		case rlpx.DiscMsg:
+			reason := rlpx.DecodeDisconnect(msg)
+			report.Reason = &reason
+			return report, nil
+		case rlpx.PingMsg:
+			msg.Discard()
+			p2p.SendItems(conn, rlpx.PongMsg)
+		default:
+			msg.Discard()
+		}
+	}
+}
+
+// helloCmd represents the hello command
+var helloCmd = &cobra.Command{
+	Use:   "hello <enode>",
+	Short: "Identify an enode's client with the devp2p hello alone",
+	Long: `
+    Completes only the RLPx encryption handshake and the devp2p protocol handshake (hello),
+    advertising no subprotocols at all, and reports the remote's client name, p2p version
+    (version 5 and up means snappy compression), capabilities, listen port and disconnect reason.
+
+    A remote disconnecting us, e.g. with 'too many peers', is not a failure: its reason is reported.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		en := mustEnodeArg(args)
+
+		r, err := dialHello(en, time.Duration(int32(connectTimeout))*time.Second, time.Duration(int32(respTimeout))*time.Millisecond)
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		if h := r.Hello; h != nil {
+			var caps []string
+			for _, c := range h.Caps {
+				caps = append(caps, c.String())
+			}
+			fmt.Println("name", h.Name)
+			fmt.Println("version", h.Version, "snappy", h.Version >= rlpx.SnappyProtocolVersion)
+			fmt.Println("caps", strings.Join(caps, ","))
+			fmt.Println("listenport", h.ListenPort)
+			recordReach(en, &reachReport{Hello: h})
+		} else {
+			fmt.Println("no hello")
+		}
+		if r.Reason != nil {
+			fmt.Println("disconnect", *r.Reason)
+		} else {
+			fmt.Println("disconnect -")
+		}
+		succeeded("").exit()
+	},
+}
+
+func init() {
+	helloCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 10, "time in seconds to wait for the TCP connection")
+	helloCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 1000, "milliseconds to wait for a disconnect after the remote's hello")
+	rootCmd.AddCommand(helloCmd)
+}
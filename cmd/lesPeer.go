@@ -0,0 +1,294 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/les"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/spf13/cobra"
+)
+
+// lesProtocolName is the capability name of the light client protocol.
+// The vendored les package only declares it inline.
+const lesProtocolName = "les"
+
+// announceType values as defined by the les protocol.
+var lesAnnounceTypes = map[uint64]string{
+	0: "none",
+	1: "simple",
+	2: "signed",
+}
+
+// lesKeyValueEntry and lesKeyValueList mirror the unexported les status encoding.
+type lesKeyValueEntry struct {
+	Key   string
+	Value rlp.RawValue
+}
+
+type lesKeyValueList []lesKeyValueEntry
+
+func (l lesKeyValueList) add(key string, val interface{}) lesKeyValueList {
+	if val == nil {
+		val = uint64(0)
+	}
+	enc, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		panic(err)
+	}
+	return append(l, lesKeyValueEntry{Key: key, Value: enc})
+}
+
+func (l lesKeyValueList) get(key string, val interface{}) (bool, error) {
+	for _, e := range l {
+		if e.Key == key {
+			if val == nil {
+				return true, nil
+			}
+			return true, rlp.DecodeBytes(e.Value, val)
+		}
+	}
+	return false, nil
+}
+
+// lesStatus is what a les server tells us about itself in its handshake.
+type lesStatus struct {
+	Version         uint
+	NetworkId       uint64
+	HeadTd          *big.Int
+	HeadHash        common.Hash
+	HeadNum         uint64
+	GenesisHash     common.Hash
+	AnnounceType    *uint64
+	ServeHeaders    bool
+	ServeChainSince *uint64
+	ServeStateSince *uint64
+	TxRelay         bool
+	BufLimit        *uint64
+	MinRecharge     *uint64
+	CostList        les.RequestCostList
+	Keys            []string
+}
+
+// servesLightClients reports whether the remote advertised itself as a server.
+// Light clients (and servers refusing clients) don't send flow control parameters.
+func (s *lesStatus) servesLightClients() bool {
+	return s.ServeHeaders && s.BufLimit != nil && s.MinRecharge != nil
+}
+
+func decodeLesStatus(version uint, list lesKeyValueList) (*lesStatus, error) {
+	s := &lesStatus{Version: version}
+	for _, e := range list {
+		s.Keys = append(s.Keys, e.Key)
+	}
+	for _, req := range []struct {
+		key string
+		val interface{}
+	}{
+		{"networkId", &s.NetworkId},
+		{"headTd", &s.HeadTd},
+		{"headHash", &s.HeadHash},
+		{"headNum", &s.HeadNum},
+		{"genesisHash", &s.GenesisHash},
+	} {
+		if ok, err := list.get(req.key, req.val); !ok || err != nil {
+			return nil, fmt.Errorf("bad status key %q: present=%v err=%v", req.key, ok, err)
+		}
+	}
+	var (
+		announce, chainSince, stateSince uint64
+		bufLimit, minRecharge            uint64
+	)
+	if ok, _ := list.get("announceType", &announce); ok {
+		s.AnnounceType = &announce
+	}
+	s.ServeHeaders, _ = list.get("serveHeaders", nil)
+	if ok, _ := list.get("serveChainSince", &chainSince); ok {
+		s.ServeChainSince = &chainSince
+	}
+	if ok, _ := list.get("serveStateSince", &stateSince); ok {
+		s.ServeStateSince = &stateSince
+	}
+	s.TxRelay, _ = list.get("txRelay", nil)
+	if ok, _ := list.get("flowControl/BL", &bufLimit); ok {
+		s.BufLimit = &bufLimit
+	}
+	if ok, _ := list.get("flowControl/MRR", &minRecharge); ok {
+		s.MinRecharge = &minRecharge
+	}
+	if _, err := list.get("flowControl/MRC", &s.CostList); err != nil {
+		return nil, fmt.Errorf("bad status key %q: %v", "flowControl/MRC", err)
+	}
+	return s, nil
+}
+
+func (s *lesStatus) print() {
+	optional := func(v *uint64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprint(*v)
+	}
+	fmt.Printf("les/%d\n", s.Version)
+	fmt.Println("networkId", s.NetworkId)
+	fmt.Println("genesis", s.GenesisHash.Hex())
+	fmt.Println("head", s.HeadNum, s.HeadHash.Hex(), "td", s.HeadTd)
+	fmt.Println("keys", strings.Join(s.Keys, ","))
+	fmt.Println("servesLightClients", s.servesLightClients())
+	if s.AnnounceType != nil {
+		fmt.Println("announceType", lesAnnounceTypes[*s.AnnounceType], *s.AnnounceType)
+	} else {
+		fmt.Println("announceType -")
+	}
+	fmt.Println("serveHeaders", s.ServeHeaders)
+	fmt.Println("serveChainSince", optional(s.ServeChainSince))
+	fmt.Println("serveStateSince", optional(s.ServeStateSince))
+	fmt.Println("txRelay", s.TxRelay)
+	fmt.Println("flowControl/BL", optional(s.BufLimit))
+	fmt.Println("flowControl/MRR", optional(s.MinRecharge))
+	if len(s.CostList) == 0 {
+		fmt.Println("flowControl/MRC -")
+		return
+	}
+	fmt.Println("flowControl/MRC")
+	costs := append(les.RequestCostList{}, s.CostList...)
+	sort.Slice(costs, func(i, j int) bool { return costs[i].MsgCode < costs[j].MsgCode })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  code\tbase\treq")
+	for _, c := range costs {
+		fmt.Fprintf(w, "  %#x\t%d\t%d\n", c.MsgCode, c.BaseCost, c.ReqCost)
+	}
+	w.Flush()
+}
+
+// lesPeerCmd represents the lespeer command
+var lesPeerCmd = &cobra.Command{
+	Aliases: []string{"lesPeer"},
+	Use:     "lespeer <enode>",
+	Short:   "Perform a LES (light client protocol) status handshake with an enode",
+	Long: `
+    Spins up a memory-backed p2p server advertising the les capability as a light client,
+    performs the LES status handshake and reports what the server advertises:
+    whether it serves light clients, its announce type, serveHeaders/serveChainSince/serveStateSince
+    and its flow control parameters (buffer limit, minimum recharge and request cost table).
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		en := mustEnodeArg(args)
+		spec := mustChainSpec()
+
+		resCh := make(chan peerResult, 1)
+		lesRun := func(version uint) func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+			return func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+				log.Println(peer.String())
+				log.Println(spew.Sdump(peer.Info()))
+
+				var send lesKeyValueList
+				send = send.add("protocolVersion", uint64(version))
+				send = send.add("networkId", spec.NetworkId)
+				send = send.add("headTd", spec.TD)
+				send = send.add("headHash", spec.Genesis)
+				send = send.add("headNum", uint64(0))
+				send = send.add("genesisHash", spec.Genesis)
+				send = send.add("announceType", uint64(1))
+
+				errc := make(chan error, 2)
+				var recv lesKeyValueList
+				go func() {
+					errc <- p2p.Send(ws, les.StatusMsg, send)
+				}()
+				go func() {
+					msg, err := ws.ReadMsg()
+					if err != nil {
+						errc <- err
+						return
+					}
+					defer msg.Discard()
+					if msg.Code != les.StatusMsg {
+						errc <- fmt.Errorf("first msg has code %x (!= %x)", msg.Code, les.StatusMsg)
+						return
+					}
+					errc <- msg.Decode(&recv)
+				}()
+				timeout := time.NewTimer(time.Duration(int32(respTimeout)) * time.Millisecond)
+				defer timeout.Stop()
+				for i := 0; i < 2; i++ {
+					select {
+					case err := <-errc:
+						if err != nil {
+							resCh <- peerResult{peer.ID(), err}
+							return err
+						}
+					case <-timeout.C:
+						err := &outcome{Kind: outcomeTimeout, Detail: "no status message"}
+						resCh <- peerResult{peer.ID(), err}
+						return err
+					}
+				}
+				status, err := decodeLesStatus(version, recv)
+				if err != nil {
+					resCh <- peerResult{peer.ID(), err}
+					return err
+				}
+				log.Println(spew.Sdump(recv))
+				status.print()
+
+				peer.Disconnect(p2p.DiscQuitting)
+				resCh <- peerResult{peer.ID(), nil}
+				return nil
+			}
+		}
+
+		var protocols []p2p.Protocol
+		for _, v := range les.ClientProtocolVersions {
+			protocols = append(protocols, p2p.Protocol{
+				Name:    lesProtocolName,
+				Version: v,
+				Length:  les.ProtocolLengths[v],
+				Run:     lesRun(v),
+			})
+		}
+
+		err := probe(en, protocols, resCh, time.Duration(int32(connectTimeout))*time.Second)
+		if _, ok := err.(*peerDropError); ok {
+			// The protocol reports its result before it returns, so a drop
+			// without one means the remote doesn't serve les to us.
+			fmt.Println("servesLightClients false")
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		classify(err).exit()
+	},
+}
+
+func init() {
+	lesPeerCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 30, "time in seconds to wait for node to dial a connection")
+	lesPeerCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 5000, "milliseconds to wait for the remote's les status")
+	lesPeerCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	lesPeerCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	rootCmd.AddCommand(lesPeerCmd)
+}
@@ -0,0 +1,296 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionDuration int
+	sessionInterval int
+)
+
+var ethMsgNames = map[uint64]string{
+	eth.StatusMsg:          "Status",
+	eth.NewBlockHashesMsg:  "NewBlockHashes",
+	eth.TxMsg:              "Tx",
+	eth.GetBlockHeadersMsg: "GetBlockHeaders",
+	eth.BlockHeadersMsg:    "BlockHeaders",
+	eth.GetBlockBodiesMsg:  "GetBlockBodies",
+	eth.BlockBodiesMsg:     "BlockBodies",
+	eth.NewBlockMsg:        "NewBlock",
+	eth.GetNodeDataMsg:     "GetNodeData",
+	eth.NodeDataMsg:        "NodeData",
+	eth.GetReceiptsMsg:     "GetReceipts",
+	eth.ReceiptsMsg:        "Receipts",
+}
+
+func ethMsgName(code uint64) string {
+	if name, ok := ethMsgNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", code)
+}
+
+// These mirror the unexported message types of the eth package.
+type (
+	newBlockHashesData []struct {
+		Hash   common.Hash
+		Number uint64
+	}
+	newBlockData struct {
+		Block *types.Block
+		TD    *big.Int
+	}
+	getBlockHeadersData struct {
+		Origin  rlp.RawValue // block hash or number
+		Amount  uint64
+		Skip    uint64
+		Reverse bool
+	}
+)
+
+// sizeBuckets is the number of size histogram buckets. Message sizes fit in 24 bits.
+const sizeBuckets = 25
+
+// msgStats counts the messages of one code received from one peer.
+type msgStats struct {
+	Count uint64
+	Bytes uint64
+	Sizes [sizeBuckets]uint64 // Sizes[i] counts messages of less than 1<<i bytes, and at least 1<<(i-1)
+}
+
+type peerStats struct {
+	Name    string
+	Version uint
+	Since   time.Time
+	Msgs    map[uint64]*msgStats
+}
+
+// trafficStats collects what each observed peer sent us.
+type trafficStats struct {
+	mu    sync.Mutex
+	peers map[enode.ID]*peerStats
+}
+
+func newTrafficStats() *trafficStats {
+	return &trafficStats{peers: make(map[enode.ID]*peerStats)}
+}
+
+func (s *trafficStats) start(peer *p2p.Peer, version uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ps, ok := s.peers[peer.ID()]; ok {
+		// Reconnected, keep counting.
+		ps.Name, ps.Version = peer.Name(), version
+		return
+	}
+	s.peers[peer.ID()] = &peerStats{Name: peer.Name(), Version: version, Since: time.Now(), Msgs: make(map[uint64]*msgStats)}
+}
+
+func (s *trafficStats) add(id enode.ID, code uint64, size uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps := s.peers[id]
+	ms, ok := ps.Msgs[code]
+	if !ok {
+		ms = new(msgStats)
+		ps.Msgs[code] = ms
+	}
+	ms.Count++
+	ms.Bytes += uint64(size)
+	ms.Sizes[bits.Len32(size)]++
+}
+
+func (s *trafficStats) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.peers)
+}
+
+func (s *trafficStats) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]enode.ID, 0, len(s.peers))
+	for id := range s.peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	for _, id := range ids {
+		ps := s.peers[id]
+		elapsed := time.Since(ps.Since)
+		fmt.Fprintf(w, "peer %s %s eth/%d %v\n", id.TerminalString(), ps.Name, ps.Version, elapsed.Round(time.Second))
+		codes := make([]uint64, 0, len(ps.Msgs))
+		for code := range ps.Msgs {
+			codes = append(codes, code)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  msg\tcount\tbytes\tper min\tsizes")
+		for _, code := range codes {
+			ms := ps.Msgs[code]
+			var sizes []string
+			for i, n := range ms.Sizes {
+				if n > 0 {
+					sizes = append(sizes, fmt.Sprintf("<%s:%d", byteSize(1<<uint(i)), n))
+				}
+			}
+			perMin := float64(ms.Count) / elapsed.Minutes()
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%.1f\t%s\n", ethMsgName(code), ms.Count, ms.Bytes, perMin, strings.Join(sizes, " "))
+		}
+		tw.Flush()
+	}
+}
+
+func byteSize(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dKB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// observeMsg decodes msg into a one line summary and answers requests with
+// empty responses, the least a peer accepts without dropping us.
+func observeMsg(ws p2p.MsgWriter, msg p2p.Msg) (string, error) {
+	switch msg.Code {
+	case eth.NewBlockHashesMsg:
+		var hashes newBlockHashesData
+		if err := msg.Decode(&hashes); err != nil {
+			return "", err
+		}
+		var s []string
+		for _, h := range hashes {
+			s = append(s, fmt.Sprintf("#%d %s", h.Number, h.Hash.TerminalString()))
+		}
+		return strings.Join(s, ", "), nil
+	case eth.NewBlockMsg:
+		var nb newBlockData
+		if err := msg.Decode(&nb); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("#%d %s txs=%d uncles=%d td=%v", nb.Block.NumberU64(), nb.Block.Hash().TerminalString(), len(nb.Block.Transactions()), len(nb.Block.Uncles()), nb.TD), nil
+	case eth.TxMsg:
+		var txs []*types.Transaction
+		if err := msg.Decode(&txs); err != nil {
+			return "", err
+		}
+		if len(txs) == 0 {
+			return "0 txs", nil
+		}
+		return fmt.Sprintf("%d txs, first %s", len(txs), txs[0].Hash().TerminalString()), nil
+	case eth.GetBlockHeadersMsg:
+		var req getBlockHeadersData
+		if err := msg.Decode(&req); err != nil {
+			return "", err
+		}
+		var origin []byte
+		if err := rlp.DecodeBytes(req.Origin, &origin); err != nil {
+			return "", err
+		}
+		o := new(big.Int).SetBytes(origin).String()
+		if len(origin) == common.HashLength {
+			o = common.BytesToHash(origin).TerminalString()
+		}
+		return fmt.Sprintf("origin=%s amount=%d skip=%d reverse=%v", o, req.Amount, req.Skip, req.Reverse),
+			p2p.Send(ws, eth.BlockHeadersMsg, []rlp.RawValue{})
+	case eth.GetBlockBodiesMsg, eth.GetNodeDataMsg, eth.GetReceiptsMsg:
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d hashes", len(hashes)), p2p.Send(ws, msg.Code+1, []rlp.RawValue{})
+	}
+	return fmt.Sprintf("%d bytes", msg.Size), nil
+}
+
+// observeCmd represents the observe command
+var observeCmd = &cobra.Command{
+	Use:   "observe <enode...>",
+	Short: "Keep eth sessions open with enodes and report the traffic they send",
+	Long: `
+    Spins up a memory-backed p2p server, connects to the given enodes and, after the eth status handshake,
+    keeps the sessions open. Requests are answered with empty responses, the minimum to stay connected.
+    Every inbound message is logged with a decoded summary of NewBlockHashes, NewBlock, Tx and request messages.
+
+    Per peer counters and size histograms for each message code are printed every --interval seconds and at exit.
+    Dropped peers are redialed.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		nodes := mustEnodeArgs(args)
+		spec := mustChainSpec()
+		stats := newTrafficStats()
+
+		started := func(peer *p2p.Peer, version uint) {
+			stats.start(peer, version)
+			fmt.Println(time.Now().Format(time.RFC3339), peer.ID().TerminalString(), "session", peer.Name(), "eth", version)
+		}
+		handle := func(peer *p2p.Peer, ws p2p.MsgWriter, msg p2p.Msg) error {
+			stats.add(peer.ID(), msg.Code, msg.Size)
+			summary, err := observeMsg(ws, msg)
+			if err != nil {
+				return err
+			}
+			fmt.Println(time.Now().Format(time.RFC3339), peer.ID().TerminalString(), ethMsgName(msg.Code), summary)
+			return nil
+		}
+
+		lastFailure := runSessions(nodes, spec, sessionHandler{
+			started: started,
+			handle:  handle,
+			tick:    func() { stats.print(os.Stdout) },
+		})
+		stats.print(os.Stdout)
+
+		if stats.len() == 0 {
+			if lastFailure == nil {
+				lastFailure = errProbeTimeout
+			}
+			classify(lastFailure).exit()
+		}
+		succeeded(fmt.Sprintf("observed %d of %d peers", stats.len(), len(nodes))).exit()
+	},
+}
+
+func init() {
+	observeCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	observeCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	observeCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	observeCmd.PersistentFlags().IntVarP(&sessionDuration, "duration", "d", 0, "seconds to observe for (0 = until interrupted)")
+	observeCmd.PersistentFlags().IntVarP(&sessionInterval, "interval", "i", 60, "seconds between traffic statistics (0 = only at exit)")
+	rootCmd.AddCommand(observeCmd)
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/etclabscore/dp2p/policy"
+	"github.com/etclabscore/dp2p/socks5"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// outcomeKind classifies how talking to a remote node went.
+// Every command ends with an outcome, and exits with its code.
+type outcomeKind int
+
+const (
+	outcomeSuccess         outcomeKind = iota // exit 0
+	outcomeFailure                            // exit 1, anything not covered below (bad arguments, local errors)
+	outcomeUnreachable                        // exit 2, no route to the remote, or the dial timed out
+	outcomeRefused                            // exit 3, TCP connection refused
+	outcomeHandshakeFailed                    // exit 4, the RLPx encryption or devp2p protocol handshake failed
+	outcomeStatusMismatch                     // exit 5, the remote's status doesn't match ours (network, genesis, version, fork id)
+	outcomeTimeout                            // exit 6, no response in time
+	outcomeFiltered                           // exit 7, the network policy doesn't allow the node
+	outcomeDisconnected                       // exit discExitBase + reason, see p2p.DiscReason
+)
+
+// discExitBase is the exit code of a disconnect with reason 0 (disconnect requested).
+// A disconnect with reason r exits with discExitBase + r, e.g. 20 for too many peers.
+const discExitBase = 16
+
+var outcomeNames = map[outcomeKind]string{
+	outcomeSuccess:         "success",
+	outcomeFailure:         "failure",
+	outcomeUnreachable:     "unreachable",
+	outcomeRefused:         "refused",
+	outcomeHandshakeFailed: "handshake-failed",
+	outcomeStatusMismatch:  "status-mismatch",
+	outcomeTimeout:         "timeout",
+	outcomeFiltered:        "filtered",
+	outcomeDisconnected:    "disconnected",
+}
+
+func (k outcomeKind) String() string {
+	return outcomeNames[k]
+}
+
+// outcome is the result of a command. It doubles as an error, so failures can
+// be passed up as they are classified.
+type outcome struct {
+	Kind   outcomeKind
+	Reason p2p.DiscReason // only for outcomeDisconnected
+	Detail string
+}
+
+func (o *outcome) Error() string {
+	s := o.Kind.String()
+	if o.Kind == outcomeDisconnected {
+		s += ": " + o.Reason.String()
+	}
+	if o.Detail != "" {
+		s += ": " + o.Detail
+	}
+	return s
+}
+
+func (o *outcome) exitCode() int {
+	if o.Kind == outcomeDisconnected {
+		return discExitBase + int(o.Reason)
+	}
+	return int(o.Kind)
+}
+
+// fields renders the outcome as key=value pairs.
+func (o *outcome) fields() string {
+	fields := []string{"result=" + o.Kind.String(), fmt.Sprintf("exit=%d", o.exitCode())}
+	if o.Kind == outcomeDisconnected {
+		fields = append(fields, fmt.Sprintf("reason=%q", o.Reason.String()), fmt.Sprintf("code=%#x", uint(o.Reason)))
+	}
+	if o.Detail != "" {
+		fields = append(fields, fmt.Sprintf("detail=%q", o.Detail))
+	}
+	return strings.Join(fields, " ")
+}
+
+var (
+	exitHooksMu sync.Mutex
+	exitHooks   []func(o *outcome)
+)
+
+// atExit adds f to the functions run with the outcome before it's printed,
+// in the order they were added. Features print their summary lines and
+// flush their state in them.
+func atExit(f func(o *outcome)) {
+	exitHooksMu.Lock()
+	defer exitHooksMu.Unlock()
+	exitHooks = append(exitHooks, f)
+}
+
+// exit runs the atExit functions, prints the outcome as a structured line
+// followed by OK or FAIL, and exits with the outcome's code.
+func (o *outcome) exit() {
+	exitHooksMu.Lock()
+	hooks := exitHooks
+	exitHooksMu.Unlock()
+	for _, f := range hooks {
+		f(o)
+	}
+	fmt.Println(o.fields())
+	if o.Kind == outcomeSuccess {
+		fmt.Println("OK")
+	} else {
+		fmt.Println("FAIL")
+	}
+	os.Exit(o.exitCode())
+}
+
+func succeeded(detail string) *outcome {
+	return &outcome{Kind: outcomeSuccess, Detail: detail}
+}
+
+func disconnected(reason p2p.DiscReason) *outcome {
+	return &outcome{Kind: outcomeDisconnected, Reason: reason}
+}
+
+func statusMismatch(field string, ours, theirs interface{}) *outcome {
+	return &outcome{Kind: outcomeStatusMismatch, Detail: fmt.Sprintf("%s: ours %v, theirs %v", field, ours, theirs)}
+}
+
+// classify turns any error met while talking to a remote into an outcome.
+func classify(err error) *outcome {
+	switch err := err.(type) {
+	case nil:
+		return succeeded("")
+	case *outcome:
+		return err
+	case p2p.DiscReason:
+		return disconnected(err)
+	case *policy.Violation:
+		return &outcome{Kind: outcomeFiltered, Detail: err.Error()}
+	case *socks5.ReplyError:
+		switch err.Code {
+		case socks5.ReplyRefused:
+			return &outcome{Kind: outcomeRefused, Detail: err.Error()}
+		case socks5.ReplyNetUnreachable, socks5.ReplyHostUnreachable:
+			return &outcome{Kind: outcomeUnreachable, Detail: err.Error()}
+		case socks5.ReplyTTLExpired:
+			return &outcome{Kind: outcomeTimeout, Detail: err.Error()}
+		}
+	case *peerDropError:
+		if reason, ok := parseDiscReason(err.reason); ok {
+			return disconnected(reason)
+		}
+		return &outcome{Kind: outcomeDisconnected, Reason: p2p.DiscNetworkError, Detail: err.reason}
+	case *net.OpError:
+		if err.Op == "dial" {
+			if isRefused(err) {
+				return &outcome{Kind: outcomeRefused, Detail: err.Error()}
+			}
+			return &outcome{Kind: outcomeUnreachable, Detail: err.Error()}
+		}
+		if err.Timeout() {
+			return &outcome{Kind: outcomeTimeout, Detail: err.Error()}
+		}
+	}
+	if err == errProbeTimeout || discover.IsTimeout(err) {
+		return &outcome{Kind: outcomeTimeout, Detail: err.Error()}
+	}
+	return &outcome{Kind: outcomeFailure, Detail: err.Error()}
+}
+
+func isRefused(err *net.OpError) bool {
+	if serr, ok := err.Err.(*os.SyscallError); ok {
+		return serr.Err == syscall.ECONNREFUSED
+	}
+	return err.Err == syscall.ECONNREFUSED
+}
+
+// parseDiscReason reverses p2p.DiscReason.String. Peer events only carry
+// the text of the error a peer was dropped with.
+func parseDiscReason(s string) (p2p.DiscReason, bool) {
+	for r := p2p.DiscRequested; r <= p2p.DiscSubprotocolError; r++ {
+		if s == r.String() && s != "" {
+			return r, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,253 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/etclabscore/dp2p/forkid"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	partitionSeen      time.Duration
+	partitionThreshold float64
+)
+
+// chainOf names the chain of a node's eth status: the known chains with its
+// network id and genesis whose fork ids are compatible with its own, joined
+// with | if several are (e.g. mainnet and classic nodes without a fork id).
+// A fork hash none of them knows, e.g. of a fork newer than ours, doesn't
+// rule them out. Statuses of other chains are named by their network id and
+// genesis, and, if the genesis is known but the fork id conflicts with it,
+// their fork hash.
+func chainOf(s *crawldb.Status) string {
+	var names []string
+	for name, spec := range chainSpecs {
+		if spec.NetworkId == s.NetworkID && spec.Genesis == s.Genesis {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var id forkid.ID
+	hash, err := hex.DecodeString(s.ForkHash)
+	if s.ForkHash != "" && err == nil && len(hash) == len(id.Hash) {
+		copy(id.Hash[:], hash)
+		id.Next = s.ForkNext
+		compatible := names[:0]
+		for _, name := range names {
+			if verdict, _ := chainSpecs[name].forkFilter(forkHead)(id); verdict != forkid.Incompatible {
+				compatible = append(compatible, name)
+			}
+		}
+		if len(compatible) == 0 {
+			for _, name := range names {
+				if chainSpecs[name].knowsForkHash(id.Hash) {
+					return fmt.Sprintf("network %d genesis %x fork %#x", s.NetworkID, s.Genesis[:4], id.Hash)
+				}
+			}
+		} else {
+			names = compatible
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("network %d genesis %x", s.NetworkID, s.Genesis[:4])
+	}
+	return strings.Join(names, "|")
+}
+
+// chainGroup is the nodes of one chain.
+type chainGroup struct {
+	Chain     string
+	NetworkID uint64
+	Genesis   string
+	Nodes     int
+}
+
+// mustPartitionBootnodes returns the IDs of the bootnodes given as arguments,
+// or the chain's.
+func mustPartitionBootnodes(args []string, spec *chainSpec) []enode.ID {
+	urls := args
+	if len(urls) == 0 {
+		urls = spec.Bootnodes
+	}
+	var ids []enode.ID
+	for _, s := range urls {
+		id, err := parseNodeID(s)
+		if err != nil {
+			log.Println("bad bootnode", s, err)
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// partitionCmd represents the partition command
+var partitionCmd = &cobra.Command{
+	Use:   "partition [<bootnode id|enode...>]",
+	Short: "Group the crawled nodes by chain, and check which chains the bootnodes' neighbors are on",
+	Long: `
+    Every Ethereum-family chain shares the discovery network. partition classifies the nodes of the crawl database
+    (--db, by default ./crawl.db) that answered within --seen by the network id, genesis and fork id of their eth
+    status, as recorded by crawl --probe, census --probe or reach. Nodes are assigned to the known chains whose
+    fork ids are compatible with theirs; those without a fork id (before eth/64), or with a fork hash none of them
+    knows (e.g. of a newer fork), can't tell mainnet from classic. A fork id conflicting with the known chains of
+    its genesis is listed with its fork hash, other chains by network id and genesis hash.
+
+    Then, for the given bootnodes, or those of --chain, it classifies the neighbors each reported (edges recorded
+    by crawl or findnode --db within --seen), and flags a bootnode if more than --threshold of its neighbors
+    with a known chain are on other chains than --chain.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		db := mustCrawlDB()
+		spec := mustChainSpec()
+		bootnodes := mustPartitionBootnodes(args, spec)
+		var since time.Time
+		if partitionSeen > 0 {
+			since = time.Now().Add(-partitionSeen)
+		}
+
+		chains := make(map[enode.ID]string)
+		groups := make(map[string]*chainGroup)
+		var seen int
+		err := db.Nodes(func(n *crawldb.Node) bool {
+			if n.Status != nil {
+				chains[n.ID] = chainOf(n.Status)
+			}
+			if !seenSince(n, since) {
+				return true
+			}
+			seen++
+			chain, ok := chains[n.ID]
+			if !ok {
+				return true
+			}
+			g := groups[chain]
+			if g == nil {
+				g = &chainGroup{Chain: chain, NetworkID: n.Status.NetworkID, Genesis: fmt.Sprintf("%x", n.Status.Genesis[:8])}
+				groups[chain] = g
+			}
+			g.Nodes++
+			return true
+		})
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+
+		var classified int
+		list := make([]*chainGroup, 0, len(groups))
+		for _, g := range groups {
+			list = append(list, g)
+			classified += g.Nodes
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Nodes != list[j].Nodes {
+				return list[i].Nodes > list[j].Nodes
+			}
+			return list[i].Chain < list[j].Chain
+		})
+		fmt.Printf("nodes %d with status %d chains %d\n\n", seen, classified, len(list))
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "chain\tnetwork\tgenesis\tnodes\tshare")
+		for _, g := range list {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", g.Chain, g.NetworkID, g.Genesis, g.Nodes, percent(g.Nodes, classified))
+		}
+		if len(bootnodes) == 0 {
+			tw.Flush()
+			succeeded(fmt.Sprintf("%d chains, no bootnodes to check", len(list))).exit()
+		}
+		fmt.Fprintln(tw)
+
+		// Neighbors of the bootnodes, by chain.
+		neighbors := make(map[enode.ID]map[string]int)
+		for _, id := range bootnodes {
+			neighbors[id] = make(map[string]int)
+		}
+		err = db.Edges(since, func(e *crawldb.Edge) bool {
+			if counts, ok := neighbors[e.From]; ok {
+				chain, known := chains[e.To]
+				if !known {
+					chain = ""
+				}
+				counts[chain]++
+			}
+			return true
+		})
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		var flagged int
+		fmt.Fprintln(tw, "bootnode\taddr\tneighbors\twith status\tsame chain\tambiguous\tother\tother share\ttop other\tflag")
+		for _, id := range bootnodes {
+			var total, same, ambiguous, other, top int
+			var topChain string
+			for chain, n := range neighbors[id] {
+				total += n
+				switch {
+				case chain == "":
+				case chain == chainName:
+					same += n
+				case strings.Contains("|"+chain+"|", "|"+chainName+"|"):
+					ambiguous += n
+				default:
+					other += n
+					if n > top || n == top && chain < topChain {
+						top, topChain = n, chain
+					}
+				}
+			}
+			addr, verdict := "-", ""
+			if n := db.Node(id); n != nil {
+				if e := n.Endpoint(); e != nil {
+					addr = (&net.UDPAddr{IP: e.IP, Port: e.UDP}).String()
+				}
+			}
+			known := same + ambiguous + other
+			if known > 0 && float64(other) > partitionThreshold*float64(known) {
+				verdict = "POLLUTED"
+				flagged++
+			}
+			if topChain == "" {
+				topChain = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", id.TerminalString(), addr, total, known,
+				same, ambiguous, other, percent(other, known), topChain, verdict)
+		}
+		tw.Flush()
+		succeeded(fmt.Sprintf("%d chains, %d of %d bootnodes flagged", len(list), flagged, len(bootnodes))).exit()
+	},
+}
+
+func init() {
+	partitionCmd.PersistentFlags().DurationVar(&partitionSeen, "seen", 24*time.Hour, "count the nodes that answered, and the neighbors reported, within this duration (0 = all)")
+	partitionCmd.PersistentFlags().Float64Var(&partitionThreshold, "threshold", 0.5, "share of neighbors on other chains above which a bootnode is flagged")
+	partitionCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "classic", "chain the bootnodes belong to ("+chainNames()+")")
+	partitionCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	rootCmd.AddCommand(partitionCmd)
+}
@@ -17,6 +17,7 @@ package cmd
 import (
 	"fmt"
 	"github.com/etclabscore/dp2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"log"
 	"net"
 	"time"
@@ -39,17 +40,24 @@ var pingCmd = &cobra.Command{
 
 		tstart := time.Now()
 
-		err := <-u.SendPing(en.ID(), &net.UDPAddr{IP: en.IP(), Port: en.UDP()}, func() {
-			fmt.Println("pong", time.Since(tstart))
+		err := tryAddrs(en, func(a *enode.Node) error {
+			tstart = time.Now()
+			return <-u.SendPing(a.ID(), &net.UDPAddr{IP: a.IP(), Port: a.UDP()}, func() {
+				fmt.Println("pong", time.Since(tstart))
+			})
 		})
+		recordBond(en, err)
 		if err != nil {
-			log.Fatalln(err)
+			log.Println(err)
+			classify(err).exit()
 		}
+		succeeded("").exit()
 	},
 }
 
 func init() {
-	pingCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	pingCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	pingCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
 	pingCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "t", 500, "milliseconds for devp2p response timeout allowance")
 	rootCmd.AddCommand(pingCmd)
 
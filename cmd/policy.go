@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/etclabscore/dp2p/policy"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Network policy flags, see package policy for the rules.
+var (
+	policyFile    string
+	policyAllow   []string
+	policyDeny    []string
+	policyDenyIDs []string
+)
+
+var (
+	netPolicy     *policy.Policy
+	netPolicyOnce sync.Once
+)
+
+// mustPolicy returns the network policy of the --policy file and the rule flags.
+func mustPolicy() *policy.Policy {
+	netPolicyOnce.Do(func() {
+		netPolicy = new(policy.Policy)
+		if policyFile != "" {
+			p, err := policy.Load(policyFile)
+			if err != nil {
+				log.Println("bad --policy", err)
+				os.Exit(1)
+			}
+			netPolicy = p
+		}
+		for _, rules := range []struct {
+			verb   string
+			values []string
+		}{{"allow", policyAllow}, {"deny", policyDeny}, {"deny-id", policyDenyIDs}} {
+			for _, v := range rules.values {
+				if err := netPolicy.Add(rules.verb, v); err != nil {
+					log.Println("bad --"+rules.verb, err)
+					os.Exit(1)
+				}
+			}
+		}
+	})
+	return netPolicy
+}
+
+// applyPolicy checks n against the network policy. Of the addresses an enode
+// hostname resolved to, only allowed ones are kept. It returns the node to use,
+// or a *policy.Violation if none is allowed.
+func applyPolicy(n *enode.Node) (*enode.Node, error) {
+	p := mustPolicy()
+	h := resolvedHostOf(n)
+	if h == nil {
+		return n, p.CheckNode(n)
+	}
+	var (
+		allowed []*enode.Node
+		err     error
+	)
+	for _, a := range h.nodes {
+		if err = p.CheckNode(a); err != nil {
+			h.filtered = append(h.filtered, a)
+			continue
+		}
+		allowed = append(allowed, a)
+	}
+	if len(allowed) == 0 {
+		return n, err
+	}
+	h.nodes = allowed
+	return allowed[0], nil
+}
+
+// printFiltered prints a result line for an enode argument the policy doesn't allow.
+func printFiltered(n *enode.Node, err error) {
+	fmt.Println("enode="+n.ID().String(), classify(err).fields())
+}
@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	elog "github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// baseProtocolLength is the number of message codes reserved by devp2p itself.
+// Subprotocol message codes are offset past them.
+const baseProtocolLength = uint64(16)
+
+var errProbeTimeout = errors.New("timeout expired")
+
+// probeRetry makes probe keep waiting for a connection until its timeout when
+// a connection attempt fails, instead of reporting the failure right away.
+// The server redials the node every 30 seconds.
+var probeRetry bool
+
+// peerDropError is returned by probe if the peer was dropped before any of
+// the probing protocols reported a result.
+type peerDropError struct {
+	reason string
+}
+
+func (e *peerDropError) Error() string {
+	return "peer dropped: " + e.reason
+}
+
+// connFailure is a failed attempt to connect to a node.
+type connFailure struct {
+	id   enode.ID // zero if the server failed before learning it
+	addr string   // remote TCP address
+	err  error
+}
+
+// dialedConn is a connection failureDialer set up. Its remote address tells
+// which node it was dialed for, even when the address is the proxy's or one
+// the node's DNS name resolved to, so failures logged with it are attributed.
+type dialedConn struct {
+	net.Conn
+	id enode.ID
+}
+
+func (c dialedConn) RemoteAddr() net.Addr {
+	return dialedAddr{c.Conn.RemoteAddr(), c.id}
+}
+
+// dialedAddr is the remote address of a dialedConn.
+type dialedAddr struct {
+	net.Addr
+	id enode.ID
+}
+
+// failureDialer is a p2p.NodeDialer reporting the errors of failed dials.
+// Nodes given with a DNS name are dialed at all their addresses at once.
+// Addresses the network policy doesn't allow aren't dialed.
+type failureDialer struct {
+	p2p.NodeDialer
+	failc chan<- *connFailure
+}
+
+func (d failureDialer) Dial(n *enode.Node) (net.Conn, error) {
+	fd, err := dialAddrs(n, func(a *enode.Node) (net.Conn, error) {
+		if err := mustPolicy().CheckNode(a); err != nil {
+			return nil, err
+		}
+		return d.NodeDialer.Dial(a)
+	})
+	if err != nil {
+		reportFailure(d.failc, &connFailure{n.ID(), addrString(n), err})
+		return nil, err
+	}
+	return dialedConn{fd, n.ID()}, nil
+}
+
+// failureHandler passes log records on to h, reporting connections the
+// server failed to set up on failc. The server only logs these.
+func failureHandler(h elog.Handler, failc chan<- *connFailure) elog.Handler {
+	return elog.FuncHandler(func(r *elog.Record) error {
+		f := new(connFailure)
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			switch k, _ := r.Ctx[i].(string); k {
+			case "err":
+				f.err, _ = r.Ctx[i+1].(error)
+			case "id":
+				f.id, _ = r.Ctx[i+1].(enode.ID)
+			case "addr":
+				f.addr = fmt.Sprint(r.Ctx[i+1])
+				if a, ok := r.Ctx[i+1].(dialedAddr); ok {
+					f.id = a.id
+				}
+			}
+		}
+		switch r.Msg {
+		case "Failed RLPx handshake":
+			f.err = &outcome{Kind: outcomeHandshakeFailed, Detail: fmt.Sprint("encryption handshake: ", f.err)}
+			reportFailure(failc, f)
+		case "Wrong devp2p handshake identity":
+			f.err = &outcome{Kind: outcomeHandshakeFailed, Detail: "wrong identity in protocol handshake"}
+			reportFailure(failc, f)
+		case "Failed proto handshake", "Rejected peer before protocol handshake", "Rejected peer":
+			if _, ok := f.err.(p2p.DiscReason); !ok {
+				f.err = &outcome{Kind: outcomeHandshakeFailed, Detail: fmt.Sprint("protocol handshake: ", f.err)}
+			}
+			reportFailure(failc, f)
+		}
+		return h.Log(r)
+	})
+}
+
+func reportFailure(failc chan<- *connFailure, f *connFailure) {
+	select {
+	case failc <- f:
+	default:
+	}
+}
+
+// peerResult is what a probing protocol reports about a peer.
+type peerResult struct {
+	id  enode.ID
+	err error
+}
+
+// probe dials en from a fresh memory-backed server running the given protocols
+// and waits for the first result the protocols send on resCh.
+// Protocols must not block sending on resCh, since the server can only be
+// stopped once all of them have returned. Protocols have stopped by the time
+// probe returns.
+func probe(en *enode.Node, protocols []p2p.Protocol, resCh <-chan peerResult, timeout time.Duration) error {
+	return probeAll([]*enode.Node{en}, protocols, resCh, timeout)[en.ID()]
+}
+
+// probeAll is probe for many nodes at once. At most maxPendingPeers nodes are
+// probed at the same time, and no more than maxPeers are connected; the rest
+// wait their turn. The timeout applies to each node from the moment it is dialed.
+// It returns the result of every node.
+func probeAll(nodes []*enode.Node, protocols []p2p.Protocol, resCh <-chan peerResult, timeout time.Duration) map[enode.ID]error {
+	// Failures are reported without blocking the server. A node fails once
+	// before it's finished, later failures only matter with probeRetry, where
+	// they replace each other.
+	failc := make(chan *connFailure, len(nodes))
+	serv := mustStartServer(protocols, failc)
+	defer serv.Stop()
+
+	pEventCh := make(chan *p2p.PeerEvent)
+	pSub := serv.SubscribeEvents(pEventCh)
+	defer pSub.Unsubscribe()
+
+	var (
+		results     = make(map[enode.ID]error)
+		lastFailure = make(map[enode.ID]error)
+		pending     = make(map[enode.ID]*enode.Node)
+		timeouts    = make(map[enode.ID]*time.Timer)
+		timeoutc    = make(chan enode.ID, len(nodes))
+		connected   = make(map[enode.ID]bool)
+		queue       = nodes
+	)
+	finish := func(id enode.ID, err error) {
+		n, ok := pending[id]
+		if !ok {
+			return // already finished, or not a target
+		}
+		results[id] = err
+		delete(pending, id)
+		timeouts[id].Stop()
+		serv.RemovePeer(n)
+	}
+	fill := func() {
+		for len(queue) > 0 && len(pending) < maxPendingPeers && len(connected) < maxPeers {
+			n := queue[0]
+			queue = queue[1:]
+			if _, ok := results[n.ID()]; ok || pending[n.ID()] != nil {
+				continue // given twice
+			}
+			pending[n.ID()] = n
+			id := n.ID()
+			timeouts[id] = time.AfterFunc(timeout, func() { timeoutc <- id })
+			serv.AddPeer(n)
+		}
+	}
+	// drainResults takes in results the protocols have sent already.
+	drainResults := func() {
+		for {
+			select {
+			case r := <-resCh:
+				finish(r.id, r.err)
+			default:
+				return
+			}
+		}
+	}
+
+	fill()
+	for len(pending) > 0 || len(queue) > 0 {
+		select {
+		case r := <-resCh:
+			finish(r.id, r.err)
+		case ev := <-pEventCh:
+			log.Println(ev)
+			switch ev.Type {
+			case p2p.PeerEventTypeAdd:
+				connected[ev.Peer] = true
+			case p2p.PeerEventTypeDrop:
+				delete(connected, ev.Peer)
+				// Protocols report before they return, so a result may be
+				// waiting alongside the drop.
+				drainResults()
+				finish(ev.Peer, &peerDropError{ev.Error})
+			}
+		case f := <-failc:
+			id := f.id
+			log.Println("connection attempt failed:", id.TerminalString(), f.err)
+			if !probeRetry {
+				finish(id, f.err)
+			} else {
+				lastFailure[id] = f.err
+			}
+		case id := <-timeoutc:
+			if err := lastFailure[id]; err != nil {
+				finish(id, err)
+			} else {
+				finish(id, errProbeTimeout)
+			}
+		case err := <-pSub.Err():
+			err = fmt.Errorf("peer event sub error: %v", err)
+			for id := range pending {
+				finish(id, err)
+			}
+			for _, n := range queue {
+				results[n.ID()] = err
+			}
+			return results
+		}
+		fill()
+	}
+	return results
+}
+
+// sharedCap is a capability negotiated with a remote peer.
+type sharedCap struct {
+	p2p.Cap
+	Offset uint64 // first message code of the protocol on the wire
+	Length uint64 // number of message codes reserved for the protocol
+}
+
+// matchCaps computes the protocols shared with a remote advertising caps, just
+// like the p2p package does: for each name the highest common version wins, and
+// message code offsets are assigned in alphabetical order of names.
+func matchCaps(protocols []p2p.Protocol, caps []p2p.Cap) []sharedCap {
+	caps = append([]p2p.Cap{}, caps...)
+	sort.Slice(caps, func(i, j int) bool {
+		return caps[i].Name < caps[j].Name || (caps[i].Name == caps[j].Name && caps[i].Version < caps[j].Version)
+	})
+	var (
+		offset = baseProtocolLength
+		result = make(map[string]*sharedCap)
+		names  []string
+	)
+outer:
+	for _, cap := range caps {
+		for _, proto := range protocols {
+			if proto.Name == cap.Name && proto.Version == cap.Version {
+				// If an old protocol version matched, revert it
+				if old := result[cap.Name]; old != nil {
+					offset -= old.Length
+				} else {
+					names = append(names, cap.Name)
+				}
+				result[cap.Name] = &sharedCap{Cap: cap, Offset: offset, Length: proto.Length}
+				offset += proto.Length
+				continue outer
+			}
+		}
+	}
+	shared := make([]sharedCap, 0, len(names))
+	for _, name := range names {
+		shared = append(shared, *result[name])
+	}
+	return shared
+}
@@ -0,0 +1,290 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	propagationTxs      bool
+	propagationTxWindow time.Duration
+)
+
+// firstSeen records when each peer first announced a block or transaction hash.
+type firstSeen struct {
+	Number uint64 // blocks only
+	First  time.Time
+	By     map[enode.ID]time.Time
+}
+
+// propagationTracker records announcements of block and transaction hashes.
+// Transactions are forgotten once first announced more than txWindow ago.
+type propagationTracker struct {
+	mu       sync.Mutex
+	names    map[enode.ID]string
+	blocks   map[common.Hash]*firstSeen
+	txs      map[common.Hash]*firstSeen
+	txCount  int // all transactions seen, including the forgotten ones
+	txWindow time.Duration
+	expired  time.Time // when forgotten transactions were last dropped
+}
+
+func newPropagationTracker(txWindow time.Duration) *propagationTracker {
+	return &propagationTracker{
+		names:    make(map[enode.ID]string),
+		blocks:   make(map[common.Hash]*firstSeen),
+		txs:      make(map[common.Hash]*firstSeen),
+		txWindow: txWindow,
+		expired:  time.Now(),
+	}
+}
+
+func (t *propagationTracker) start(peer *p2p.Peer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.names[peer.ID()] = peer.Name()
+}
+
+// announce records that id announced hash, reporting whether it's new.
+// The caller must hold t.mu.
+func (t *propagationTracker) announce(set map[common.Hash]*firstSeen, id enode.ID, hash common.Hash, number uint64, at time.Time) bool {
+	fs, ok := set[hash]
+	if !ok {
+		fs = &firstSeen{Number: number, First: at, By: make(map[enode.ID]time.Time)}
+		set[hash] = fs
+	}
+	if _, ok := fs.By[id]; !ok {
+		fs.By[id] = at
+	}
+	return !ok
+}
+
+func (t *propagationTracker) announceBlock(id enode.ID, hash common.Hash, number uint64, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.announce(t.blocks, id, hash, number, at)
+}
+
+// announceTxs records the announcement of txs, and drops the transactions
+// first announced more than txWindow ago, at most once per txWindow. A zero
+// window keeps them all.
+func (t *propagationTracker) announceTxs(id enode.ID, txs []*types.Transaction, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tx := range txs {
+		if t.announce(t.txs, id, tx.Hash(), 0, at) {
+			t.txCount++
+		}
+	}
+	if t.txWindow == 0 || at.Sub(t.expired) < t.txWindow {
+		return
+	}
+	for h, fs := range t.txs {
+		if at.Sub(fs.First) > t.txWindow {
+			delete(t.txs, h)
+		}
+	}
+	t.expired = at
+}
+
+// handle records the announcements in msg and answers requests as observe does.
+func (t *propagationTracker) handle(peer *p2p.Peer, ws p2p.MsgWriter, msg p2p.Msg) error {
+	at := time.Now()
+	switch msg.Code {
+	case eth.NewBlockHashesMsg:
+		var hashes newBlockHashesData
+		if err := msg.Decode(&hashes); err != nil {
+			return err
+		}
+		for _, h := range hashes {
+			t.announceBlock(peer.ID(), h.Hash, h.Number, at)
+		}
+	case eth.NewBlockMsg:
+		var nb newBlockData
+		if err := msg.Decode(&nb); err != nil {
+			return err
+		}
+		t.announceBlock(peer.ID(), nb.Block.Hash(), nb.Block.NumberU64(), at)
+	case eth.TxMsg:
+		if !propagationTxs {
+			return nil
+		}
+		var txs []*types.Transaction
+		if err := msg.Decode(&txs); err != nil {
+			return err
+		}
+		t.announceTxs(peer.ID(), txs, at)
+	default:
+		_, err := observeMsg(ws, msg)
+		return err
+	}
+	return nil
+}
+
+// delays is a distribution of propagation delays.
+type delays []time.Duration
+
+func (d delays) percentile(p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	return d[int(p*float64(len(d)-1))]
+}
+
+func (d delays) String() string {
+	if len(d) == 0 {
+		return "-\t-\t-"
+	}
+	round := func(x time.Duration) time.Duration { return x.Round(time.Millisecond) }
+	return fmt.Sprintf("%v\t%v\t%v", round(d.percentile(0.5)), round(d.percentile(0.9)), round(d.percentile(1)))
+}
+
+// peerDelays returns, for each peer, how often it announced a hash of set
+// first and how long after the first announcement it announced each hash,
+// zero for those it announced first.
+func peerDelays(set map[common.Hash]*firstSeen) (map[enode.ID]int, map[enode.ID]delays) {
+	first, all := make(map[enode.ID]int), make(map[enode.ID]delays)
+	for _, fs := range set {
+		for id, at := range fs.By {
+			if at.Equal(fs.First) {
+				first[id]++
+			}
+			all[id] = append(all[id], at.Sub(fs.First))
+		}
+	}
+	return first, all
+}
+
+// printPeers prints the per-peer delay distributions.
+func (t *propagationTracker) printPeers(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]enode.ID, 0, len(t.names))
+	for id := range t.names {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return t.names[ids[i]] < t.names[ids[j]] })
+	blocksFirst, blocks := peerDelays(t.blocks)
+	txsFirst, txs := peerDelays(t.txs)
+
+	fmt.Fprintf(w, "%d blocks, %d txs (%d tracked) from %d peers\n", len(t.blocks), t.txCount, len(t.txs), len(t.names))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "peer\tname\tblocks\tfirst\tp50\tp90\tmax\ttxs\tfirst\tp50\tp90\tmax")
+	for _, id := range ids {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%v\t%d\t%d\t%v\n", id.TerminalString(), t.names[id],
+			len(blocks[id]), blocksFirst[id], blocks[id], len(txs[id]), txsFirst[id], txs[id])
+	}
+	tw.Flush()
+}
+
+// printBlocks prints the delay distribution of each block.
+func (t *propagationTracker) printBlocks(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hashes := make([]common.Hash, 0, len(t.blocks))
+	for h := range t.blocks {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return t.blocks[hashes[i]].First.Before(t.blocks[hashes[j]].First)
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "block\thash\tpeers\tfirst by\tp50\tp90\tmax")
+	for _, h := range hashes {
+		fs := t.blocks[h]
+		var (
+			d     delays
+			first enode.ID
+		)
+		for id, at := range fs.By {
+			if at.Equal(fs.First) {
+				first = id
+			}
+			d = append(d, at.Sub(fs.First))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d/%d\t%s\t%v\n", fs.Number, h.TerminalString(), len(fs.By), len(t.names), first.TerminalString(), d)
+	}
+	tw.Flush()
+}
+
+// propagationCmd represents the propagation command
+var propagationCmd = &cobra.Command{
+	Use:   "propagation <enode...>",
+	Short: "Measure how quickly enodes announce new blocks and transactions",
+	Long: `
+    Connects to all given enodes from one memory-backed p2p server, keeping eth sessions open as observe does,
+    and records when each peer first announces each block hash (NewBlockHashes or NewBlock) and transaction hash.
+
+    Delays are relative to the first announcement of a hash by any peer, so the first announcer's is zero.
+    Per-peer distributions (how often a peer was first, and the median, 90th percentile and maximum delay, zeros
+    included) are printed every --interval seconds, and per-block distributions at exit. Transactions are
+    forgotten once first announced more than --tx-window ago, so the transaction columns cover the recent ones.
+    The window has to be well above the slowest announcement, or late announcers count as first again.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		nodes := mustEnodeArgs(args)
+		spec := mustChainSpec()
+		tracker := newPropagationTracker(propagationTxWindow)
+
+		started := func(peer *p2p.Peer, version uint) {
+			tracker.start(peer)
+			fmt.Println(time.Now().Format(time.RFC3339), peer.ID().TerminalString(), "session", peer.Name(), "eth", version)
+		}
+		lastFailure := runSessions(nodes, spec, sessionHandler{
+			started: started,
+			handle:  tracker.handle,
+			tick:    func() { tracker.printPeers(os.Stdout) },
+		})
+		tracker.printBlocks(os.Stdout)
+		tracker.printPeers(os.Stdout)
+
+		if len(tracker.names) == 0 {
+			if lastFailure == nil {
+				lastFailure = errProbeTimeout
+			}
+			classify(lastFailure).exit()
+		}
+		succeeded(fmt.Sprintf("%d blocks, %d txs", len(tracker.blocks), tracker.txCount)).exit()
+	},
+}
+
+func init() {
+	propagationCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	propagationCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	propagationCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	propagationCmd.PersistentFlags().IntVarP(&sessionDuration, "duration", "d", 0, "seconds to measure for (0 = until interrupted)")
+	propagationCmd.PersistentFlags().IntVarP(&sessionInterval, "interval", "i", 60, "seconds between per-peer statistics (0 = only at exit)")
+	propagationCmd.PersistentFlags().BoolVar(&propagationTxs, "txs", true, "track transaction announcements too")
+	propagationCmd.PersistentFlags().DurationVar(&propagationTxWindow, "tx-window", 10*time.Minute, "how long transactions are tracked after their first announcement (0 = for the whole run)")
+	rootCmd.AddCommand(propagationCmd)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/dp2p/socks5"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// proxyURL is the SOCKS5 proxy (socks5://[user:password@]host:port) to dial
+// RLPx connections through. Connections are direct if it's empty.
+var proxyURL string
+
+var (
+	proxyDialer     *socks5.Dialer
+	proxyDialerOnce sync.Once
+
+	// egressMu guards egressAddrs, the addresses the proxy reported
+	// connecting to nodes from, and egressByNode, the last one for each node.
+	egressMu     sync.Mutex
+	egressAddrs  []string
+	egressByNode = make(map[enode.ID]string)
+)
+
+// mustProxy returns the dialer of --proxy, nil if it isn't set.
+func mustProxy() *socks5.Dialer {
+	proxyDialerOnce.Do(func() {
+		if proxyURL == "" {
+			return
+		}
+		d, err := socks5.Parse(proxyURL)
+		if err != nil {
+			log.Println("bad --proxy", err)
+			os.Exit(1)
+		}
+		proxyDialer = d
+		atExit(func(*outcome) { printEgress() })
+	})
+	return proxyDialer
+}
+
+// dialTCP connects to the TCP endpoint of n, through the proxy if one is set.
+func dialTCP(n *enode.Node, timeout time.Duration) (net.Conn, error) {
+	d := mustProxy()
+	if d == nil {
+		return net.DialTimeout("tcp", addrString(n), timeout)
+	}
+	fd, err := d.DialTimeout(addrString(n), timeout)
+	if err != nil {
+		return nil, err
+	}
+	egressMu.Lock()
+	s := fd.BoundAddr().String()
+	if !containsString(egressAddrs, s) {
+		egressAddrs = append(egressAddrs, s)
+	}
+	egressByNode[n.ID()] = s
+	egressMu.Unlock()
+	return fd, nil
+}
+
+// proxyNodeDialer is the p2p.NodeDialer of the server, dialing with dialTCP.
+type proxyNodeDialer struct {
+	timeout time.Duration
+}
+
+func (d proxyNodeDialer) Dial(n *enode.Node) (net.Conn, error) {
+	return dialTCP(n, d.timeout)
+}
+
+// egressOf returns the address the proxy connected to the node from, empty
+// if it wasn't dialed through the proxy.
+func egressOf(id enode.ID) string {
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	return egressByNode[id]
+}
+
+// printEgress prints the proxy connections went through and the addresses
+// it connected from. Nothing is printed for direct connections.
+func printEgress() {
+	d := mustProxy()
+	if d == nil {
+		return
+	}
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	bound := egressAddrs
+	if len(bound) == 0 {
+		bound = []string{"-"}
+	}
+	fmt.Printf("egress=socks5://%s bound=%s\n", d.ProxyAddr, strings.Join(bound, ","))
+}
@@ -0,0 +1,332 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/etclabscore/dp2p/rlpx"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+// reachReport holds the outcome of each reachability stage for one enode.
+// A nil outcome means the stage wasn't attempted.
+type reachReport struct {
+	Ping     *outcome // UDP ping
+	Findnode *outcome // UDP findnode, after the bond
+	TCP      *outcome // TCP connect
+	RLPx     *outcome // RLPx encryption and protocol handshakes
+	Status   *outcome // eth status exchange
+
+	Hello  *rlpx.ProtoHandshake // the remote's hello, if the protocol handshake succeeded
+	Theirs interface{}          // the remote's eth status, if it sent one
+}
+
+func (r *reachReport) stages() []*outcome {
+	return []*outcome{r.Ping, r.Findnode, r.TCP, r.RLPx, r.Status}
+}
+
+// result is the first failed stage, or success if none failed.
+func (r *reachReport) result() *outcome {
+	for _, o := range r.stages() {
+		if o != nil && o.Kind != outcomeSuccess {
+			return o
+		}
+	}
+	return succeeded(strings.Join(r.diagnose(), "; "))
+}
+
+func isOK(o *outcome) bool {
+	return o != nil && o.Kind == outcomeSuccess
+}
+
+// diagnose names the misconfigurations the stages point at.
+func (r *reachReport) diagnose() []string {
+	var d []string
+	switch {
+	case r.Ping == nil:
+		d = append(d, "no discovery port advertised")
+	case !isOK(r.Ping) && !isOK(r.TCP):
+		if r.TCP.Kind == outcomeRefused {
+			d = append(d, "UDP unanswered, TCP port closed")
+		} else {
+			d = append(d, "host unreachable (down, or both ports firewalled)")
+		}
+		return d
+	case !isOK(r.Ping):
+		d = append(d, "UDP firewalled, TCP open")
+	case !isOK(r.TCP):
+		if r.TCP.Kind == outcomeRefused {
+			d = append(d, "UDP open, TCP port closed (discovery only, or wrong TCP port)")
+		} else {
+			d = append(d, "UDP open, TCP firewalled")
+		}
+	case !isOK(r.Findnode):
+		d = append(d, "UDP findnode unanswered (bond failed: the remote can't reach our UDP endpoint, or its table is empty)")
+	}
+	switch {
+	case !isOK(r.TCP):
+		if r.Ping == nil {
+			d = append(d, "TCP "+r.TCP.Kind.String())
+		}
+	case r.RLPx.Kind == outcomeHandshakeFailed:
+		d = append(d, "TCP open but RLPx handshake failed (wrong node key, or not a devp2p port)")
+	case r.RLPx.Kind == outcomeDisconnected:
+		d = append(d, "RLPx rejected: "+r.RLPx.Reason.String())
+	case !isOK(r.RLPx):
+		d = append(d, "RLPx "+r.RLPx.Error())
+	case r.Status.Kind == outcomeStatusMismatch:
+		d = append(d, "eth status mismatch: "+r.Status.Detail)
+	case !isOK(r.Status):
+		d = append(d, "eth status failed: "+r.Status.Error())
+	}
+	if len(d) == 0 {
+		d = append(d, "reachable")
+	}
+	return d
+}
+
+// cell renders a stage outcome for the reach table.
+func cell(o *outcome) string {
+	switch {
+	case o == nil:
+		return "-"
+	case o.Kind == outcomeSuccess:
+		return strings.TrimSpace("ok " + o.Detail)
+	case o.Kind == outcomeDisconnected:
+		return o.Reason.String()
+	}
+	return o.Kind.String()
+}
+
+// reach tests the discovery endpoint and the RLPx port of en independently,
+// using the same node key for both.
+func reach(u *discover.Dual, key *ecdsa.PrivateKey, spec *chainSpec, en *enode.Node, timeout time.Duration) *reachReport {
+	r := &reachReport{}
+	if en.UDP() != 0 {
+		var (
+			addr *net.UDPAddr
+			rtt  time.Duration
+		)
+		// Findnode goes to the first address that answered.
+		err := tryAddrs(en, func(a *enode.Node) error {
+			to := &net.UDPAddr{IP: a.IP(), Port: a.UDP()}
+			tstart := time.Now()
+			var d time.Duration
+			err := <-u.SendPing(en.ID(), to, func() { d = time.Since(tstart) })
+			if err == nil && addr == nil {
+				addr, rtt = to, d
+			}
+			return err
+		})
+		r.Ping = classify(err)
+		if err == nil {
+			r.Ping.Detail = rtt.Round(time.Millisecond).String()
+			nodes, err := u.Findnode(en.ID(), addr, en.Pubkey())
+			r.Findnode = classify(err)
+			if err == nil {
+				r.Findnode.Detail = fmt.Sprintf("%d nodes", len(nodes))
+			}
+		}
+	}
+
+	reachTCP(r, key, spec, en, timeout)
+	return r
+}
+
+// reachTCP runs the TCP stages of reach: connect, RLPx handshakes and the eth status exchange.
+func reachTCP(r *reachReport, key *ecdsa.PrivateKey, spec *chainSpec, en *enode.Node, timeout time.Duration) {
+	tstart := time.Now()
+	fd, err := dialAddrs(en, func(a *enode.Node) (net.Conn, error) {
+		return dialTCP(a, timeout)
+	})
+	r.TCP = classify(err)
+	if err != nil {
+		return
+	}
+	r.TCP.Detail = time.Since(tstart).Round(time.Millisecond).String()
+	conn := rlpx.NewConn(fd)
+	defer conn.Close(p2p.DiscQuitting)
+
+	if _, err := conn.DoEncHandshake(key, en.Pubkey()); err != nil {
+		r.RLPx = &outcome{Kind: outcomeHandshakeFailed, Detail: fmt.Sprint("encryption handshake: ", err)}
+		return
+	}
+	caps := []p2p.Cap{{Name: eth.ProtocolName, Version: eth64}, {Name: eth.ProtocolName, Version: eth.ProtocolVersions[0]}}
+	their, err := conn.DoProtoHandshake(&rlpx.ProtoHandshake{
+		Version: rlpx.BaseProtocolVersion,
+		Name:    "dp2p",
+		Caps:    caps,
+		ID:      crypto.FromECDSAPub(&key.PublicKey)[1:],
+	})
+	if reason, ok := err.(p2p.DiscReason); ok {
+		r.RLPx = disconnected(reason)
+		return
+	}
+	if err != nil {
+		r.RLPx = &outcome{Kind: outcomeHandshakeFailed, Detail: fmt.Sprint("protocol handshake: ", err)}
+		return
+	}
+	r.RLPx = succeeded(their.Name)
+	r.Hello = their
+
+	var version uint
+	for _, c := range their.Caps {
+		for _, p := range caps {
+			if c == p && p.Version > version {
+				version = p.Version
+			}
+		}
+	}
+	if version == 0 {
+		r.Status = statusMismatch("caps", caps, their.Caps)
+		return
+	}
+	// eth is the only capability we share, so its messages come right after the base protocol's.
+	theirs, err := exchangeStatus(&subprotoConn{conn, rlpx.BaseProtocolLength}, spec, version)
+	if err == nil {
+		r.Theirs = theirs
+		err = checkStatus(spec, version, theirs)
+	}
+	r.Status = classify(err)
+	if err == nil {
+		r.Status.Detail = fmt.Sprintf("eth/%d", version)
+	}
+}
+
+// subprotoConn runs one subprotocol directly on an RLPx connection, shifting
+// message codes by its offset. Base protocol pings are answered and a
+// disconnect is returned as the p2p.DiscReason error.
+type subprotoConn struct {
+	conn   *rlpx.Conn
+	offset uint64
+}
+
+func (c *subprotoConn) ReadMsg() (p2p.Msg, error) {
+	for {
+		msg, err := c.conn.ReadMsg()
+		if err != nil {
+			return msg, err
+		}
+		switch {
+		case msg.Code == rlpx.DiscMsg:
+			return msg, rlpx.DecodeDisconnect(msg)
+		case msg.Code == rlpx.PingMsg:
+			msg.Discard()
+			go p2p.SendItems(c.conn, rlpx.PongMsg)
+		case msg.Code < c.offset:
+			msg.Discard()
+		default:
+			msg.Code -= c.offset
+			return msg, nil
+		}
+	}
+}
+
+func (c *subprotoConn) WriteMsg(msg p2p.Msg) error {
+	msg.Code += c.offset
+	return c.conn.WriteMsg(msg)
+}
+
+// reachCmd represents the reach command
+var reachCmd = &cobra.Command{
+	Use:   "reach <enode...>",
+	Short: "Test an enode's UDP discovery and TCP RLPx endpoints independently",
+	Long: `
+    Tests the discovery endpoint (the enode's UDP port, or discport) and the RLPx TCP port of each enode separately,
+    from one node key: UDP ping, UDP findnode after bonding, TCP connect, the RLPx encryption and protocol handshakes,
+    and the eth status exchange. Each stage is a column of a table, followed by a diagnosis of common
+    misconfigurations, e.g. "UDP firewalled, TCP open".
+
+    A single enode exits with the outcome of its first failed stage. Many enodes each get a result line,
+    and the command succeeds if all of them are reachable.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		nodes := mustEnodeArgs(args)
+		spec := mustChainSpec()
+
+		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		u := mustUdpConfig(discover.Config{PrivateKey: key})
+
+		reports := make([]*reachReport, len(nodes))
+		sem := make(chan struct{}, maxPendingPeers)
+		var wg sync.WaitGroup
+		for i, n := range nodes {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, n *enode.Node) {
+				defer wg.Done()
+				reports[i] = reach(u, key, spec, n, time.Duration(int32(connectTimeout))*time.Second)
+				recordReach(n, reports[i])
+				<-sem
+			}(i, n)
+		}
+		wg.Wait()
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "enode\tudp ping\tfindnode\ttcp\trlpx\tstatus\tdiagnosis")
+		for i, r := range reports {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", nodes[i].ID().TerminalString(),
+				cell(r.Ping), cell(r.Findnode), cell(r.TCP), cell(r.RLPx), cell(r.Status), strings.Join(r.diagnose(), "; "))
+		}
+		tw.Flush()
+
+		if len(nodes) == 1 {
+			reports[0].result().exit()
+		}
+		var failed int
+		for i, r := range reports {
+			o := r.result()
+			if o.Kind != outcomeSuccess {
+				failed++
+			}
+			printResult(nodes[i], o)
+		}
+		if failed > 0 {
+			(&outcome{Kind: outcomeFailure, Detail: fmt.Sprintf("%d of %d enodes not reachable", failed, len(nodes))}).exit()
+		}
+		succeeded(fmt.Sprintf("%d enodes", len(nodes))).exit()
+	},
+}
+
+func init() {
+	reachCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	reachCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
+	reachCmd.PersistentFlags().IntVarP(&connectTimeout, "timeout", "t", 10, "time in seconds to wait for the TCP connection")
+	reachCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 500, "milliseconds for devp2p response timeout allowance")
+	reachCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 50, "maximum number of enodes tested at once")
+	reachCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	reachCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	rootCmd.AddCommand(reachCmd)
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/dp2p/crawldb"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// dbPath is the crawl database (see package crawldb) probe results are
+// recorded in. Nothing is recorded if it's empty.
+var dbPath string
+
+var (
+	nodeDB     *crawldb.DB
+	nodeDBOnce sync.Once
+)
+
+// probeKind is the name of the running command, the kind of probe its
+// results are recorded as.
+var probeKind string
+
+var (
+	// probeTargets are the enode arguments of the running command.
+	probeTargets []*enode.Node
+
+	// probesMu guards probesRecorded, the targets whose results were
+	// recorded before the command exits.
+	probesMu       sync.Mutex
+	probesRecorded = make(map[enode.ID]bool)
+)
+
+// mustDB returns the database of --db, nil if it isn't set.
+func mustDB() *crawldb.DB {
+	nodeDBOnce.Do(func() {
+		if dbPath == "" {
+			return
+		}
+		db, err := crawldb.Open(dbPath)
+		if err != nil {
+			log.Println("failed to open --db", err)
+			os.Exit(1)
+		}
+		nodeDB = db
+		atExit(func(o *outcome) {
+			recordExit(o)
+			closeDB()
+		})
+	})
+	return nodeDB
+}
+
+// mustCrawlDB is mustDB, opening ./crawl.db if --db isn't set.
+func mustCrawlDB() *crawldb.DB {
+	if dbPath == "" {
+		dbPath = "crawl.db"
+	}
+	return mustDB()
+}
+
+// closeDB flushes the database, if one is open.
+func closeDB() {
+	if nodeDB != nil {
+		nodeDB.Close()
+	}
+}
+
+// recordNode applies f to the record of n and notes its endpoint.
+func recordNode(n *enode.Node, f func(rec *crawldb.Node)) {
+	db := mustDB()
+	if db == nil {
+		return
+	}
+	now := time.Now()
+	err := db.UpdateNode(n.ID(), func(rec *crawldb.Node) {
+		rec.Saw(n, now)
+		if f != nil {
+			f(rec)
+		}
+	})
+	if err != nil {
+		log.Println("failed to record node", err)
+	}
+}
+
+// recordProbe logs the outcome of probing n, of the given kind. A node
+// that answered is seen now.
+func recordProbe(kind string, n *enode.Node, o *outcome) {
+	db := mustDB()
+	if db == nil {
+		return
+	}
+	probesMu.Lock()
+	probesRecorded[n.ID()] = true
+	probesMu.Unlock()
+
+	now := time.Now()
+	err := db.AddProbe(&crawldb.Probe{
+		Time:     now,
+		ID:       n.ID(),
+		Kind:     kind,
+		Addr:     addrString(n),
+		Result:   o.Kind.String(),
+		Exit:     o.exitCode(),
+		Detail:   o.Detail,
+		Answered: answered(o),
+		Egress:   egressOf(n.ID()),
+	})
+	if err != nil {
+		log.Println("failed to record probe", err)
+	}
+	recordNode(n, func(rec *crawldb.Node) {
+		if answered(o) {
+			rec.Answered(now)
+		}
+	})
+}
+
+// answered reports whether the node got as far as talking to us.
+func answered(o *outcome) bool {
+	switch o.Kind {
+	case outcomeSuccess, outcomeStatusMismatch, outcomeDisconnected:
+		return true
+	}
+	return false
+}
+
+// recordExit records the result of a command run on a single enode,
+// unless the command recorded it already.
+func recordExit(o *outcome) {
+	if len(probeTargets) != 1 || o.Kind == outcomeFiltered {
+		return
+	}
+	n := probeTargets[0]
+	probesMu.Lock()
+	done := probesRecorded[n.ID()]
+	probesMu.Unlock()
+	if !done {
+		recordProbe(probeKind, n, o)
+	}
+}
+
+// printResult prints the result line of one of many enodes, and records it.
+func printResult(n *enode.Node, o *outcome) {
+	line := "enode=" + n.ID().String()
+	if e := egressOf(n.ID()); e != "" {
+		line += " egress=" + e
+	}
+	fmt.Println(line, o.fields())
+	recordProbe(probeKind, n, o)
+}
+
+// recordNeighbors notes the neighbors n reported, as edges of the neighbor graph.
+func recordNeighbors(n *enode.Node, neighbors []*enode.Node) {
+	db := mustDB()
+	if db == nil {
+		return
+	}
+	ids := make([]enode.ID, len(neighbors))
+	for i, nb := range neighbors {
+		recordNode(nb, nil)
+		ids[i] = nb.ID()
+	}
+	if err := db.AddEdges(n.ID(), ids, time.Now()); err != nil {
+		log.Println("failed to record neighbors", err)
+	}
+}
+
+// recordBond notes the outcome of pinging n.
+func recordBond(n *enode.Node, err error) {
+	recordNode(n, func(rec *crawldb.Node) {
+		if err == nil {
+			rec.Bonded = time.Now()
+			rec.BondFails = 0
+		} else {
+			rec.BondFails++
+		}
+	})
+}
+
+// recordReach notes the hello and status a reach report learned.
+func recordReach(n *enode.Node, r *reachReport) {
+	if r.Hello == nil {
+		return
+	}
+	recordNode(n, func(rec *crawldb.Node) {
+		rec.SawClient(r.Hello.Name, time.Now())
+		rec.Caps = rec.Caps[:0]
+		for _, c := range r.Hello.Caps {
+			rec.Caps = append(rec.Caps, c.String())
+		}
+		if status := statusRecord(r.Theirs); status != nil {
+			rec.Status = status
+		}
+	})
+}
+
+// statusRecord converts an eth status to its database form.
+func statusRecord(theirs interface{}) *crawldb.Status {
+	switch status := theirs.(type) {
+	case *statusData:
+		return &crawldb.Status{
+			ProtocolVersion: status.ProtocolVersion,
+			NetworkID:       status.NetworkId,
+			TD:              status.TD,
+			Head:            status.CurrentBlock,
+			Genesis:         status.GenesisBlock,
+			Time:            time.Now(),
+		}
+	case *statusData64:
+		return &crawldb.Status{
+			ProtocolVersion: status.ProtocolVersion,
+			NetworkID:       status.NetworkId,
+			TD:              status.TD,
+			Head:            status.CurrentBlock,
+			Genesis:         status.GenesisBlock,
+			ForkHash:        fmt.Sprintf("%x", status.ForkID.Hash),
+			ForkNext:        status.ForkID.Next,
+			Time:            time.Now(),
+		}
+	}
+	return nil
+}
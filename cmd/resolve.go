@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// dnsResolver is the DNS server (host:port) resolving enode hostnames.
+// The system resolver is used if it's empty.
+var dnsResolver string
+
+const resolveTimeout = 10 * time.Second
+
+// resolvedHost is an enode given with a DNS name instead of an IP.
+type resolvedHost struct {
+	host     string
+	nodes    []*enode.Node // one per resolved address
+	filtered []*enode.Node // not allowed by the network policy
+
+	mu      sync.Mutex
+	results map[string]*outcome // by endpoint, for the endpoints probed
+	pending sync.WaitGroup      // dials whose result isn't recorded yet
+}
+
+// record keeps the result of probing an endpoint, see endpoint.
+func (h *resolvedHost) record(ep string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.results == nil {
+		h.results = make(map[string]*outcome)
+	}
+	if o, ok := h.results[ep]; ok && o.Kind == outcomeSuccess {
+		return
+	}
+	h.results[ep] = classify(err)
+}
+
+// resolvedKey identifies an enode given with a DNS name. The same node ID
+// may be given under several names.
+type resolvedKey struct {
+	id   enode.ID
+	host string
+}
+
+// resolvedHosts holds the enodes given with DNS names. It's filled while
+// parsing arguments, only the probe results change later.
+var resolvedHosts = make(map[resolvedKey]*resolvedHost)
+
+// resolvedHostOf returns the name n was resolved from, nil if it was given
+// with an IP.
+func resolvedHostOf(n *enode.Node) *resolvedHost {
+	for k, h := range resolvedHosts {
+		if k.id != n.ID() {
+			continue
+		}
+		for _, a := range append(h.nodes, h.filtered...) {
+			if a == n {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// parseEnode parses an enode URL like enode.ParseV4, but also accepts a DNS
+// name for the host. The name is resolved to all its A and AAAA records, and
+// the node with the first address is returned. Commands probe all of them,
+// see tryAddrs and dialAddrs.
+func parseEnode(rawurl string) (*enode.Node, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "enode" || u.Hostname() == "" || net.ParseIP(u.Hostname()) != nil {
+		return enode.ParseV4(rawurl)
+	}
+	ips, err := lookupIP(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	h := &resolvedHost{host: u.Hostname()}
+	for _, ip := range ips {
+		resolved := *u
+		resolved.Host = net.JoinHostPort(ip.String(), u.Port())
+		n, err := enode.ParseV4(resolved.String())
+		if err != nil {
+			return nil, err
+		}
+		h.nodes = append(h.nodes, n)
+	}
+	key := resolvedKey{h.nodes[0].ID(), h.host}
+	if prev := resolvedHosts[key]; prev != nil {
+		return prev.nodes[0], nil
+	}
+	if len(resolvedHosts) == 0 {
+		atExit(func(*outcome) { printResolved() })
+	}
+	resolvedHosts[key] = h
+	return h.nodes[0], nil
+}
+
+func lookupIP(host string) ([]net.IP, error) {
+	r := net.DefaultResolver
+	if dnsResolver != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, dnsResolver)
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// readEnodeList reads a list file: one enode URL per line, blank lines
+// and lines starting with # are skipped.
+func readEnodeList(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var urls []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+// tryAddrs calls f with every address n resolved to and keeps the result
+// for each UDP endpoint. Nodes given with an IP have just the one. It returns
+// nil if any address succeeded, the last error otherwise.
+func tryAddrs(n *enode.Node, f func(a *enode.Node) error) error {
+	h := resolvedHostOf(n)
+	if h == nil {
+		return f(n)
+	}
+	var lastErr error
+	ok := false
+	for _, a := range h.nodes {
+		err := f(a)
+		h.record(endpoint("udp", a), err)
+		if err == nil {
+			ok = true
+			continue
+		}
+		lastErr = err
+		if len(h.nodes) > 1 {
+			log.Println(h.host, endpoint("udp", a), "failed:", err)
+		}
+	}
+	if ok {
+		return nil
+	}
+	return lastErr
+}
+
+// dialAddrs dials n over TCP at every address it resolved to at once, and
+// keeps the result for each TCP endpoint. It returns the first connection
+// made; the others are closed as they come in.
+func dialAddrs(n *enode.Node, dial func(a *enode.Node) (net.Conn, error)) (net.Conn, error) {
+	h := resolvedHostOf(n)
+	if h == nil {
+		return dial(n)
+	}
+	type dialed struct {
+		fd  net.Conn
+		err error
+	}
+	dialc := make(chan dialed, len(h.nodes))
+	for _, a := range h.nodes {
+		h.pending.Add(1)
+		go func(a *enode.Node) {
+			defer h.pending.Done()
+			fd, err := dial(a)
+			h.record(endpoint("tcp", a), err)
+			if err != nil && len(h.nodes) > 1 {
+				log.Println(h.host, endpoint("tcp", a), "failed:", err)
+			}
+			dialc <- dialed{fd, err}
+		}(a)
+	}
+	var err error
+	for left := len(h.nodes); left > 0; left-- {
+		d := <-dialc
+		if d.err != nil {
+			err = d.err
+			continue
+		}
+		go func(left int) {
+			for ; left > 0; left-- {
+				if d := <-dialc; d.fd != nil {
+					d.fd.Close()
+				}
+			}
+		}(left - 1)
+		return d.fd, nil
+	}
+	return nil, err
+}
+
+func addrString(n *enode.Node) string {
+	return (&net.TCPAddr{IP: n.IP(), Port: n.TCP()}).String()
+}
+
+// endpoint names the UDP or TCP endpoint of n, e.g. 1.2.3.4:30303/udp.
+func endpoint(network string, n *enode.Node) string {
+	if network == "udp" {
+		return (&net.UDPAddr{IP: n.IP(), Port: n.UDP()}).String() + "/udp"
+	}
+	return addrString(n) + "/tcp"
+}
+
+// printResolved prints the addresses each enode hostname resolved to,
+// which of their endpoints answered and how probing the others went.
+// It waits for the dials still under way.
+func printResolved() {
+	for k, h := range resolvedHosts {
+		h.pending.Wait()
+		var addrs, answered, failed, filtered []string
+		h.mu.Lock()
+		for _, n := range h.nodes {
+			addrs = append(addrs, addrString(n))
+			for _, network := range []string{"udp", "tcp"} {
+				ep := endpoint(network, n)
+				switch o := h.results[ep]; {
+				case o == nil:
+				case o.Kind == outcomeSuccess:
+					answered = append(answered, ep)
+				default:
+					failed = append(failed, ep+"/"+o.Kind.String())
+				}
+			}
+		}
+		h.mu.Unlock()
+		for _, n := range h.filtered {
+			filtered = append(filtered, addrString(n))
+		}
+		if len(answered) == 0 {
+			answered = []string{"-"}
+		}
+		line := fmt.Sprintf("enode=%s host=%s addrs=%s answered=%s", k.id, h.host, strings.Join(addrs, ","), strings.Join(answered, ","))
+		if len(failed) > 0 {
+			line += " failed=" + strings.Join(failed, ",")
+		}
+		if len(filtered) > 0 {
+			line += " filtered=" + strings.Join(filtered, ",")
+		}
+		fmt.Println(line)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -33,6 +33,14 @@ var rootCmd = &cobra.Command{
 	Long: `
   Tools for simple interaction and queries on the devp2p protocol.
 `,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		probeKind = cmd.Name()
+		if dbPath != "" {
+			// Opened now, so the command's result is recorded even if it
+			// exits before recording anything.
+			mustDB()
+		}
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -58,6 +66,14 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dp2p")
+	rootCmd.PersistentFlags().StringVar(&dnsResolver, "resolver", "", "DNS server (host:port) to resolve enode hostnames with (default: the system's)")
+	rootCmd.PersistentFlags().StringVar(&dbPath, "db", "", "crawl database directory to record probe results in")
+	rootCmd.PersistentFlags().StringSliceVar(&geoipPaths, "geoip", nil, "MaxMind DB (mmdb) files to annotate node addresses from, e.g. GeoLite2-City.mmdb,GeoLite2-ASN.mmdb (hosting providers need GeoIP2-Anonymous-IP.mmdb)")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "SOCKS5 proxy to dial RLPx connections through (socks5://[user:password@]host:port)")
+	rootCmd.PersistentFlags().StringVar(&policyFile, "policy", "", "network policy file, one rule per line (allow <cidr>, deny <cidr|lan|loopback>, deny-id <id>)")
+	rootCmd.PersistentFlags().StringSliceVar(&policyAllow, "allow", nil, "only talk to nodes in these networks (CIDRs)")
+	rootCmd.PersistentFlags().StringSliceVar(&policyDeny, "deny", nil, "never talk to nodes in these networks (CIDRs, lan, loopback)")
+	rootCmd.PersistentFlags().StringSliceVar(&policyDenyIDs, "deny-id", nil, "never talk to these nodes (node IDs, public keys or enode URLs)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,215 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveNodeKey   string
+	serveDiscovery bool
+	serveBootnodes []string
+	serveNAT       string
+	serveKeep      bool
+)
+
+// mustNodeKey loads the node key from file, creating the file if it doesn't
+// exist yet, so the enode stays the same across runs.
+// Without a file, a fresh key is used.
+func mustNodeKey(file string) *ecdsa.PrivateKey {
+	if file == "" {
+		key, _ := crypto.GenerateKey()
+		return key
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		key, _ := crypto.GenerateKey()
+		if err := crypto.SaveECDSA(file, key); err != nil {
+			log.Println("failed to save node key", err)
+			os.Exit(1)
+		}
+		return key
+	}
+	key, err := crypto.LoadECDSA(file)
+	if err != nil {
+		log.Println("failed to load node key", err)
+		os.Exit(1)
+	}
+	return key
+}
+
+func mustBootnodes(spec *chainSpec) []*enode.Node {
+	urls := serveBootnodes
+	if len(urls) == 0 {
+		urls = spec.Bootnodes
+	}
+	var nodes []*enode.Node
+	for _, url := range urls {
+		n, err := parseEnode(url)
+		if err != nil {
+			log.Println("malformed bootnode", url, err)
+			os.Exit(1)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Accept inbound connections and record who dials us",
+	Long: `
+    Runs a p2p server speaking eth/64 and eth/63 that never dials out, and accepts inbound connections.
+    For each dialer it logs the enode, client name, capabilities, eth status and, once it's gone, the session
+    duration and disconnect reason. Dialers are disconnected politely after the status exchange, or kept
+    connected with --keep (requests are answered with empty responses, as observe does).
+
+    With --discovery, the node takes part in discovery (using the chain's bootnodes unless --bootnodes are given),
+    so other nodes can find it. Use --nodekey to keep the same enode across runs.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		spec := mustChainSpec()
+
+		serveRun := func(version uint) func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+			return func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+				id := peer.ID().TerminalString()
+				if addr, ok := peer.RemoteAddr().(*net.TCPAddr); ok {
+					if err := mustPolicy().Check(peer.ID(), addr.IP); err != nil {
+						fmt.Println(time.Now().Format(time.RFC3339), id, "filtered", addr, err)
+						return p2p.DiscUselessPeer
+					}
+				}
+				var caps []string
+				for _, c := range peer.Caps() {
+					caps = append(caps, c.String())
+				}
+				fmt.Println(time.Now().Format(time.RFC3339), id, "inbound", peer.Node().String(), "name", peer.Name(), "caps", strings.Join(caps, ","))
+
+				theirs, err := exchangeStatus(ws, spec, version)
+				if err != nil {
+					fmt.Println(time.Now().Format(time.RFC3339), id, "status", err)
+					return err
+				}
+				fmt.Println(time.Now().Format(time.RFC3339), id, "status", describeStatus(spec, theirs))
+				if err := checkStatus(spec, version, theirs); err != nil {
+					fmt.Println(time.Now().Format(time.RFC3339), id, err)
+					return p2p.DiscUselessPeer
+				}
+				if !serveKeep {
+					peer.Disconnect(p2p.DiscQuitting)
+					return nil
+				}
+				for {
+					msg, err := ws.ReadMsg()
+					if err != nil {
+						return err
+					}
+					_, err = observeMsg(ws, msg)
+					msg.Discard()
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		failc := make(chan *connFailure, 16)
+		serv := newServer(ethProtocols(serveRun), failc)
+		serv.PrivateKey = mustNodeKey(serveNodeKey)
+		serv.NoDial = true
+		serv.NoDiscovery = !serveDiscovery
+		if serveDiscovery {
+			serv.BootstrapNodes = mustBootnodes(spec)
+		}
+		natm, err := nat.Parse(serveNAT)
+		if err != nil {
+			log.Println("bad --nat", err)
+			os.Exit(1)
+		}
+		serv.NAT = natm
+		if err := serv.Start(); err != nil {
+			log.Println("failed to start p2p server", err)
+			os.Exit(1)
+		}
+		fmt.Println("self", serv.Self().String())
+
+		var (
+			sessions = make(map[enode.ID]time.Time)
+			dialers  = make(map[enode.ID]bool)
+			pEventCh = make(chan *p2p.PeerEvent)
+			pSub     = serv.SubscribeEvents(pEventCh)
+		)
+		end, release := sessionEnd()
+		defer release()
+	loop:
+		for {
+			select {
+			case ev := <-pEventCh:
+				switch ev.Type {
+				case p2p.PeerEventTypeAdd:
+					sessions[ev.Peer] = time.Now()
+					dialers[ev.Peer] = true
+				case p2p.PeerEventTypeDrop:
+					if start, ok := sessions[ev.Peer]; ok {
+						fmt.Println(time.Now().Format(time.RFC3339), ev.Peer.TerminalString(), "session", time.Since(start).Round(time.Millisecond), "dropped", ev.Error)
+						delete(sessions, ev.Peer)
+					}
+				}
+			case f := <-failc:
+				fmt.Println(time.Now().Format(time.RFC3339), f.id.TerminalString(), "rejected", f.addr, f.err)
+				if f.id != (enode.ID{}) {
+					dialers[f.id] = true
+				}
+			case err := <-pSub.Err():
+				log.Println("peer event sub error", err)
+				break loop
+			case <-end:
+				break loop
+			}
+		}
+		pSub.Unsubscribe()
+		serv.Stop()
+
+		succeeded(fmt.Sprintf("%d dialers", len(dialers))).exit()
+	},
+}
+
+func init() {
+	serveCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30303", "address:port to listen at")
+	serveCmd.PersistentFlags().StringVar(&serveNodeKey, "nodekey", "", "node key file, created if missing (default: a fresh key)")
+	serveCmd.PersistentFlags().BoolVar(&serveDiscovery, "discovery", false, "take part in discovery so other nodes can find us")
+	serveCmd.PersistentFlags().StringSliceVar(&serveBootnodes, "bootnodes", nil, "discovery bootstrap enodes (default: the chain's bootnodes)")
+	serveCmd.PersistentFlags().StringVar(&serveNAT, "nat", "none", "NAT port mapping mechanism (any|none|upnp|pmp|extip:<IP>)")
+	serveCmd.PersistentFlags().BoolVar(&serveKeep, "keep", false, "keep dialers connected instead of disconnecting after the status exchange")
+	serveCmd.PersistentFlags().IntVar(&maxPeers, "maxpeers", 25, "maximum number of connected dialers")
+	serveCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	serveCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	serveCmd.PersistentFlags().IntVarP(&sessionDuration, "duration", "d", 0, "seconds to serve for (0 = until interrupted)")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Redial backoff of runSessions. The server won't redial a node within 30
+// seconds of the last attempt anyway.
+var (
+	sessionBackoffMin = 30 * time.Second
+	sessionBackoffMax = 10 * time.Minute
+)
+
+// sessionHandler receives the events of runSessions. Nil funcs are skipped.
+type sessionHandler struct {
+	// started is called once a session's status handshake is done.
+	started func(peer *p2p.Peer, version uint)
+	// handle is called for every message a session receives.
+	// The message is discarded after.
+	handle func(peer *p2p.Peer, ws p2p.MsgWriter, msg p2p.Msg) error
+	// dropped is called when a connected node is dropped.
+	dropped func(id enode.ID, reason string)
+	// failed is called when a connection attempt or status handshake fails.
+	failed func(f *connFailure)
+	// tick is called every sessionInterval.
+	tick func()
+}
+
+// runSessions connects to nodes from one server and keeps eth sessions open
+// with them, until sessionDuration has passed or we're interrupted.
+// Nodes that are dropped or fail to connect are redialed after a backoff,
+// doubling from sessionBackoffMin up to sessionBackoffMax, and reset once
+// a session is established.
+// runSessions returns the last failed connection attempt, if any.
+func runSessions(nodes []*enode.Node, spec *chainSpec, h sessionHandler) error {
+	// Room for a failed status exchange and a failed connection attempt of
+	// every node, reported without blocking the server.
+	failc := make(chan *connFailure, 2*len(nodes))
+	startc := make(chan enode.ID, len(nodes))
+	run := func(version uint) func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+		return func(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
+			theirs, err := exchangeStatus(ws, spec, version)
+			if err != nil {
+				reportFailure(failc, &connFailure{id: peer.ID(), err: err})
+				return err
+			}
+			if err := checkStatus(spec, version, theirs); err != nil {
+				reportFailure(failc, &connFailure{id: peer.ID(), err: err})
+				return p2p.DiscUselessPeer
+			}
+			select {
+			case startc <- peer.ID():
+			default:
+			}
+			if h.started != nil {
+				h.started(peer, version)
+			}
+			for {
+				msg, err := ws.ReadMsg()
+				if err != nil {
+					return err
+				}
+				if h.handle != nil {
+					err = h.handle(peer, ws, msg)
+				}
+				msg.Discard()
+				if err != nil {
+					log.Println(peer.ID().TerminalString(), ethMsgName(msg.Code), err)
+					return err
+				}
+			}
+		}
+	}
+
+	serv := mustStartServer(ethProtocols(run), failc)
+	pEventCh := make(chan *p2p.PeerEvent)
+	pSub := serv.SubscribeEvents(pEventCh)
+
+	var (
+		targets = make(map[enode.ID]*enode.Node)
+		backoff = make(map[enode.ID]time.Duration)
+		waiting = make(map[enode.ID]bool) // removed, to be added again
+		redialc = make(chan enode.ID, len(nodes))
+	)
+	for _, n := range nodes {
+		targets[n.ID()] = n
+		backoff[n.ID()] = sessionBackoffMin
+		serv.AddPeer(n)
+	}
+	// redial removes a node from the server and adds it again after its backoff.
+	redial := func(id enode.ID) {
+		n, ok := targets[id]
+		if !ok || waiting[id] {
+			return
+		}
+		serv.RemovePeer(n)
+		waiting[id] = true
+		delay := backoff[id]
+		if backoff[id] *= 2; backoff[id] > sessionBackoffMax {
+			backoff[id] = sessionBackoffMax
+		}
+		log.Println("redialing", id.TerminalString(), "in", delay)
+		time.AfterFunc(delay, func() { redialc <- id })
+	}
+
+	end, release := sessionEnd()
+	defer release()
+	var ticker <-chan time.Time
+	if sessionInterval > 0 {
+		t := time.NewTicker(time.Duration(sessionInterval) * time.Second)
+		defer t.Stop()
+		ticker = t.C
+	}
+	var lastFailure error
+loop:
+	for {
+		select {
+		case ev := <-pEventCh:
+			if ev.Type == p2p.PeerEventTypeDrop {
+				fmt.Println(time.Now().Format(time.RFC3339), ev.Peer.TerminalString(), "dropped", ev.Error)
+				if h.dropped != nil {
+					h.dropped(ev.Peer, ev.Error)
+				}
+				redial(ev.Peer)
+			}
+		case id := <-startc:
+			backoff[id] = sessionBackoffMin
+		case f := <-failc:
+			log.Println("connection attempt failed:", f.id.TerminalString(), f.addr, f.err)
+			lastFailure = f.err
+			if h.failed != nil {
+				h.failed(f)
+			}
+			redial(f.id)
+		case id := <-redialc:
+			delete(waiting, id)
+			serv.AddPeer(targets[id])
+		case err := <-pSub.Err():
+			log.Println("peer event sub error", err)
+			break loop
+		case <-ticker:
+			if h.tick != nil {
+				h.tick()
+			}
+		case <-end:
+			break loop
+		}
+	}
+	pSub.Unsubscribe()
+	serv.Stop()
+	return lastFailure
+}
+
+// sessionEnd returns a channel that is closed once sessionDuration has passed,
+// if set, or we are interrupted. Call release when done with it.
+func sessionEnd() (end <-chan struct{}, release func()) {
+	var (
+		endc    = make(chan struct{})
+		quit    = make(chan struct{})
+		sigc    = make(chan os.Signal, 1)
+		timeout <-chan time.Time
+	)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	if sessionDuration > 0 {
+		timeout = time.After(time.Duration(sessionDuration) * time.Second)
+	}
+	go func() {
+		select {
+		case <-sigc:
+		case <-timeout:
+		case <-quit:
+			return
+		}
+		close(endc)
+	}()
+	return endc, func() {
+		signal.Stop(sigc)
+		close(quit)
+	}
+}
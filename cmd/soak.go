@@ -0,0 +1,263 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	soakBackoffMin int
+	soakBackoffMax int
+)
+
+// soakPeer is the connection history of one soaked node.
+type soakPeer struct {
+	Name      string
+	Connected time.Time // start of the open session, zero if disconnected
+	Sessions  []time.Duration
+	Reasons   map[string]int // disconnect reasons
+	Failures  map[string]int // failed connection attempts by outcome
+}
+
+func (p *soakPeer) uptime(now time.Time) time.Duration {
+	var up time.Duration
+	for _, d := range p.Sessions {
+		up += d
+	}
+	if !p.Connected.IsZero() {
+		up += now.Sub(p.Connected)
+	}
+	return up
+}
+
+// soakTracker records the sessions of soaked nodes.
+type soakTracker struct {
+	mu    sync.Mutex
+	start time.Time
+	peers map[enode.ID]*soakPeer
+}
+
+func newSoakTracker(nodes []*enode.Node) *soakTracker {
+	t := &soakTracker{start: time.Now(), peers: make(map[enode.ID]*soakPeer)}
+	for _, n := range nodes {
+		t.peers[n.ID()] = &soakPeer{Reasons: make(map[string]int), Failures: make(map[string]int)}
+	}
+	return t
+}
+
+func (t *soakTracker) connected(peer *p2p.Peer, version uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.peers[peer.ID()]
+	if !ok {
+		return
+	}
+	p.Name = peer.Name()
+	p.Connected = time.Now()
+	fmt.Println(p.Connected.Format(time.RFC3339), peer.ID().TerminalString(), "connect", peer.Name(), "eth", version)
+}
+
+func (t *soakTracker) disconnected(id enode.ID, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.peers[id]
+	if !ok || p.Connected.IsZero() {
+		// Dropped before the status handshake, reported as a failure.
+		return
+	}
+	now := time.Now()
+	session := now.Sub(p.Connected)
+	p.Sessions = append(p.Sessions, session)
+	p.Connected = time.Time{}
+	if r, ok := parseDiscReason(reason); ok {
+		reason = r.String()
+	}
+	p.Reasons[reason]++
+	fmt.Println(now.Format(time.RFC3339), id.TerminalString(), "disconnect", fmt.Sprintf("reason=%q", reason), "session", session.Round(time.Second))
+}
+
+func (t *soakTracker) failed(f *connFailure) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.peers[f.id]
+	if !ok {
+		return
+	}
+	o := classify(f.err)
+	p.Failures[o.Kind.String()]++
+	fmt.Println(time.Now().Format(time.RFC3339), f.id.TerminalString(), "failed", o.fields())
+}
+
+// closeSessions ends all open sessions, so they count towards the statistics.
+func (t *soakTracker) closeSessions() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for _, p := range t.peers {
+		if !p.Connected.IsZero() {
+			p.Sessions = append(p.Sessions, now.Sub(p.Connected))
+			p.Connected = time.Time{}
+		}
+	}
+}
+
+// everConnected counts the peers that had at least one session.
+func (t *soakTracker) everConnected() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var n int
+	for _, p := range t.peers {
+		if len(p.Sessions) > 0 || !p.Connected.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *soakTracker) print(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	window := now.Sub(t.start)
+	ids := make([]enode.ID, 0, len(t.peers))
+	for id := range t.peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	fmt.Fprintf(w, "soak %v\n", window.Round(time.Second))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "peer\tname\tsessions\tuptime\tconnected\tmean\tmax\tdisconnects\tfailures")
+	for _, id := range ids {
+		p := t.peers[id]
+		sessions := p.Sessions
+		if !p.Connected.IsZero() {
+			sessions = append(sessions[:len(sessions):len(sessions)], now.Sub(p.Connected))
+		}
+		up := p.uptime(now)
+		var mean, max time.Duration
+		for _, d := range sessions {
+			if d > max {
+				max = d
+			}
+		}
+		if len(sessions) > 0 {
+			mean = up / time.Duration(len(sessions))
+		}
+		var pct float64
+		if window > 0 {
+			pct = 100 * float64(up) / float64(window)
+		}
+		name := p.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%v\t%v\t%v\t%s\t%s\n", id.TerminalString(), name, len(sessions), pct,
+			up.Round(time.Second), mean.Round(time.Second), max.Round(time.Second), countList(p.Reasons), countList(p.Failures))
+	}
+	tw.Flush()
+}
+
+// countList renders counts as "key:n" pairs, most frequent first.
+func countList(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("%q:%d", k, counts[k])
+	}
+	return strings.Join(s, " ")
+}
+
+// soakCmd represents the soak command
+var soakCmd = &cobra.Command{
+	Use:   "soak <enode...>",
+	Short: "Keep enodes connected for a long time and report their uptime and stability",
+	Long: `
+    Spins up a memory-backed p2p server and keeps eth sessions open with the given enodes, like observe,
+    but without logging their traffic. Dropped or unreachable enodes are redialed with a backoff that doubles
+    from --backoff-min up to --backoff-max seconds, and resets once a session is established.
+
+    A timeline of connects, disconnects with their decoded reasons and session lengths, and failed connection
+    attempts is printed as it happens. Every --interval seconds and at exit a table shows, per enode, the number
+    of sessions, uptime as a share of the soak, total, mean and longest session, and counts of disconnect
+    reasons and failures. Open sessions are closed at exit.
+
+    The soak succeeds if at least one enode was connected.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		nodes := mustEnodeArgs(args)
+		spec := mustChainSpec()
+		sessionBackoffMin = time.Duration(soakBackoffMin) * time.Second
+		sessionBackoffMax = time.Duration(soakBackoffMax) * time.Second
+		if sessionBackoffMax < sessionBackoffMin {
+			sessionBackoffMax = sessionBackoffMin
+		}
+		tracker := newSoakTracker(nodes)
+
+		lastFailure := runSessions(nodes, spec, sessionHandler{
+			started: tracker.connected,
+			dropped: tracker.disconnected,
+			failed:  tracker.failed,
+			tick:    func() { tracker.print(os.Stdout) },
+		})
+		tracker.closeSessions()
+		tracker.print(os.Stdout)
+
+		n := tracker.everConnected()
+		if n == 0 {
+			if lastFailure == nil {
+				lastFailure = errProbeTimeout
+			}
+			classify(lastFailure).exit()
+		}
+		succeeded(fmt.Sprintf("connected %d of %d peers", n, len(nodes))).exit()
+	},
+}
+
+func init() {
+	soakCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at")
+	soakCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain to claim in the status exchange ("+chainNames()+")")
+	soakCmd.PersistentFlags().Uint64Var(&forkHead, "head", 0, "local head block to validate remote fork ids against (0 = past all known forks)")
+	soakCmd.PersistentFlags().IntVarP(&sessionDuration, "duration", "d", 0, "seconds to soak for (0 = until interrupted)")
+	soakCmd.PersistentFlags().IntVarP(&sessionInterval, "interval", "i", 600, "seconds between statistics (0 = only at exit)")
+	soakCmd.PersistentFlags().IntVar(&soakBackoffMin, "backoff-min", 30, "seconds to wait before the first redial of a dropped enode")
+	soakCmd.PersistentFlags().IntVar(&soakBackoffMax, "backoff-max", 600, "maximum seconds between redials")
+	rootCmd.AddCommand(soakCmd)
+}
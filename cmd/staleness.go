@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// staleMinNeighbors is the number of checked neighbors a node must have
+// handed out for its staleness to be reported.
+const staleMinNeighbors = 4
+
+// neighborPing is the result of pinging a neighbor.
+type neighborPing struct {
+	rtt time.Duration
+	err error
+}
+
+func (p neighborPing) String() string {
+	if p.err != nil {
+		return "dead"
+	}
+	return p.rtt.Round(time.Millisecond).String()
+}
+
+// pingNeighbors pings nodes, up to maxPendingPeers at once, and records the bonds.
+func pingNeighbors(u *discover.Dual, nodes []*enode.Node) []neighborPing {
+	pings := make([]neighborPing, len(nodes))
+	sem := make(chan struct{}, maxPendingPeers)
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p *neighborPing, n *enode.Node) {
+			defer wg.Done()
+			tstart := time.Now()
+			p.err = <-u.SendPing(n.ID(), &net.UDPAddr{IP: n.IP(), Port: n.UDP()}, func() { p.rtt = time.Since(tstart) })
+			recordBond(n, p.err)
+			o := classify(p.err)
+			if p.err == nil {
+				o.Detail = p.String()
+			}
+			recordProbe("ping", n, o)
+			<-sem
+		}(&pings[i], n)
+	}
+	wg.Wait()
+	return pings
+}
+
+// staleness is how many of the neighbors a node handed out were dead.
+type staleness struct {
+	node    *enode.Node
+	checked int // neighbors whose liveness is known
+	dead    int
+}
+
+func (s *staleness) score() float64 {
+	if s.checked == 0 {
+		return 0
+	}
+	return float64(s.dead) / float64(s.checked)
+}
+
+func (s *staleness) String() string {
+	return fmt.Sprintf("dead %d/%d %s", s.dead, s.checked, percent(s.dead, s.checked))
+}
+
+// staleNodes scores the nodes that handed out neighbors by the liveness of
+// those, as far as it is known, and returns the ones with at least
+// staleMinNeighbors checked neighbors scoring threshold or more, the
+// stalest first.
+func staleNodes(nodes map[enode.ID]*enode.Node, handed map[enode.ID][]enode.ID, alive map[enode.ID]bool, threshold float64) []*staleness {
+	var stale []*staleness
+	for id, neighbors := range handed {
+		s := &staleness{node: nodes[id]}
+		for _, nb := range neighbors {
+			if ok, checked := alive[nb]; checked {
+				s.checked++
+				if !ok {
+					s.dead++
+				}
+			}
+		}
+		if s.checked >= staleMinNeighbors && s.score() >= threshold {
+			stale = append(stale, s)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].score() != stale[j].score() {
+			return stale[i].score() > stale[j].score()
+		}
+		return stale[i].checked > stale[j].checked
+	})
+	return stale
+}
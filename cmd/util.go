@@ -3,25 +3,38 @@ package cmd
 import (
 	"fmt"
 	"github.com/etclabscore/dp2p/discover"
+	"github.com/etclabscore/dp2p/forkid"
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth"
+	elog "github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"log"
 	"math/big"
 	"net"
 	"os"
+	"strings"
+	"time"
 )
 
 var (
 	connectTimeout int
 	respTimeout int
 	listenAddr string
+	listenAddr6 string // IPv6 discovery socket, listenAddr is the IPv4 one
 	statusProto bool
+	chainName string
+	forkHead uint64
+	maxPeers = 25
+	maxPendingPeers = 50
 )
 
+// eth64 is the first eth protocol version carrying a fork id in its status.
+// The vendored eth package only implements eth/62 and eth/63.
+const eth64 = 64
+
 type statusData struct {
 	ProtocolVersion uint32
 	NetworkId uint64
@@ -30,6 +43,16 @@ type statusData struct {
 	GenesisBlock common.Hash
 }
 
+// statusData64 is the eth/64 status message, extended with an EIP-2124 fork id.
+type statusData64 struct {
+	ProtocolVersion uint32
+	NetworkId uint64
+	TD *big.Int
+	CurrentBlock common.Hash
+	GenesisBlock common.Hash
+	ForkID forkid.ID
+}
+
 type errCode int
 
 const (
@@ -48,7 +71,9 @@ func errResp(code errCode, format string, v ...interface{}) error {
 	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
 }
 
-func readStatus(ws p2p.MsgReadWriter, status *statusData) error {
+// readStatus reads the remote's status message into status, which should be
+// a pointer to either statusData or statusData64.
+func readStatus(ws p2p.MsgReadWriter, status interface{}) error {
 	msg, err := ws.ReadMsg()
 	if err != nil {
 		return err
@@ -60,7 +85,7 @@ func readStatus(ws p2p.MsgReadWriter, status *statusData) error {
 		return errResp(eth.ErrMsgTooLarge, "%v > %v", msg.Size, eth.ProtocolMaxMsgSize)
 	}
 	// Decode the handshake
-	if err := msg.Decode(&status); err != nil {
+	if err := msg.Decode(status); err != nil {
 		return errResp(eth.ErrDecode, "msg %v: %v", msg, err)
 	}
 	return nil
@@ -71,36 +96,183 @@ func mustEnodeArg(args []string) *enode.Node {
 		log.Println("need enode as first argument")
 		os.Exit(1)
 	}
-	eni := args[0]
-	en, err := enode.ParseV4(eni)
+	en, err := applyPolicy(mustParseEnode(args[0]))
+	if err != nil {
+		log.Println(err)
+		classify(err).exit()
+	}
+	probeTargets = []*enode.Node{en}
+	return en
+}
+
+func mustParseEnode(eni string) *enode.Node {
+	en, err := parseEnode(eni)
 	if err != nil {
-		log.Println("malformed enode", eni)
+		log.Println("bad enode", eni, err)
 		os.Exit(1)
 	}
 	return en
 }
 
-func mustUdp() *discover.Udp {
+// mustEnodeArgs parses all arguments as enodes. An argument @file
+// is replaced by the enodes listed in file. Enodes the network policy
+// doesn't allow get a result line and are left out.
+func mustEnodeArgs(args []string) []*enode.Node {
+	if len(args) == 0 {
+		log.Println("need at least one enode argument")
+		os.Exit(1)
+	}
+	var urls []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "@") {
+			list, err := readEnodeList(arg[1:])
+			if err != nil {
+				log.Println("failed to read enode list", err)
+				os.Exit(1)
+			}
+			urls = append(urls, list...)
+			continue
+		}
+		urls = append(urls, arg)
+	}
+	if len(urls) == 0 {
+		log.Println("need at least one enode argument")
+		os.Exit(1)
+	}
+	var (
+		nodes     []*enode.Node
+		lastError error
+	)
+	for _, url := range urls {
+		n, err := applyPolicy(mustParseEnode(url))
+		if err != nil {
+			if len(urls) > 1 {
+				printFiltered(n, err)
+			}
+			lastError = err
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 {
+		classify(lastError).exit()
+	}
+	probeTargets = nodes
+	return nodes
+}
+
+// mustSeeds returns the enodes to start discovering from: the arguments as
+// mustEnodeArgs parses them, or without arguments the chain's bootnodes the
+// network policy allows. The seeds aren't probe targets, probeTargets is
+// left as it was.
+func mustSeeds(args []string) []*enode.Node {
+	targets := probeTargets
+	defer func() { probeTargets = targets }()
+	if len(args) > 0 {
+		return mustEnodeArgs(args)
+	}
+	var seeds []*enode.Node
+	for _, url := range mustChainSpec().Bootnodes {
+		if n, err := applyPolicy(mustParseEnode(url)); err == nil {
+			seeds = append(seeds, n)
+		}
+	}
+	if len(seeds) == 0 {
+		log.Println("no enodes to start from, the chain has no bootnodes")
+		os.Exit(1)
+	}
+	return seeds
+}
+
+// newServer sets up a memory-backed p2p server, without discovery,
+// running the given protocols. Failed connection attempts are reported on failc.
+func newServer(protocols []p2p.Protocol, failc chan<- *connFailure) *p2p.Server {
+	nodekey, _ := crypto.GenerateKey()
+	serv := &p2p.Server{Config: p2p.Config{
+		PrivateKey:      nodekey,
+		MaxPeers:        maxPeers,
+		MaxPendingPeers: maxPendingPeers,
+		NoDiscovery:     true,
+		Name:            "dp2p",
+		Protocols:       protocols,
+		ListenAddr:      listenAddr,
+		NetRestrict:     mustPolicy().NetRestrict(),
+		Logger:          elog.Root(),
+		NodeDatabase:    "", // empty for memory
+		EnableMsgEvents: true,
+	}}
+	dialer := proxyNodeDialer{timeout: 15 * time.Second}
+	if mustProxy() != nil {
+		serv.Dialer = dialer
+	}
+	if failc != nil {
+		serv.Dialer = failureDialer{dialer, failc}
+		serv.Logger = elog.New()
+		serv.Logger.SetHandler(failureHandler(elog.Root().GetHandler(), failc))
+	}
+	return serv
+}
+
+// mustStartServer starts a server as set up by newServer.
+func mustStartServer(protocols []p2p.Protocol, failc chan<- *connFailure) *p2p.Server {
+	serv := newServer(protocols, failc)
+	if err := serv.Start(); err != nil {
+		log.Println("failed to start p2p server", err)
+		os.Exit(1)
+	}
+	return serv
+}
+
+func mustUdp() *discover.Dual {
 	nodeKey, _ := crypto.GenerateKey()
+	return mustUdpConfig(discover.Config{PrivateKey: nodeKey})
+}
 
-	addr, err := net.ResolveUDPAddr("udp", listenAddr)
-	if err != nil {
-		utils.Fatalf("-ResolveUDPAddr: %v", err)
+// mustUdpConfig is mustUdp with the given config, e.g. to share a node key with RLPx connections.
+// Discovery runs on an IPv4 socket at listenAddr and an IPv6 socket at listenAddr6,
+// and talks to every node from the socket of its address family.
+func mustUdpConfig(cfg discover.Config) *discover.Dual {
+	addr6 := listenAddr6
+	if addr6 == "" {
+		_, port, err := net.SplitHostPort(listenAddr)
+		if err != nil {
+			utils.Fatalf("-SplitHostPort: %v", err)
+		}
+		addr6 = net.JoinHostPort("::", port)
 	}
-	conn, err := net.ListenUDP("udp", addr)
+	c4, err := listenUDP("udp4", listenAddr)
 	if err != nil {
 		utils.Fatalf("-ListenUDP: %v", err)
 	}
+	c6, err := listenUDP("udp6", addr6)
+	if err != nil {
+		// Hosts without IPv6 can still talk to IPv4 nodes.
+		log.Println("no IPv6 discovery socket:", err)
+	}
+	if c4 == nil && c6 == nil {
+		utils.Fatalf("no discovery socket to listen on")
+	}
 
 	db, _ := enode.OpenDB("")
-	ln := enode.NewLocalNode(db, nodeKey)
-	cfg := discover.Config{
-		PrivateKey:  nodeKey,
-		//NetRestrict: restrictList,
+	if p := mustPolicy(); !p.Empty() {
+		cfg.NetRestrict = p.NetRestrict()
+		cfg.Filter = p.Check
 	}
-	_, u, err := discover.ListenUDP(conn, ln, cfg)
+	u, err := discover.ListenDual(c4, c6, db, cfg)
 	if err != nil {
 		utils.Fatalf("%v", err)
 	}
 	return u
-}
\ No newline at end of file
+}
+
+// listenUDP listens at addr on network udp4 or udp6, unless addr is "none".
+func listenUDP(network, addr string) (*net.UDPConn, error) {
+	if addr == "none" {
+		return nil, nil
+	}
+	a, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP(network, a)
+}
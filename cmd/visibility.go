@@ -0,0 +1,225 @@
+// Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etclabscore/dp2p/discover"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/spf13/cobra"
+)
+
+var (
+	visibilityRounds   int
+	visibilityInterval time.Duration
+	visibilityList     bool
+)
+
+// listing is how a remote table lists the checked node.
+type listing struct {
+	node     *enode.Node // whose table it is
+	endpoint string      // the checked node's endpoint in the table, "" if it isn't listed
+	err      error
+}
+
+// endpointString is the discovery and RLPx endpoint of n as an enode URL has it.
+func endpointString(n *enode.Node) string {
+	if n.UDP() != n.TCP() {
+		return fmt.Sprintf("%s?discport=%d", addrString(n), n.UDP())
+	}
+	return addrString(n)
+}
+
+// checkListed asks n for the nodes closest to target, which come first if n
+// lists target in its table.
+func checkListed(u *discover.Dual, n *enode.Node, target *enode.Node) listing {
+	l := listing{node: n}
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	if l.err = <-u.SendPing(n.ID(), addr, nil); l.err != nil {
+		return l
+	}
+	ns, err := u.Findnode(n.ID(), addr, target.Pubkey())
+	// A node without neighbors of target doesn't reply.
+	if err != nil && !discover.IsTimeout(err) {
+		l.err = err
+		return l
+	}
+	nodes := make([]*enode.Node, len(ns))
+	for i, nb := range ns {
+		nodes[i] = &nb.Node
+		if nb.ID() == target.ID() {
+			l.endpoint = endpointString(&nb.Node)
+		}
+	}
+	recordNeighbors(n, nodes)
+	return l
+}
+
+// visibilityCmd represents the visibility command
+var visibilityCmd = &cobra.Command{
+	Use:   "visibility <enode> [<bootnode enode...>]",
+	Short: "Check which nodes' discovery tables list an enode, and with which endpoint",
+	Long: `
+    Looks up the ID of the given enode, usually our own node, joining the network through the bootnode enodes
+    or the chain's bootnodes, and asks each of the closest nodes found (--maxpending at once) for the nodes
+    closest to it. A node listing the enode in its table returns it first, with the endpoint it has for it:
+    current if it is the enode's, stale otherwise, e.g. an address or port from before the node moved.
+
+    The check is repeated --rounds times, --interval apart. Each round prints how many of the closest nodes list
+    the enode, how many with a stale endpoint, and how many started (new) or stopped (dropped) listing it since
+    the round before; with --list every node's answer is printed. The stale endpoints seen are summed up last.
+
+    Right after a node is deployed or moved, expect few listings: nodes add it to their tables as it pings
+    them and they verify it, which the bootnodes do first. If the closest nodes keep not listing it, it
+    may not be reachable on its UDP endpoint (see reach).
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		target := mustEnodeArg(args)
+		seeds := mustSeeds(args[1:])
+		current := endpointString(target)
+
+		discover.SetResponseTimeout(time.Duration(int32(respTimeout)) * time.Millisecond)
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			log.Println(err)
+			classify(err).exit()
+		}
+		self := enode.PubkeyToIDV4(&key.PublicKey)
+		u := mustUdpConfig(discover.Config{PrivateKey: key, Bootnodes: seeds})
+
+		var (
+			listed     map[enode.ID]bool
+			last       []listing
+			staleSeen  = make(map[string]int) // stale endpoint => nodes listing it, over all rounds
+			lastListed int
+		)
+		for r := 0; r < visibilityRounds; r++ {
+			if r > 0 {
+				time.Sleep(visibilityInterval)
+			}
+			var closest []*enode.Node
+			for _, n := range u.Lookup(target.Pubkey()) {
+				if n.ID() != target.ID() && n.ID() != self {
+					closest = append(closest, n)
+				}
+			}
+			sort.Slice(closest, func(i, j int) bool { return enode.DistCmp(target.ID(), closest[i].ID(), closest[j].ID()) < 0 })
+
+			results := make([]listing, len(closest))
+			sem := make(chan struct{}, maxPendingPeers)
+			var wg sync.WaitGroup
+			for i, n := range closest {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int, n *enode.Node) {
+					defer wg.Done()
+					results[i] = checkListed(u, n, target)
+					<-sem
+				}(i, n)
+			}
+			wg.Wait()
+
+			var answered, stale, fresh, dropped int
+			now := make(map[enode.ID]bool)
+			for _, l := range results {
+				if l.err != nil {
+					continue
+				}
+				answered++
+				if l.endpoint == "" {
+					continue
+				}
+				now[l.node.ID()] = true
+				if l.endpoint != current {
+					stale++
+					staleSeen[l.endpoint]++
+				}
+				if listed != nil && !listed[l.node.ID()] {
+					fresh++
+				}
+			}
+			for id := range listed {
+				if !now[id] {
+					dropped++
+				}
+			}
+			change := ""
+			if listed != nil {
+				change = fmt.Sprintf(" new %d dropped %d", fresh, dropped)
+			}
+			fmt.Printf("round %d %s closest %d answered %d listing %d stale %d%s\n", r+1, time.Now().UTC().Format(time.RFC3339), len(closest), answered, len(now), stale, change)
+			if visibilityList {
+				tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "node\taddr\tlog-distance\tlists")
+				for _, l := range results {
+					lists := "-"
+					switch {
+					case l.err != nil:
+						lists = "error: " + l.err.Error()
+					case l.endpoint == current:
+						lists = "current " + l.endpoint
+					case l.endpoint != "":
+						lists = "stale " + l.endpoint
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", l.node.ID().TerminalString(), addrString(l.node), enode.LogDist(target.ID(), l.node.ID()), lists)
+				}
+				tw.Flush()
+			}
+			listed, last, lastListed = now, results, len(now)
+		}
+
+		var endpoints []string
+		for ep := range staleSeen {
+			endpoints = append(endpoints, ep)
+		}
+		sort.Strings(endpoints)
+		for _, ep := range endpoints {
+			fmt.Println("stale endpoint", ep, "listings", staleSeen[ep])
+		}
+
+		var lastStale int
+		for _, l := range last {
+			if l.endpoint != "" && l.endpoint != current {
+				lastStale++
+			}
+		}
+		if lastListed == 0 {
+			(&outcome{Kind: outcomeFailure, Detail: fmt.Sprintf("not listed by any of %d closest nodes", len(last))}).exit()
+		}
+		succeeded(fmt.Sprintf("listed by %d of %d closest nodes, %d with a stale endpoint", lastListed, len(last), lastStale)).exit()
+	},
+}
+
+func init() {
+	visibilityCmd.PersistentFlags().StringVarP(&listenAddr, "listenaddr", "a", ":30301", "address:port to listen at (IPv4 discovery socket, none to disable)")
+	visibilityCmd.PersistentFlags().StringVar(&listenAddr6, "listenaddr6", "", "address:port to listen at for IPv6 nodes (default: [::] and the port of --listenaddr, none to disable)")
+	visibilityCmd.PersistentFlags().IntVarP(&respTimeout, "resptimeout", "r", 500, "milliseconds for devp2p response timeout allowance")
+	visibilityCmd.PersistentFlags().IntVar(&maxPendingPeers, "maxpending", 16, "maximum number of nodes asked at once")
+	visibilityCmd.PersistentFlags().IntVar(&visibilityRounds, "rounds", 1, "number of times to check")
+	visibilityCmd.PersistentFlags().DurationVar(&visibilityInterval, "interval", 5*time.Minute, "time between the checks")
+	visibilityCmd.PersistentFlags().BoolVar(&visibilityList, "list", false, "print every closest node's answer")
+	visibilityCmd.PersistentFlags().StringVarP(&chainName, "chain", "c", "mainnet", "chain whose bootnodes to start from ("+chainNames()+")")
+	rootCmd.AddCommand(visibilityCmd)
+}
@@ -0,0 +1,300 @@
+package crawldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Snapshot is the population found by crawling: the nodes that answered.
+type Snapshot struct {
+	Time  time.Time
+	Nodes []*Node
+}
+
+// Snapshot returns the nodes last seen at or after since.
+func (db *DB) Snapshot(since time.Time) (*Snapshot, error) {
+	s := &Snapshot{Time: time.Now()}
+	err := db.Nodes(func(n *Node) bool {
+		if !n.LastSeen.IsZero() && !n.LastSeen.Before(since) {
+			s.Nodes = append(s.Nodes, n)
+		}
+		return true
+	})
+	return s, err
+}
+
+// WriteSnapshot writes s to file as JSON.
+func WriteSnapshot(file string, s *Snapshot) error {
+	enc, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, enc, 0644)
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSnapshot(file string) (*Snapshot, error) {
+	enc, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := new(Snapshot)
+	return s, json.Unmarshal(enc, s)
+}
+
+// NodeState is a node as it was at one time.
+type NodeState struct {
+	ID        enode.ID
+	IP        net.IP
+	UDP       int
+	TCP       int
+	Client    string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Addr renders the endpoint of s.
+func (s *NodeState) Addr() string {
+	if s.IP == nil {
+		return "-"
+	}
+	addr := net.JoinHostPort(s.IP.String(), strconv.Itoa(s.TCP))
+	if s.UDP != s.TCP {
+		addr += "?discport=" + strconv.Itoa(s.UDP)
+	}
+	return addr
+}
+
+// stateAt returns the endpoint and client n had at t, as far as recorded.
+func (n *Node) stateAt(t time.Time) *NodeState {
+	s := &NodeState{ID: n.ID, Client: n.Client, FirstSeen: n.FirstSeen, LastSeen: n.LastSeen}
+	for i, e := range n.Endpoints {
+		if i == 0 || !e.FirstSeen.After(t) {
+			s.IP, s.UDP, s.TCP = e.IP, e.UDP, e.TCP
+		}
+	}
+	for i, c := range n.Clients {
+		if i == 0 || !c.FirstSeen.After(t) {
+			s.Client = c.Name
+		}
+	}
+	return s
+}
+
+// View is the node population at one time.
+type View struct {
+	Time  time.Time
+	Nodes map[enode.ID]*NodeState
+}
+
+// View returns the population of the snapshot.
+func (s *Snapshot) View() *View {
+	v := &View{Time: s.Time, Nodes: make(map[enode.ID]*NodeState, len(s.Nodes))}
+	for _, n := range s.Nodes {
+		v.Nodes[n.ID] = n.stateAt(s.Time)
+	}
+	return v
+}
+
+// ViewAt returns the population at t: the nodes that answered a probe within
+// window before t, with the endpoint and client they had then.
+func (db *DB) ViewAt(t time.Time, window time.Duration) (*View, error) {
+	lastSeen := make(map[enode.ID]time.Time)
+	err := db.Probes(t.Add(-window), func(p *Probe) bool {
+		if p.Time.After(t) {
+			return false
+		}
+		if p.Answered {
+			lastSeen[p.ID] = p.Time
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	v := &View{Time: t, Nodes: make(map[enode.ID]*NodeState, len(lastSeen))}
+	for id, seen := range lastSeen {
+		n := db.Node(id)
+		if n == nil {
+			continue
+		}
+		s := n.stateAt(t)
+		s.LastSeen = seen
+		v.Nodes[id] = s
+	}
+	return v, nil
+}
+
+// Change is a node's endpoint or client changing between two views.
+type Change struct {
+	ID       enode.ID
+	From, To string
+}
+
+// Diff compares two views of the population.
+type Diff struct {
+	From, To *View
+	Joined   []*NodeState // in To only
+	Left     []*NodeState // in From only
+	Moved    []Change     // IP or ports changed
+	Upgraded []Change     // client name changed
+}
+
+// Compare compares the views from and to. Nodes are ordered by ID.
+func Compare(from, to *View) *Diff {
+	d := &Diff{From: from, To: to}
+	for _, id := range sortedIDs(to) {
+		s := to.Nodes[id]
+		old, ok := from.Nodes[id]
+		if !ok {
+			d.Joined = append(d.Joined, s)
+			continue
+		}
+		if old.Addr() != s.Addr() {
+			d.Moved = append(d.Moved, Change{id, old.Addr(), s.Addr()})
+		}
+		if old.Client != s.Client && old.Client != "" && s.Client != "" {
+			d.Upgraded = append(d.Upgraded, Change{id, old.Client, s.Client})
+		}
+	}
+	for _, id := range sortedIDs(from) {
+		if _, ok := to.Nodes[id]; !ok {
+			d.Left = append(d.Left, from.Nodes[id])
+		}
+	}
+	return d
+}
+
+func sortedIDs(v *View) []enode.ID {
+	ids := make([]enode.ID, 0, len(v.Nodes))
+	for id := range v.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	return ids
+}
+
+// Churn is the share of the population that joined or left: the nodes
+// joining and leaving over twice the mean population.
+func (d *Diff) Churn() float64 {
+	total := len(d.From.Nodes) + len(d.To.Nodes)
+	if total == 0 {
+		return 0
+	}
+	return float64(len(d.Joined)+len(d.Left)) / float64(total)
+}
+
+// ChurnPerDay is Churn over the days between the views.
+func (d *Diff) ChurnPerDay() float64 {
+	days := d.To.Time.Sub(d.From.Time).Hours() / 24
+	if days <= 0 {
+		return 0
+	}
+	return d.Churn() / days
+}
+
+// LeftLifetimes are the times the nodes that left were seen for.
+func (d *Diff) LeftLifetimes() []time.Duration {
+	var ds []time.Duration
+	for _, s := range d.Left {
+		if !s.FirstSeen.IsZero() {
+			ds = append(ds, s.LastSeen.Sub(s.FirstSeen))
+		}
+	}
+	return ds
+}
+
+// Session is a time a node kept answering probes.
+type Session struct {
+	ID         enode.ID
+	Start, End time.Time // first and last answered probe
+	Ended      bool      // false if the node may still be up
+}
+
+// Sessions reconstructs sessions from the probe log between from and to.
+// A session is a run of answered probes of a node, ended by an unanswered
+// probe or by no answer for longer than gap. Unanswered findnode and status
+// probes don't end it: they follow an answered ping, so the node is up.
+func (db *DB) Sessions(from, to time.Time, gap time.Duration) ([]Session, error) {
+	open := make(map[enode.ID]*Session)
+	var sessions []Session
+	end := func(id enode.ID) {
+		if s := open[id]; s != nil {
+			s.Ended = true
+			sessions = append(sessions, *s)
+			delete(open, id)
+		}
+	}
+	err := db.Probes(from, func(p *Probe) bool {
+		if p.Time.After(to) {
+			return false
+		}
+		s := open[p.ID]
+		if s != nil && p.Time.Sub(s.End) > gap {
+			end(p.ID)
+			s = nil
+		}
+		switch {
+		case p.Answered && s == nil:
+			open[p.ID] = &Session{ID: p.ID, Start: p.Time, End: p.Time}
+		case p.Answered:
+			s.End = p.Time
+		case p.Kind != "findnode" && p.Kind != "status":
+			end(p.ID)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for id, s := range open {
+		if to.Sub(s.End) > gap {
+			end(id)
+		} else {
+			sessions = append(sessions, *s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].Start.Equal(sessions[j].Start) {
+			return sessions[i].Start.Before(sessions[j].Start)
+		}
+		return bytes.Compare(sessions[i].ID[:], sessions[j].ID[:]) < 0
+	})
+	return sessions, nil
+}
+
+// Median returns the median of ds, zero if it's empty.
+func Median(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// AgeBuckets are the upper bounds of the age classes Ages counts.
+var AgeBuckets = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+// Ages counts the nodes of v by the time since they were first seen, in
+// the classes of AgeBuckets and a last one for older nodes.
+func Ages(v *View) []int {
+	counts := make([]int, len(AgeBuckets)+1)
+	for _, s := range v.Nodes {
+		age := v.Time.Sub(s.FirstSeen)
+		i := sort.Search(len(AgeBuckets), func(i int) bool { return age < AgeBuckets[i] })
+		counts[i]++
+	}
+	return counts
+}
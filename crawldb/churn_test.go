@@ -0,0 +1,173 @@
+package crawldb
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestCompare(t *testing.T) {
+	t0 := time.Unix(1000000, 0)
+	var ids [4]enode.ID
+	for i := range ids {
+		ids[i][0] = byte(i)
+	}
+	from := &View{Time: t0, Nodes: map[enode.ID]*NodeState{
+		ids[0]: {ID: ids[0], IP: net.ParseIP("10.0.0.1"), UDP: 30303, TCP: 30303, Client: "Geth/v1.8.27"},
+		ids[1]: {ID: ids[1], IP: net.ParseIP("10.0.0.2"), UDP: 30303, TCP: 30303, Client: "Geth/v1.8.27"},
+		ids[2]: {ID: ids[2], IP: net.ParseIP("10.0.0.3"), UDP: 30303, TCP: 30303, FirstSeen: t0.Add(-time.Hour), LastSeen: t0},
+	}}
+	to := &View{Time: t0.Add(48 * time.Hour), Nodes: map[enode.ID]*NodeState{
+		ids[0]: {ID: ids[0], IP: net.ParseIP("10.0.0.9"), UDP: 30303, TCP: 30303, Client: "Geth/v1.8.27"},
+		ids[1]: {ID: ids[1], IP: net.ParseIP("10.0.0.2"), UDP: 30303, TCP: 30303, Client: "Geth/v1.9.0"},
+		ids[3]: {ID: ids[3], IP: net.ParseIP("10.0.0.4"), UDP: 30303, TCP: 30303},
+	}}
+	d := Compare(from, to)
+	if len(d.Joined) != 1 || d.Joined[0].ID != ids[3] {
+		t.Errorf("joined %v", d.Joined)
+	}
+	if len(d.Left) != 1 || d.Left[0].ID != ids[2] {
+		t.Errorf("left %v", d.Left)
+	}
+	if len(d.Moved) != 1 || d.Moved[0] != (Change{ids[0], "10.0.0.1:30303", "10.0.0.9:30303"}) {
+		t.Errorf("moved %v", d.Moved)
+	}
+	if len(d.Upgraded) != 1 || d.Upgraded[0] != (Change{ids[1], "Geth/v1.8.27", "Geth/v1.9.0"}) {
+		t.Errorf("upgraded %v", d.Upgraded)
+	}
+	if churn := d.Churn(); churn != 2.0/6 {
+		t.Errorf("churn %v, want 1/3", churn)
+	}
+	if perDay := d.ChurnPerDay(); perDay != 1.0/6 {
+		t.Errorf("churn per day %v, want 1/6", perDay)
+	}
+	if lt := d.LeftLifetimes(); len(lt) != 1 || lt[0] != time.Hour {
+		t.Errorf("left lifetimes %v", lt)
+	}
+}
+
+func TestViewAt(t *testing.T) {
+	db, _ := Open("")
+	defer db.Close()
+
+	n := newNode(t, "10.0.0.1", 30303)
+	moved := enode.NewV4(n.Pubkey(), net.ParseIP("10.0.0.2"), 30303, 30303)
+	t0 := time.Unix(1000000, 0)
+	db.UpdateNode(n.ID(), func(rec *Node) {
+		rec.Saw(n, t0)
+		rec.Answered(t0)
+		rec.SawClient("Geth/v1.8.27", t0)
+		rec.Saw(moved, t0.Add(24*time.Hour))
+		rec.SawClient("Geth/v1.9.0", t0.Add(24*time.Hour))
+	})
+	db.AddProbe(&Probe{Time: t0, ID: n.ID(), Kind: "ping", Answered: true})
+	db.AddProbe(&Probe{Time: t0.Add(24 * time.Hour), ID: n.ID(), Kind: "ping", Answered: true})
+
+	v, err := db.ViewAt(t0.Add(time.Hour), 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := v.Nodes[n.ID()]
+	if s == nil || !s.IP.Equal(net.ParseIP("10.0.0.1")) || s.Client != "Geth/v1.8.27" {
+		t.Fatalf("early view %+v", s)
+	}
+	v, _ = db.ViewAt(t0.Add(25*time.Hour), 2*time.Hour)
+	if s := v.Nodes[n.ID()]; s == nil || !s.IP.Equal(net.ParseIP("10.0.0.2")) || s.Client != "Geth/v1.9.0" {
+		t.Fatalf("late view %+v", s)
+	}
+	v, _ = db.ViewAt(t0.Add(12*time.Hour), 2*time.Hour)
+	if len(v.Nodes) != 0 {
+		t.Errorf("node unseen in the window is in the view")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	db, _ := Open("")
+	defer db.Close()
+
+	var a, b enode.ID
+	a[0], b[0] = 1, 2
+	t0 := time.Unix(1000000, 0)
+	probes := []*Probe{
+		{Time: t0, ID: a, Kind: "ping", Answered: true},
+		{Time: t0.Add(1 * time.Hour), ID: a, Kind: "ping", Answered: true},
+		{Time: t0.Add(2 * time.Hour), ID: a, Kind: "findnode"}, // doesn't end the session
+		{Time: t0.Add(3 * time.Hour), ID: a, Kind: "ping", Answered: true},
+		{Time: t0.Add(4 * time.Hour), ID: a, Kind: "ping"}, // ends it
+		{Time: t0.Add(5 * time.Hour), ID: a, Kind: "ping", Answered: true},
+		{Time: t0, ID: b, Kind: "hello", Answered: true},
+		{Time: t0.Add(30 * time.Hour), ID: b, Kind: "hello", Answered: true}, // after a gap
+	}
+	for _, p := range probes {
+		db.AddProbe(p)
+	}
+	sessions, err := db.Sessions(t0, t0.Add(31*time.Hour), 12*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Session{
+		{ID: a, Start: t0, End: t0.Add(3 * time.Hour), Ended: true},
+		{ID: b, Start: t0, End: t0, Ended: true},
+		{ID: a, Start: t0.Add(5 * time.Hour), End: t0.Add(5 * time.Hour), Ended: true},
+		{ID: b, Start: t0.Add(30 * time.Hour), End: t0.Add(30 * time.Hour)},
+	}
+	if len(sessions) != len(want) {
+		t.Fatalf("got %d sessions, want %d: %+v", len(sessions), len(want), sessions)
+	}
+	for i := range want {
+		s, w := sessions[i], want[i]
+		if s.ID != w.ID || !s.Start.Equal(w.Start) || !s.End.Equal(w.End) || s.Ended != w.Ended {
+			t.Errorf("session %d: got %+v, want %+v", i, s, w)
+		}
+	}
+}
+
+func TestAgesMedian(t *testing.T) {
+	now := time.Now()
+	v := &View{Time: now, Nodes: make(map[enode.ID]*NodeState)}
+	for i, age := range []time.Duration{time.Minute, 2 * time.Hour, 3 * time.Hour, 40 * 24 * time.Hour} {
+		var id enode.ID
+		id[0] = byte(i)
+		v.Nodes[id] = &NodeState{FirstSeen: now.Add(-age)}
+	}
+	got := Ages(v)
+	want := []int{1, 2, 0, 0, 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ages %v, want %v", got, want)
+		}
+	}
+	if m := Median([]time.Duration{3, 1, 2}); m != 2 {
+		t.Errorf("median %v, want 2", m)
+	}
+	if m := Median([]time.Duration{4, 1, 2, 3}); m != 2 {
+		t.Errorf("median %v, want 2", m)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := newNode(t, "10.0.0.1", 30303)
+	s := &Snapshot{Time: time.Unix(1000000, 0).UTC(), Nodes: []*Node{{ID: n.ID(), Client: "Geth/v1.9.0"}}}
+	file := filepath.Join(dir, "snap.json")
+	if err := WriteSnapshot(file, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadSnapshot(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(s.Time) || len(got.Nodes) != 1 || got.Nodes[0].ID != n.ID() || got.Nodes[0].Client != "Geth/v1.9.0" {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,438 @@
+// Package crawldb stores what crawls and probes learn about nodes in a
+// leveldb database: when each node ID was first and last seen, the history
+// of its IPs and ports, its bond state, ENR sequence number, client name,
+// capabilities and eth status, and a log of every probe result.
+//
+// It also holds the state of the running crawl, the nodes still to visit
+// and those visited, so an interrupted crawl can be resumed.
+package crawldb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key prefixes.
+var (
+	nodePrefix    = []byte("n:") // + node ID -> Node
+	probePrefix   = []byte("p:") // + big endian unix nanoseconds + node ID -> Probe
+	queuePrefix   = []byte("q:") // + node ID -> enode URL, to visit in this crawl
+	visitedPrefix = []byte("v:") // + node ID -> visit time, visited in this crawl
+	edgePrefix    = []byte("e:") // + reporting node ID + reported node ID -> Edge
+	crawlKey      = []byte("crawl")
+)
+
+// Endpoint is an address a node was seen at.
+type Endpoint struct {
+	IP        net.IP
+	UDP       int
+	TCP       int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Status is the eth status a node sent.
+type Status struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkHash        string `json:",omitempty"` // eth/64 fork id, hex
+	ForkNext        uint64 `json:",omitempty"`
+	Time            time.Time
+}
+
+// Node is everything known about one node ID.
+type Node struct {
+	ID         enode.ID
+	URL        string     // latest enode URL
+	Discovered time.Time  // first given as a seed or reported as a neighbor
+	FirstSeen  time.Time  // first answered, zero if it never did
+	LastSeen   time.Time  // last answered
+	Endpoints  []Endpoint // IP and port history, oldest first
+	Bonded     time.Time  // last answered ping
+	BondFails  int        // pings unanswered since then
+	Seq        uint64     `json:",omitempty"` // ENR sequence number
+	Client     string     `json:",omitempty"` // name from the devp2p hello
+	Clients    []Client   `json:",omitempty"` // client name history, oldest first
+	Caps       []string   `json:",omitempty"`
+	Status     *Status    `json:",omitempty"`
+}
+
+// Saw records that n was reported or answered at the endpoint of en.
+func (n *Node) Saw(en *enode.Node, t time.Time) {
+	n.URL = en.String()
+	if n.Discovered.IsZero() {
+		n.Discovered = t
+	}
+	if len(n.Endpoints) > 0 {
+		last := &n.Endpoints[len(n.Endpoints)-1]
+		if last.IP.Equal(en.IP()) && last.UDP == en.UDP() && last.TCP == en.TCP() {
+			last.LastSeen = t
+			return
+		}
+	}
+	n.Endpoints = append(n.Endpoints, Endpoint{IP: en.IP(), UDP: en.UDP(), TCP: en.TCP(), FirstSeen: t, LastSeen: t})
+}
+
+// Client is a client name a node was seen with.
+type Client struct {
+	Name      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// SawClient records that n identified as name.
+func (n *Node) SawClient(name string, t time.Time) {
+	n.Client = name
+	if len(n.Clients) > 0 {
+		last := &n.Clients[len(n.Clients)-1]
+		if last.Name == name {
+			last.LastSeen = t
+			return
+		}
+	}
+	n.Clients = append(n.Clients, Client{Name: name, FirstSeen: t, LastSeen: t})
+}
+
+// Answered records that n responded to us.
+func (n *Node) Answered(t time.Time) {
+	if n.FirstSeen.IsZero() {
+		n.FirstSeen = t
+	}
+	n.LastSeen = t
+}
+
+// Endpoint returns the latest endpoint, nil if none is known.
+func (n *Node) Endpoint() *Endpoint {
+	if len(n.Endpoints) == 0 {
+		return nil
+	}
+	return &n.Endpoints[len(n.Endpoints)-1]
+}
+
+// Probe is the result of one probe of a node.
+type Probe struct {
+	Time     time.Time
+	ID       enode.ID
+	Kind     string // what was probed, e.g. ping, findnode or status
+	Addr     string
+	Result   string
+	Exit     int
+	Detail   string `json:",omitempty"`
+	Answered bool   // the node responded, even if the probe failed
+	Egress   string `json:",omitempty"` // address the proxy dialed from, if any
+}
+
+// Edge is a node reporting another in a NEIGHBORS reply.
+type Edge struct {
+	From, To  enode.ID
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Crawl is the state of the current crawl.
+type Crawl struct {
+	Started  time.Time
+	Finished time.Time // zero while running or interrupted
+}
+
+// DB is a crawl database.
+type DB struct {
+	lvl *leveldb.DB
+	mu  sync.Mutex // serializes node updates
+}
+
+// Open opens the database at path, creating it if needed. An empty path
+// opens a memory database.
+func Open(path string) (*DB, error) {
+	var (
+		lvl *leveldb.DB
+		err error
+	)
+	if path == "" {
+		lvl, err = leveldb.Open(storage.NewMemStorage(), nil)
+	} else {
+		lvl, err = leveldb.OpenFile(path, &opt.Options{OpenFilesCacheCapacity: 16})
+		if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+			lvl, err = leveldb.RecoverFile(path, nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DB{lvl: lvl}, nil
+}
+
+// Close flushes and closes the database.
+func (db *DB) Close() error {
+	return db.lvl.Close()
+}
+
+func key(prefix []byte, id enode.ID) []byte {
+	return append(append([]byte{}, prefix...), id[:]...)
+}
+
+func (db *DB) get(k []byte, v interface{}) bool {
+	blob, err := db.lvl.Get(k, nil)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(blob, v) == nil
+}
+
+func (db *DB) put(k []byte, v interface{}) error {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(k, blob, nil)
+}
+
+// Node returns the record of id, nil if there is none.
+func (db *DB) Node(id enode.ID) *Node {
+	n := new(Node)
+	if !db.get(key(nodePrefix, id), n) {
+		return nil
+	}
+	return n
+}
+
+// UpdateNode calls f with the record of id, a new one if there is none,
+// and stores it.
+func (db *DB) UpdateNode(id enode.ID, f func(*Node)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := db.Node(id)
+	if n == nil {
+		n = &Node{ID: id}
+	}
+	f(n)
+	return db.put(key(nodePrefix, id), n)
+}
+
+// Nodes calls f with each node record until it returns false.
+func (db *DB) Nodes(f func(*Node) bool) error {
+	it := db.lvl.NewIterator(util.BytesPrefix(nodePrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		n := new(Node)
+		if json.Unmarshal(it.Value(), n) != nil {
+			continue
+		}
+		if !f(n) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+// AddProbe appends p to the probe log.
+func (db *DB) AddProbe(p *Probe) error {
+	k := make([]byte, len(probePrefix)+8, len(probePrefix)+8+len(p.ID))
+	copy(k, probePrefix)
+	binary.BigEndian.PutUint64(k[len(probePrefix):], uint64(p.Time.UnixNano()))
+	return db.put(append(k, p.ID[:]...), p)
+}
+
+// Probes calls f with the probes since the given time, oldest first,
+// until it returns false.
+func (db *DB) Probes(since time.Time, f func(*Probe) bool) error {
+	start := make([]byte, len(probePrefix)+8)
+	copy(start, probePrefix)
+	if !since.IsZero() {
+		binary.BigEndian.PutUint64(start[len(probePrefix):], uint64(since.UnixNano()))
+	}
+	it := db.lvl.NewIterator(&util.Range{Start: start, Limit: util.BytesPrefix(probePrefix).Limit}, nil)
+	defer it.Release()
+	for it.Next() {
+		p := new(Probe)
+		if json.Unmarshal(it.Value(), p) != nil {
+			continue
+		}
+		if !f(p) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+// AddEdges records that from reported the nodes to as its neighbors.
+func (db *DB) AddEdges(from enode.ID, to []enode.ID, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	batch := new(leveldb.Batch)
+	for _, id := range to {
+		k := append(key(edgePrefix, from), id[:]...)
+		e := &Edge{From: from, To: id, FirstSeen: t}
+		db.get(k, e)
+		e.LastSeen = t
+		blob, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		batch.Put(k, blob)
+	}
+	return db.lvl.Write(batch, nil)
+}
+
+// Edges calls f with each edge last seen at or after since, until it
+// returns false. Edges are ordered by the reporting node.
+func (db *DB) Edges(since time.Time, f func(*Edge) bool) error {
+	it := db.lvl.NewIterator(util.BytesPrefix(edgePrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		e := new(Edge)
+		if json.Unmarshal(it.Value(), e) != nil || e.LastSeen.Before(since) {
+			continue
+		}
+		if !f(e) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+// Crawl returns the state of the current crawl, nil if none was started.
+func (db *DB) Crawl() *Crawl {
+	c := new(Crawl)
+	if !db.get(crawlKey, c) {
+		return nil
+	}
+	return c
+}
+
+// StartCrawl forgets the current crawl and starts a new one.
+func (db *DB) StartCrawl(t time.Time) error {
+	for _, prefix := range [][]byte{queuePrefix, visitedPrefix} {
+		it := db.lvl.NewIterator(util.BytesPrefix(prefix), nil)
+		batch := new(leveldb.Batch)
+		for it.Next() {
+			batch.Delete(it.Key())
+		}
+		it.Release()
+		if err := db.lvl.Write(batch, nil); err != nil {
+			return err
+		}
+	}
+	return db.put(crawlKey, &Crawl{Started: t})
+}
+
+// FinishCrawl marks the current crawl as done.
+func (db *DB) FinishCrawl(t time.Time) error {
+	c := db.Crawl()
+	if c == nil {
+		c = &Crawl{Started: t}
+	}
+	c.Finished = t
+	return db.put(crawlKey, c)
+}
+
+// Enqueue adds n to the nodes to visit in this crawl. It reports false
+// if n was queued or visited already.
+func (db *DB) Enqueue(n *enode.Node) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, prefix := range [][]byte{queuePrefix, visitedPrefix} {
+		if ok, err := db.lvl.Has(key(prefix, n.ID()), nil); ok || err != nil {
+			return false, err
+		}
+	}
+	return true, db.lvl.Put(key(queuePrefix, n.ID()), []byte(n.String()), nil)
+}
+
+// Visited moves id from the queue to the visited nodes of this crawl.
+func (db *DB) Visited(id enode.ID, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	batch := new(leveldb.Batch)
+	batch.Delete(key(queuePrefix, id))
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(t.UnixNano()))
+	batch.Put(key(visitedPrefix, id), ts)
+	return db.lvl.Write(batch, nil)
+}
+
+// Queue returns the nodes still to visit in this crawl.
+func (db *DB) Queue() ([]*enode.Node, error) {
+	var nodes []*enode.Node
+	it := db.lvl.NewIterator(util.BytesPrefix(queuePrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		n, err := enode.ParseV4(string(it.Value()))
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, it.Error()
+}
+
+// CountVisited returns how many nodes this crawl visited.
+func (db *DB) CountVisited() (int, error) {
+	var count int
+	it := db.lvl.NewIterator(util.BytesPrefix(visitedPrefix), nil)
+	defer it.Release()
+	for it.Next() {
+		count++
+	}
+	return count, it.Error()
+}
+
+// Query selects node records. Zero fields match all nodes.
+type Query struct {
+	Since     time.Time   // last seen at or after
+	NetworkID uint64      // eth status network ID
+	Genesis   common.Hash // eth status genesis hash
+	Client    string      // case-insensitive substring of the client name
+	Net       *net.IPNet  // latest IP in this network
+}
+
+// Match reports whether n is selected by q.
+func (q *Query) Match(n *Node) bool {
+	if !q.Since.IsZero() && n.LastSeen.Before(q.Since) {
+		return false
+	}
+	if q.NetworkID != 0 && (n.Status == nil || n.Status.NetworkID != q.NetworkID) {
+		return false
+	}
+	if q.Genesis != (common.Hash{}) && (n.Status == nil || n.Status.Genesis != q.Genesis) {
+		return false
+	}
+	if q.Client != "" && !strings.Contains(strings.ToLower(n.Client), strings.ToLower(q.Client)) {
+		return false
+	}
+	if q.Net != nil {
+		e := n.Endpoint()
+		if e == nil || !q.Net.Contains(e.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the nodes matching q.
+func (db *DB) Select(q *Query) ([]*Node, error) {
+	var nodes []*Node
+	err := db.Nodes(func(n *Node) bool {
+		if q.Match(n) {
+			nodes = append(nodes, n)
+		}
+		return true
+	})
+	return nodes, err
+}
@@ -0,0 +1,175 @@
+package crawldb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func newNode(t *testing.T, ip string, port int) *enode.Node {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enode.NewV4(&key.PublicKey, net.ParseIP(ip), port, port)
+}
+
+func TestNodeHistory(t *testing.T) {
+	db, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	n := newNode(t, "10.0.0.1", 30303)
+	moved := enode.NewV4(n.Pubkey(), net.ParseIP("10.0.0.2"), 30303, 30303)
+	t0 := time.Unix(1000, 0)
+	for i, en := range []*enode.Node{n, n, moved} {
+		ts := t0.Add(time.Duration(i) * time.Hour)
+		db.UpdateNode(n.ID(), func(rec *Node) {
+			rec.Saw(en, ts)
+			rec.Answered(ts)
+		})
+	}
+	db.UpdateNode(n.ID(), func(rec *Node) { rec.Client = "Geth/v1.9.0" })
+
+	rec := db.Node(n.ID())
+	if rec == nil {
+		t.Fatal("node not stored")
+	}
+	if len(rec.Endpoints) != 2 {
+		t.Fatalf("got %d endpoints, want 2: %+v", len(rec.Endpoints), rec.Endpoints)
+	}
+	if e := rec.Endpoints[0]; !e.FirstSeen.Equal(t0) || !e.LastSeen.Equal(t0.Add(time.Hour)) {
+		t.Errorf("first endpoint seen %v-%v", e.FirstSeen, e.LastSeen)
+	}
+	if !rec.Endpoint().IP.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("latest endpoint %v, want 10.0.0.2", rec.Endpoint().IP)
+	}
+	if !rec.FirstSeen.Equal(t0) || !rec.LastSeen.Equal(t0.Add(2*time.Hour)) || rec.Client != "Geth/v1.9.0" {
+		t.Errorf("bad record %+v", rec)
+	}
+	if db.Node(newNode(t, "10.0.0.3", 1).ID()) != nil {
+		t.Error("unknown node has a record")
+	}
+}
+
+func TestProbes(t *testing.T) {
+	db, _ := Open("")
+	defer db.Close()
+
+	n := newNode(t, "10.0.0.1", 30303)
+	t0 := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		db.AddProbe(&Probe{Time: t0.Add(time.Duration(i) * time.Minute), ID: n.ID(), Kind: "ping", Result: "success"})
+	}
+	var got []time.Time
+	db.Probes(t0.Add(time.Minute), func(p *Probe) bool {
+		got = append(got, p.Time)
+		return true
+	})
+	if len(got) != 2 || !got[0].Equal(t0.Add(time.Minute)) || !got[1].Equal(t0.Add(2*time.Minute)) {
+		t.Errorf("got probes at %v", got)
+	}
+}
+
+func TestCrawlQueue(t *testing.T) {
+	db, _ := Open("")
+	defer db.Close()
+
+	a, b := newNode(t, "10.0.0.1", 30303), newNode(t, "10.0.0.2", 30303)
+	db.StartCrawl(time.Now())
+	for _, n := range []*enode.Node{a, b, a} {
+		db.Enqueue(n)
+	}
+	db.Visited(a.ID(), time.Now())
+	if ok, _ := db.Enqueue(a); ok {
+		t.Error("visited node queued again")
+	}
+	queue, err := db.Queue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].ID() != b.ID() {
+		t.Errorf("queue %v, want %v", queue, b)
+	}
+	if n, _ := db.CountVisited(); n != 1 {
+		t.Errorf("%d visited, want 1", n)
+	}
+	if c := db.Crawl(); c == nil || !c.Finished.IsZero() {
+		t.Errorf("crawl state %+v", c)
+	}
+
+	db.StartCrawl(time.Now())
+	if queue, _ := db.Queue(); len(queue) != 0 {
+		t.Errorf("new crawl has queue %v", queue)
+	}
+	if ok, _ := db.Enqueue(a); !ok {
+		t.Error("node visited by the last crawl not queued")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Now()
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	n := &Node{
+		LastSeen:  now.Add(-time.Hour),
+		Client:    "Parity-Ethereum/v2.5.5",
+		Status:    &Status{NetworkID: 61},
+		Endpoints: []Endpoint{{IP: net.ParseIP("10.0.0.1")}},
+	}
+	tests := []struct {
+		q    Query
+		want bool
+	}{
+		{Query{}, true},
+		{Query{Since: now.Add(-24 * time.Hour), NetworkID: 61}, true},
+		{Query{Since: now.Add(-time.Minute)}, false},
+		{Query{NetworkID: 1}, false},
+		{Query{Client: "parity"}, true},
+		{Query{Client: "geth"}, false},
+		{Query{Net: lan}, true},
+	}
+	for i, test := range tests {
+		if got := test.q.Match(n); got != test.want {
+			t.Errorf("query %d: got %v, want %v", i, got, test.want)
+		}
+	}
+	if (&Query{NetworkID: 61}).Match(&Node{}) {
+		t.Error("node without status matched a network")
+	}
+}
+
+func TestEdges(t *testing.T) {
+	db, _ := Open("")
+	defer db.Close()
+
+	var a, b, c enode.ID
+	a[0], b[0], c[0] = 1, 2, 3
+	t0 := time.Unix(1000, 0)
+	db.AddEdges(a, []enode.ID{b, c}, t0)
+	db.AddEdges(a, []enode.ID{b}, t0.Add(time.Hour))
+	db.AddEdges(b, []enode.ID{a}, t0)
+
+	var all, recent []*Edge
+	db.Edges(time.Time{}, func(e *Edge) bool {
+		all = append(all, e)
+		return true
+	})
+	db.Edges(t0.Add(time.Minute), func(e *Edge) bool {
+		recent = append(recent, e)
+		return true
+	})
+	if len(all) != 3 {
+		t.Fatalf("got %d edges, want 3", len(all))
+	}
+	if all[0].From != a || all[0].To != b || !all[0].FirstSeen.Equal(t0) || !all[0].LastSeen.Equal(t0.Add(time.Hour)) {
+		t.Errorf("bad edge %+v", all[0])
+	}
+	if len(recent) != 1 || recent[0].To != b {
+		t.Errorf("recent edges %+v", recent)
+	}
+}
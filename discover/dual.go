@@ -0,0 +1,139 @@
+package discover
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+var (
+	errNoIPv4 = errors.New("no IPv4 discovery socket")
+	errNoIPv6 = errors.New("no IPv6 discovery socket")
+)
+
+// Dual runs the discovery protocol on an IPv4 and an IPv6 socket sharing one
+// node key. Requests go out on the socket of the target's address family, so
+// IPv6 nodes aren't sent packets from an IPv4 socket and vice versa.
+type Dual struct {
+	V4, V6 *Udp // nil if the family isn't available
+}
+
+// ListenDual starts discovery on the given IPv4 and IPv6 sockets.
+// Either of them may be nil, but not both.
+func ListenDual(c4, c6 *net.UDPConn, db *enode.DB, cfg Config) (*Dual, error) {
+	if c4 == nil && c6 == nil {
+		return nil, errors.New("no discovery sockets")
+	}
+	d := new(Dual)
+	for _, s := range []struct {
+		c *net.UDPConn
+		t **Udp
+	}{{c4, &d.V4}, {c6, &d.V6}} {
+		if s.c == nil {
+			continue
+		}
+		_, t, err := ListenUDP(s.c, enode.NewLocalNode(db, cfg.PrivateKey), cfg)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		*s.t = t
+	}
+	return d, nil
+}
+
+// For returns the discovery socket to talk to ip with.
+// IPv4-mapped IPv6 addresses are IPv4.
+func (d *Dual) For(ip net.IP) (*Udp, error) {
+	if ip.To4() != nil {
+		if d.V4 == nil {
+			return nil, errNoIPv4
+		}
+		return d.V4, nil
+	}
+	if d.V6 == nil {
+		return nil, errNoIPv6
+	}
+	return d.V6, nil
+}
+
+// SendPing is Udp.SendPing on the socket for toaddr.
+func (d *Dual) SendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-chan error {
+	t, err := d.For(toaddr.IP)
+	if err != nil {
+		errc := make(chan error, 1)
+		errc <- err
+		return errc
+	}
+	return t.SendPing(toid, toaddr, callback)
+}
+
+// Findnode is Udp.Findnode on the socket for toaddr.
+func (d *Dual) Findnode(toid enode.ID, toaddr *net.UDPAddr, key *ecdsa.PublicKey) ([]*node, error) {
+	t, err := d.For(toaddr.IP)
+	if err != nil {
+		return nil, err
+	}
+	return t.Findnode(toid, toaddr, key)
+}
+
+// RequestENR is Udp.RequestENR on the socket for n.
+func (d *Dual) RequestENR(n *enode.Node) (*enode.Node, error) {
+	t, err := d.For(n.IP())
+	if err != nil {
+		return nil, err
+	}
+	return t.RequestENR(n)
+}
+
+// Lookup runs Table.Lookup on the tables of both sockets at once and merges
+// the results by distance to the target, the closest bucketSize of them.
+func (d *Dual) Lookup(target *ecdsa.PublicKey) []*enode.Node {
+	var (
+		wg      sync.WaitGroup
+		results [2][]*enode.Node
+	)
+	for i, t := range []*Udp{d.V4, d.V6} {
+		if t == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, t *Udp) {
+			defer wg.Done()
+			results[i] = t.tab.Lookup(target)
+		}(i, t)
+	}
+	wg.Wait()
+	key := encodePubkey(target)
+	return mergeByDistance(enode.ID(crypto.Keccak256Hash(key[:])), results[0], results[1])
+}
+
+// mergeByDistance merges lists of nodes into the bucketSize closest to
+// target, each node once.
+func mergeByDistance(target enode.ID, lists ...[]*enode.Node) []*enode.Node {
+	merged := &nodesByDistance{target: target}
+	seen := make(map[enode.ID]bool)
+	for _, l := range lists {
+		for _, n := range l {
+			if !seen[n.ID()] {
+				seen[n.ID()] = true
+				merged.push(wrapNode(n), bucketSize)
+			}
+		}
+	}
+	return unwrapNodes(merged.entries)
+}
+
+// Close closes both sockets and their tables.
+func (d *Dual) Close() {
+	if d.V4 != nil {
+		d.V4.tab.Close()
+	}
+	if d.V6 != nil {
+		d.V6.tab.Close()
+	}
+}
@@ -0,0 +1,116 @@
+package discover
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestUDP_findnodeIPv6Neighbors(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.close()
+	test.remoteaddr = &net.UDPAddr{IP: net.ParseIP("2a01:4f8::1"), Port: 30303}
+	rejected := make(map[string]error)
+	test.udp.rejected = func(from, addr *net.UDPAddr, err error) {
+		rejected[addr.IP.String()] = err
+	}
+
+	rid := enode.PubkeyToIDV4(&test.remotekey.PublicKey)
+	test.table.db.UpdateLastPingReceived(rid, test.remoteaddr.IP, time.Now())
+	resultc := make(chan []*node)
+	go func() {
+		ns, _ := test.udp.findnode(rid, test.remoteaddr, testTarget)
+		resultc <- ns
+	}()
+	test.waitPacketOut(func(p *findnode) {})
+
+	ips := []string{"2a01:4f8::2", "1.2.3.4", "fd00::1", "::1", "2001:db8::1"}
+	var rpclist []rpcNode
+	for _, ip := range ips {
+		key := newkey()
+		rpclist = append(rpclist, nodeToRPC(wrapNode(enode.NewV4(&key.PublicKey, net.ParseIP(ip), 30303, 30303))))
+	}
+	test.packetIn(nil, neighborsPacket, &neighbors{Expiration: futureExp, Nodes: rpclist})
+
+	result := <-resultc
+	if len(result) != 2 || !result[0].IP().Equal(net.ParseIP(ips[0])) || !result[1].IP().Equal(net.ParseIP(ips[1])) {
+		t.Errorf("wrong neighbors: %v", result)
+	}
+	for _, ip := range ips[2:] {
+		if rejected[ip] == nil {
+			t.Errorf("neighbor %s not rejected", ip)
+		}
+	}
+	if len(rejected) != 3 {
+		t.Errorf("wrong rejected neighbors: %v", rejected)
+	}
+}
+
+func TestDual(t *testing.T) {
+	listen := func(network, addr string) *net.UDPConn {
+		c, err := net.ListenUDP(network, &net.UDPAddr{IP: net.ParseIP(addr)})
+		if err != nil {
+			t.Skip("can't listen:", err)
+		}
+		return c
+	}
+	newDual := func(c4, c6 *net.UDPConn) *Dual {
+		db, _ := enode.OpenDB("")
+		d, err := ListenDual(c4, c6, db, Config{PrivateKey: newkey()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	a := newDual(listen("udp4", "127.0.0.1"), listen("udp6", "::1"))
+	defer a.Close()
+	b4, b6 := listen("udp4", "127.0.0.1"), listen("udp6", "::1")
+	b := newDual(b4, b6)
+	defer b.Close()
+
+	bid := b.V4.self().ID()
+	for _, addr := range []net.Addr{b4.LocalAddr(), b6.LocalAddr()} {
+		if err := <-a.SendPing(bid, addr.(*net.UDPAddr), nil); err != nil {
+			t.Errorf("ping %v: %v", addr, err)
+		}
+	}
+
+	v4only := newDual(listen("udp4", "127.0.0.1"), nil)
+	defer v4only.Close()
+	if err := <-v4only.SendPing(bid, b6.LocalAddr().(*net.UDPAddr), nil); err != errNoIPv6 {
+		t.Errorf("ping from v4 only: got %v, want %v", err, errNoIPv6)
+	}
+	if u, err := v4only.For(net.ParseIP("::ffff:1.2.3.4")); u != v4only.V4 || err != nil {
+		t.Errorf("IPv4-mapped address not routed to IPv4 socket")
+	}
+}
+
+func TestDual_mergeByDistance(t *testing.T) {
+	var target enode.ID
+	var v4, v6 []*enode.Node
+	for d := 256; d > 256-bucketSize; d-- {
+		v4 = append(v4, &nodeAtDistance(target, d, net.IP{127, 0, 0, 1}).Node)
+		v6 = append(v6, &nodeAtDistance(target, d-bucketSize, net.ParseIP("::1")).Node)
+	}
+	v6 = append(v6, v4[len(v4)-1])
+
+	merged := mergeByDistance(target, v4, v6)
+	if len(merged) != bucketSize {
+		t.Fatalf("got %d nodes, want %d", len(merged), bucketSize)
+	}
+	seen := make(map[enode.ID]bool)
+	for i, n := range merged {
+		if seen[n.ID()] {
+			t.Errorf("node %v returned twice", n.ID())
+		}
+		seen[n.ID()] = true
+		if i > 0 && enode.DistCmp(target, merged[i-1].ID(), n.ID()) > 0 {
+			t.Errorf("node %d closer than the one before it", i)
+		}
+		if n.IP().To4() != nil {
+			t.Errorf("IPv4 node %v kept over closer IPv6 ones", n.ID())
+		}
+	}
+}
@@ -244,6 +244,47 @@ func (tab *Table) LookupRandom() []*enode.Node {
 	return unwrapNodes(tab.lookup(target, true))
 }
 
+// Lookup finds the nodes closest to the ID of the given key in the network.
+// It waits for the table to be seeded. Lookups start from the nodes
+// revalidation has confirmed, so while there are none, e.g. right after
+// startup, the table's nodes are pinged first.
+func (tab *Table) Lookup(target *ecdsa.PublicKey) []*enode.Node {
+	<-tab.initDone
+	tab.confirmNodes()
+	return unwrapNodes(tab.lookup(encodePubkey(target), true))
+}
+
+// confirmNodes pings the nodes of the table if none of them is confirmed
+// live yet, counting a liveness check for those that answer.
+func (tab *Table) confirmNodes() {
+	tab.mutex.Lock()
+	var unconfirmed []*node
+	for _, b := range &tab.buckets {
+		for _, n := range b.entries {
+			if n.livenessChecks > 0 {
+				tab.mutex.Unlock()
+				return
+			}
+			unconfirmed = append(unconfirmed, n)
+		}
+	}
+	tab.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, n := range unconfirmed {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			if tab.net.ping(n.ID(), n.addr()) == nil {
+				tab.mutex.Lock()
+				n.livenessChecks++
+				tab.mutex.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
 // lookup performs a network search for nodes close to the given target. It approaches the
 // target by querying nodes that are closer to it on each iteration. The given target does
 // not need to be an actual node identifier.
@@ -275,6 +316,9 @@ func (tab *Table) lookup(targetKey encPubkey, refreshIfEmpty bool) []*node {
 		<-tab.refresh()
 		refreshIfEmpty = false
 	}
+	for _, n := range result.entries {
+		seen[n.ID()] = true
+	}
 
 	for {
 		// ask the alpha closest nodes that we haven't asked yet
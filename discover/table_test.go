@@ -400,6 +400,48 @@ func TestTable_Lookup(t *testing.T) {
 	// TODO: check result nodes are actually closest
 }
 
+// meshTransport is a network of nodes each of which knows all the others.
+type meshTransport struct {
+	nodes []*node
+}
+
+func (*meshTransport) self() *enode.Node                             { return nullNode }
+func (*meshTransport) close()                                        {}
+func (*meshTransport) ping(toid enode.ID, toaddr *net.UDPAddr) error { return nil }
+
+func (m *meshTransport) findnode(toid enode.ID, toaddr *net.UDPAddr, target encPubkey) ([]*node, error) {
+	var result []*node
+	for _, n := range m.nodes {
+		if n.ID() != toid {
+			result = append(result, wrapNode(&n.Node))
+		}
+	}
+	return result, nil
+}
+
+func TestTable_LookupNoDuplicates(t *testing.T) {
+	mesh := new(meshTransport)
+	for i := 0; i < 5; i++ {
+		mesh.nodes = append(mesh.nodes, nodeAtDistance(enode.ID{}, 250+i, intIP(i+1)))
+	}
+	tab, db := newTestTable(mesh)
+	defer db.Close()
+	defer tab.Close()
+
+	// The table's nodes are returned by the nodes asked, too.
+	for _, n := range mesh.nodes[:2] {
+		n.livenessChecks = 1
+	}
+	fillTable(tab, mesh.nodes[:2])
+	results := tab.lookup(encPubkey{}, false)
+	if len(results) != len(mesh.nodes) {
+		t.Errorf("wrong number of results: got %d, want %d", len(results), len(mesh.nodes))
+	}
+	if hasDuplicates(results) {
+		t.Errorf("result set contains duplicate entries")
+	}
+}
+
 // This is the test network for the Lookup test.
 // The nodes were obtained by running testnet.mine with a random NodeID as target.
 var lookupTestnet = &preminedTestnet{
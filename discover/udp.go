@@ -29,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/p2p/netutil"
 	"github.com/ethereum/go-ethereum/rlp"
 )
@@ -65,6 +66,8 @@ const (
 	pongPacket
 	findnodePacket
 	neighborsPacket
+	enrRequestPacket
+	enrResponsePacket
 )
 
 // RPC request structures
@@ -108,6 +111,21 @@ type (
 		Rest []rlp.RawValue `rlp:"tail"`
 	}
 
+	// enrRequest queries for the remote node's record.
+	enrRequest struct {
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// enrResponse is the reply to enrRequest.
+	enrResponse struct {
+		ReplyTok []byte // Hash of the enrRequest packet.
+		Record   enr.Record
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	rpcNode struct {
 		IP  net.IP // len 4 for IPv4 or 16 for IPv6
 		UDP uint16 // for discovery protocol
@@ -126,6 +144,11 @@ func SetResponseTimeout(t time.Duration) {
 	respTimeout = t
 }
 
+// IsTimeout reports whether err means the remote didn't reply in time.
+func IsTimeout(err error) bool {
+	return err == errTimeout
+}
+
 func makeEndpoint(addr *net.UDPAddr, tcpPort uint16) rpcEndpoint {
 	ip := net.IP{}
 	if ip4 := addr.IP.To4(); ip4 != nil {
@@ -150,6 +173,9 @@ func (t *Udp) nodeFromRPC(sender *net.UDPAddr, rn rpcNode) (*node, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := t.checkFilter(rn.ID.id(), rn.IP); err != nil {
+		return nil, err
+	}
 	n := wrapNode(enode.NewV4(key, rn.IP, int(rn.TCP), int(rn.UDP)))
 	err = n.ValidateComplete()
 	return n, err
@@ -190,6 +216,8 @@ type Udp struct {
 	db          *enode.DB
 	tab         *Table
 	wg          sync.WaitGroup
+	rejected    func(from, addr *net.UDPAddr, err error)
+	filter      func(id enode.ID, ip net.IP) error
 
 	addReplyMatcher chan *replyMatcher
 	gotreply        chan reply
@@ -253,6 +281,15 @@ type Config struct {
 	NetRestrict *netutil.Netlist  // network whitelist
 	Bootnodes   []*enode.Node     // list of bootstrap nodes
 	Unhandled   chan<- ReadPacket // unhandled packets are sent on this channel
+
+	// Rejected is called with every neighbor a findnode reply relays that
+	// fails validation, e.g. a LAN address relayed by a node on the internet.
+	Rejected func(from, addr *net.UDPAddr, err error)
+
+	// Filter, if set, decides which nodes may be talked to. Requests to nodes it
+	// returns an error for fail with it, their packets are dropped, and neighbors
+	// it filters are rejected.
+	Filter func(id enode.ID, ip net.IP) error
 }
 
 // ListenUDP returns a new table that listens for UDP packets on laddr.
@@ -269,6 +306,8 @@ func newUDP(c conn, ln *enode.LocalNode, cfg Config) (*Table, *Udp, error) {
 		conn:            c,
 		priv:            cfg.PrivateKey,
 		netrestrict:     cfg.NetRestrict,
+		rejected:        cfg.Rejected,
+		filter:          cfg.Filter,
 		localNode:       ln,
 		db:              ln.Database(),
 		closing:         make(chan struct{}),
@@ -303,6 +342,14 @@ func (t *Udp) ourEndpoint() rpcEndpoint {
 	return makeEndpoint(a, uint16(n.TCP()))
 }
 
+// checkFilter applies the configured filter, if any.
+func (t *Udp) checkFilter(id enode.ID, ip net.IP) error {
+	if t.filter == nil {
+		return nil
+	}
+	return t.filter(id, ip)
+}
+
 // ping sends a ping message to the given node and waits for a reply.
 func (t *Udp) ping(toid enode.ID, toaddr *net.UDPAddr) error {
 	return <-t.sendPing(toid, toaddr, nil)
@@ -311,6 +358,11 @@ func (t *Udp) ping(toid enode.ID, toaddr *net.UDPAddr) error {
 // sendPing sends a ping message to the given node and invokes the callback
 // when the reply arrives.
 func (t *Udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-chan error {
+	if err := t.checkFilter(toid, toaddr.IP); err != nil {
+		errc := make(chan error, 1)
+		errc <- err
+		return errc
+	}
 	req := &ping{
 		Version:    4,
 		From:       t.ourEndpoint(),
@@ -345,13 +397,10 @@ func (t *Udp) SendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-ch
 // findnode sends a findnode request to the given node and waits until
 // the node has sent up to k neighbors.
 func (t *Udp) findnode(toid enode.ID, toaddr *net.UDPAddr, target encPubkey) ([]*node, error) {
-	// If we haven't seen a ping from the destination node for a while, it won't remember
-	// our endpoint proof and reject findnode. Solicit a ping first.
-	if time.Since(t.db.LastPingReceived(toid, toaddr.IP)) > bondExpiration {
-		t.ping(toid, toaddr)
-		// Wait for them to ping back and process our pong.
-		time.Sleep(respTimeout)
+	if err := t.checkFilter(toid, toaddr.IP); err != nil {
+		return nil, err
 	}
+	t.ensureBond(toid, toaddr)
 
 	// Add a matcher for 'neighbours' replies to the pending reply queue. The matcher is
 	// active until enough nodes have been received.
@@ -364,6 +413,9 @@ func (t *Udp) findnode(toid enode.ID, toaddr *net.UDPAddr, target encPubkey) ([]
 			n, err := t.nodeFromRPC(toaddr, rn)
 			if err != nil {
 				log.Trace("Invalid neighbor node received", "ip", rn.IP, "addr", toaddr, "err", err)
+				if t.rejected != nil {
+					t.rejected(toaddr, &net.UDPAddr{IP: rn.IP, Port: int(rn.UDP)}, err)
+				}
 				continue
 			}
 			//log.Info("Neighbor", "n", n.String())
@@ -375,12 +427,76 @@ func (t *Udp) findnode(toid enode.ID, toaddr *net.UDPAddr, target encPubkey) ([]
 		Target:     target,
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
 	})
-	return nodes, <-errc
+	// Wait for the reply before reading nodes, the matcher appends to it.
+	err := <-errc
+	return nodes, err
 }
 
+// Findnode asks toid for its neighbors of key. The remote sends up to a
+// bucket of them; if fewer arrive before the timeout, they are returned
+// without an error. Nodes with an empty table don't reply at all.
 func (t *Udp) Findnode(toid  enode.ID, toaddr *net.UDPAddr, key *ecdsa.PublicKey) ([]*node, error) {
 	target := encodePubkey(key)
-	return t.findnode(toid, toaddr, target)
+	nodes, err := t.findnode(toid, toaddr, target)
+	if len(nodes) > 0 && IsTimeout(err) {
+		err = nil
+	}
+	return nodes, err
+}
+
+// ensureBond solicits a ping from the remote node if we haven't seen one for a while.
+// Without it the remote won't remember our endpoint proof and rejects our requests.
+func (t *Udp) ensureBond(toid enode.ID, toaddr *net.UDPAddr) {
+	if time.Since(t.db.LastPingReceived(toid, toaddr.IP)) > bondExpiration {
+		t.ping(toid, toaddr)
+		// Wait for them to ping back and process our pong.
+		time.Sleep(respTimeout)
+	}
+}
+
+// RequestENR sends an enrRequest (EIP-868) to the given node and waits for a response.
+// The returned node carries the remote's current record.
+func (t *Udp) RequestENR(n *enode.Node) (*enode.Node, error) {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	if err := t.checkFilter(n.ID(), addr.IP); err != nil {
+		return nil, err
+	}
+	t.ensureBond(n.ID(), addr)
+
+	req := &enrRequest{
+		Expiration: uint64(time.Now().Add(expiration).Unix()),
+	}
+	packet, hash, err := encodePacket(t.priv, enrRequestPacket, req)
+	if err != nil {
+		return nil, err
+	}
+	// Add a matcher for the reply to the pending reply queue. Responses are matched if
+	// they reference the request we're about to send.
+	var resp *enrResponse
+	errc := t.pending(n.ID(), addr.IP, enrResponsePacket, func(r interface{}) (matched bool, requestDone bool) {
+		matched = bytes.Equal(r.(*enrResponse).ReplyTok, hash)
+		if matched {
+			resp = r.(*enrResponse)
+		}
+		return matched, matched
+	})
+	// Send the packet and wait for the reply.
+	t.write(addr, n.ID(), req.name(), packet)
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	// Verify the response record.
+	respN, err := enode.New(enode.ValidSchemes, &resp.Record)
+	if err != nil {
+		return nil, err
+	}
+	if respN.ID() != n.ID() {
+		return nil, fmt.Errorf("invalid ID in response record")
+	}
+	if err := netutil.CheckRelayIP(addr.IP, respN.IP()); err != nil {
+		return nil, fmt.Errorf("invalid IP in response record: %v", err)
+	}
+	return respN, nil
 }
 
 // pending adds a reply matcher to the pending reply queue.
@@ -612,7 +728,7 @@ func (t *Udp) handlePacket(from *net.UDPAddr, buf []byte) error {
 		return err
 	}
 	fromID := fromKey.id()
-	if err == nil {
+	if err = t.checkFilter(fromID, from.IP); err == nil {
 		err = packet.preverify(t, from, fromID, fromKey)
 	}
 	log.Trace("<< "+packet.name(), "id", fromID, "addr", from, "err", err)
@@ -646,6 +762,10 @@ func decodePacket(buf []byte) (packet, encPubkey, []byte, error) {
 		req = new(findnode)
 	case neighborsPacket:
 		req = new(neighbors)
+	case enrRequestPacket:
+		req = new(enrRequest)
+	case enrResponsePacket:
+		req = new(enrResponse)
 	default:
 		return nil, fromKey, hash, fmt.Errorf("unknown type: %d", ptype)
 	}
@@ -769,6 +889,37 @@ func (req *neighbors) handle(t *Udp, from *net.UDPAddr, fromID enode.ID, mac []b
 
 func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
+func (req *enrRequest) preverify(t *Udp, from *net.UDPAddr, fromID enode.ID, fromKey encPubkey) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if time.Since(t.db.LastPongReceived(fromID, from.IP)) > bondExpiration {
+		return errUnknownNode
+	}
+	return nil
+}
+
+func (req *enrRequest) handle(t *Udp, from *net.UDPAddr, fromID enode.ID, mac []byte) {
+	t.send(from, fromID, enrResponsePacket, &enrResponse{
+		ReplyTok: mac,
+		Record:   *t.localNode.Node().Record(),
+	})
+}
+
+func (req *enrRequest) name() string { return "ENRREQUEST/v4" }
+
+func (req *enrResponse) preverify(t *Udp, from *net.UDPAddr, fromID enode.ID, fromKey encPubkey) error {
+	if !t.handleReply(fromID, from.IP, enrResponsePacket, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
+
+func (req *enrResponse) handle(t *Udp, from *net.UDPAddr, fromID enode.ID, mac []byte) {
+}
+
+func (req *enrResponse) name() string { return "ENRRESPONSE/v4" }
+
 func expired(ts uint64) bool {
 	return time.Unix(int64(ts), 0).Before(time.Now())
 }
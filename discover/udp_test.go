@@ -38,6 +38,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -143,6 +144,7 @@ func TestUDP_packetErrors(t *testing.T) {
 	test.packetIn(errUnsolicitedReply, pongPacket, &pong{ReplyTok: []byte{}, Expiration: futureExp})
 	test.packetIn(errUnknownNode, findnodePacket, &findnode{Expiration: futureExp})
 	test.packetIn(errUnsolicitedReply, neighborsPacket, &neighbors{Expiration: futureExp})
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
 }
 
 func TestUDP_pingTimeout(t *testing.T) {
@@ -356,6 +358,57 @@ func TestUDP_findnodeMultiReply(t *testing.T) {
 	}
 }
 
+// Findnode returns the neighbors that arrived before the timeout without an
+// error, the table's findnode with errTimeout.
+func TestUDP_findnodePartialReply(t *testing.T) {
+	targetKey := &newkey().PublicKey
+	tests := []struct {
+		name    string
+		find    func(u *Udp, toid enode.ID, toaddr *net.UDPAddr) ([]*node, error)
+		wantErr error
+	}{
+		{"findnode", func(u *Udp, toid enode.ID, toaddr *net.UDPAddr) ([]*node, error) {
+			return u.findnode(toid, toaddr, encodePubkey(targetKey))
+		}, errTimeout},
+		{"Findnode", func(u *Udp, toid enode.ID, toaddr *net.UDPAddr) ([]*node, error) {
+			return u.Findnode(toid, toaddr, targetKey)
+		}, nil},
+	}
+	for _, tt := range tests {
+		test := newUDPTest(t)
+		rid := enode.PubkeyToIDV4(&test.remotekey.PublicKey)
+		test.table.db.UpdateLastPingReceived(rid, test.remoteaddr.IP, time.Now())
+
+		type result struct {
+			nodes []*node
+			err   error
+		}
+		resultc := make(chan result, 1)
+		go func() {
+			ns, err := tt.find(test.udp, rid, test.remoteaddr)
+			resultc <- result{ns, err}
+		}()
+		test.waitPacketOut(func(p *findnode) {})
+
+		// Less than a bucket arrives, the request times out waiting for more.
+		n := wrapNode(enode.MustParseV4("enode://ba85011c70bcc5c04d8607d3a0ed29aa6179c092cbdda10d5d32684fb33ed01bd94f588ca8f91ac48318087dcb02eaf36773a7a453f0eedd6742af668097b29c@10.0.1.16:30303?discport=30304"))
+		test.packetIn(nil, neighborsPacket, &neighbors{Expiration: futureExp, Nodes: []rpcNode{nodeToRPC(n)}})
+
+		select {
+		case r := <-resultc:
+			if r.err != tt.wantErr {
+				t.Errorf("%s: got error %v, want %v", tt.name, r.err, tt.wantErr)
+			}
+			if len(r.nodes) != 1 || r.nodes[0].ID() != n.ID() {
+				t.Errorf("%s: neighbors received before the timeout not returned: %v", tt.name, r.nodes)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: did not return within 5 seconds", tt.name)
+		}
+		test.close()
+	}
+}
+
 func TestUDP_pingMatch(t *testing.T) {
 	test := newUDPTest(t)
 	defer test.close()
@@ -451,6 +504,70 @@ func TestUDP_successfulPing(t *testing.T) {
 	}
 }
 
+func TestUDP_ENRRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.close()
+
+	// ensure there's a bond with the test node,
+	// the request won't be answered otherwise.
+	remoteID := encodePubkey(&test.remotekey.PublicKey).id()
+	test.table.db.UpdateLastPongReceived(remoteID, test.remoteaddr.IP, time.Now())
+
+	// check that our own record is returned.
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	wantNode := test.udp.localNode.Node()
+	test.waitPacketOut(func(p *enrResponse) {
+		n, err := enode.New(enode.ValidSchemes, &p.Record)
+		if err != nil {
+			t.Fatalf("invalid record: %v", err)
+		}
+		if !reflect.DeepEqual(n, wantNode) {
+			t.Fatalf("wrong node in enrResponse: %v", n)
+		}
+	})
+}
+
+func TestUDP_RequestENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.close()
+
+	remote := enode.NewV4(&test.remotekey.PublicKey, test.remoteaddr.IP, 30303, test.remoteaddr.Port)
+	test.table.db.UpdateLastPingReceived(remote.ID(), test.remoteaddr.IP, time.Now())
+
+	// queue a pending enr request
+	resultc, errc := make(chan *enode.Node), make(chan error)
+	go func() {
+		n, err := test.udp.RequestENR(remote)
+		if err != nil {
+			errc <- err
+		} else {
+			resultc <- n
+		}
+	}()
+
+	// the remote answers with a record carrying a higher sequence number.
+	var r enr.Record
+	r.Set(enr.IP(test.remoteaddr.IP))
+	r.Set(enr.UDP(test.remoteaddr.Port))
+	r.SetSeq(5)
+	if err := enode.SignV4(&r, test.remotekey); err != nil {
+		t.Fatal(err)
+	}
+	_, hash, _ := test.waitPacketOut(func(*enrRequest) error { return nil })
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: r})
+
+	select {
+	case n := <-resultc:
+		if n.ID() != remote.ID() || n.Seq() != 5 {
+			t.Errorf("wrong node returned: %v (seq %d)", n, n.Seq())
+		}
+	case err := <-errc:
+		t.Errorf("RequestENR error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Error("RequestENR did not return within 5 seconds")
+	}
+}
+
 var testPackets = []struct {
 	input      string
 	wantPacket interface{}
@@ -537,6 +654,52 @@ var testPackets = []struct {
 	},
 }
 
+func TestUDP_filter(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.close()
+	errFiltered := errors.New("filtered")
+	test.udp.filter = func(id enode.ID, ip net.IP) error {
+		if ip.Equal(net.IP{10, 0, 1, 98}) {
+			return errFiltered
+		}
+		return nil
+	}
+	var rejected []error
+	test.udp.rejected = func(from, addr *net.UDPAddr, err error) {
+		rejected = append(rejected, err)
+	}
+
+	toaddr := &net.UDPAddr{IP: net.IP{10, 0, 1, 98}, Port: 30303}
+	if err := test.udp.ping(enode.ID{1}, toaddr); err != errFiltered {
+		t.Errorf("ping to filtered address: got %v, want %v", err, errFiltered)
+	}
+	if _, err := test.udp.findnode(enode.ID{1}, toaddr, testTarget); err != errFiltered {
+		t.Errorf("findnode to filtered address: got %v, want %v", err, errFiltered)
+	}
+	test.packetInFrom(errFiltered, test.remotekey, toaddr, pingPacket, &ping{Version: 4, From: testRemote, To: testLocalAnnounced, Expiration: futureExp})
+
+	rid := enode.PubkeyToIDV4(&test.remotekey.PublicKey)
+	test.table.db.UpdateLastPingReceived(rid, test.remoteaddr.IP, time.Now())
+	resultc := make(chan []*node)
+	go func() {
+		ns, _ := test.udp.findnode(rid, test.remoteaddr, testTarget)
+		resultc <- ns
+	}()
+	test.waitPacketOut(func(p *findnode) {})
+	var rpclist []rpcNode
+	for _, ip := range []net.IP{{10, 0, 1, 97}, {10, 0, 1, 98}} {
+		key := newkey()
+		rpclist = append(rpclist, nodeToRPC(wrapNode(enode.NewV4(&key.PublicKey, ip, 30303, 30303))))
+	}
+	test.packetIn(nil, neighborsPacket, &neighbors{Expiration: futureExp, Nodes: rpclist})
+	if result := <-resultc; len(result) != 1 || !result[0].IP().Equal(net.IP{10, 0, 1, 97}) {
+		t.Errorf("wrong neighbors: %v", result)
+	}
+	if len(rejected) != 1 || rejected[0] != errFiltered {
+		t.Errorf("wrong rejected neighbors: %v", rejected)
+	}
+}
+
 func TestForwardCompatibility(t *testing.T) {
 	testkey, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 	wantNodeKey := encodePubkey(&testkey.PublicKey)
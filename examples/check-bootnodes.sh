@@ -1,8 +1,8 @@
 #!/usr/bin/env bash
 
 # Checks bootnode(enode) reachability for networks supported by a geth client, eg. multi-geth.
-# Enodes are each pinged in their own processes, so this script should only take approximately as long as the
-# timeout limit to run.
+# Each network's enodes are added as peers by one dp2p process, each with its own timeout, so this script should
+# only take approximately as long as the timeout limit to run.
 #
 # Use:
 #  cd $GOPATH/src/github.com/etclabscore/dp2p &&
@@ -58,19 +58,24 @@ do
         sed 's/^ *//g' |
         tee "$data_dir/$net/bootnodes.list"
 
-    while read -r enode; do
-        enode_id="$(echo $enode | cut -d'/' -f2- | cut -d '@' -f1)"
-        (
-            set +e # Must allow dp2p to 'fail', ie exit w/ 1
-            echo "Running $net $enode"
-            start="$(date +%s)"
-            # Python here asks the OS for an open port on the machine.
-            ./dp2p addpeer -a ":$(python -c 'import socket; s=socket.socket(); s.bind(("", 0)); print(s.getsockname()[1]); s.close()')" -t $timeout_lim "$enode" > "$data_dir/$net$enode_id.log" 2>&1
-            res="$?"
-            end="$(date +%s)"
-            echo "$res $net-$enode "'('$((end-start))'s)' >> "$data_dir/$net/outcomes"
-        )&
-    done < "$data_dir/$net/bootnodes.list"
+    (
+        set +e # Must allow dp2p to 'fail', ie exit w/ non-zero
+        echo "Running $net ($(wc -l < "$data_dir/$net/bootnodes.list") bootnodes)"
+        start="$(date +%s)"
+        # All of a network's bootnodes share one dp2p process, each with its own result line.
+        # Python here asks the OS for an open port on the machine.
+        ./dp2p addpeer --retry -a ":$(python -c 'import socket; s=socket.socket(); s.bind(("", 0)); print(s.getsockname()[1]); s.close()')" -t $timeout_lim $(cat "$data_dir/$net/bootnodes.list") > "$data_dir/$net/dp2p.log" 2> "$data_dir/$net/dp2p.err"
+        res="$?"
+        end="$(date +%s)"
+        if grep -q '^enode=.* result=' "$data_dir/$net/dp2p.log"; then
+            grep '^enode=.* result=' "$data_dir/$net/dp2p.log" |
+                sed -E 's/^enode=([0-9a-f]+) .*exit=([0-9]+).*/\2 '"$net"'-\1/' > "$data_dir/$net/outcomes"
+        else
+            # A single bootnode is reported by the exit code alone.
+            echo "$res $net-$(head -1 "$data_dir/$net/bootnodes.list" | cut -d'/' -f3 | cut -d'@' -f1)" > "$data_dir/$net/outcomes"
+        fi
+        echo "$net done ($((end-start))s)"
+    )&
 done
 
 js="$(jobs -p)"
@@ -84,6 +89,7 @@ echo
 echo Outcomes:
 for d in $data_dir/*; do
     oks=$(cat "$d/outcomes" | grep '^0' | wc -l)
-    fails=$(cat "$d/outcomes" | grep '^1' | wc -l)
-    echo -e "$d\tok=$oks\tfails=$fails"
+    fails=$(cat "$d/outcomes" | grep -v '^0' | wc -l)
+    codes=$(cut -d' ' -f1 "$d/outcomes" | grep -v '^0$' | sort -n | uniq -c | awk '{printf "%s:%s ", $2, $1}')
+    echo -e "$d\tok=$oks\tfails=$fails\texit_codes=[ $codes]"
 done
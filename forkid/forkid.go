@@ -0,0 +1,265 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package forkid implements EIP-2124 (https://eips.ethereum.org/EIPS/eip-2124).
+//
+// The vendored go-ethereum only speaks eth/62 and eth/63, which predate fork
+// identifiers, so the computation and validation rules of go-ethereum's
+// core/forkid (v1.9) live here, under its licence.
+package forkid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	// ErrRemoteStale is returned by the filter if a remote fork checksum is a subset
+	// of our already applied forks, but the announced next fork block is not on our
+	// already passed chain.
+	ErrRemoteStale = errors.New("remote needs update")
+
+	// ErrLocalIncompatibleOrStale is returned by the filter if a remote fork checksum
+	// does not match any local checksum variation, signalling that the two chains have
+	// diverged in the past at some point (possibly at genesis).
+	ErrLocalIncompatibleOrStale = errors.New("local incompatible or needs update")
+)
+
+// ID is a fork identifier as defined by EIP-2124.
+type ID struct {
+	Hash [4]byte // CRC32 checksum of the genesis block and passed fork block numbers
+	Next uint64  // Block number of the next upcoming fork, or 0 if no forks are known
+}
+
+func (id ID) String() string {
+	return fmt.Sprintf("%#x/%d", id.Hash, id.Next)
+}
+
+// Verdict classifies a remote fork identifier relative to the local chain.
+type Verdict int
+
+const (
+	// Compatible means the remote is on the same fork as we are, or ahead of us
+	// on our own chain.
+	Compatible Verdict = iota
+	// Stale means the remote is behind on our chain but knows about the next fork
+	// it has to pass, ie. it is syncing or idle but running compatible software.
+	Stale
+	// Incompatible means the remote is on a different chain, or runs software that
+	// does not know about a fork we have already passed.
+	Incompatible
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Compatible:
+		return "compatible"
+	case Stale:
+		return "stale-compatible"
+	case Incompatible:
+		return "incompatible"
+	}
+	return "unknown"
+}
+
+// Filter is a fork id filter to validate a remotely advertised ID.
+type Filter func(id ID) (Verdict, error)
+
+// ENREntry is the "eth" entry advertised in node records of eth/64+ nodes.
+type ENREntry struct {
+	ForkID ID
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e ENREntry) ENRKey() string { return "eth" }
+
+// NewID calculates the Ethereum fork ID from the chain config, genesis hash and head.
+func NewID(config *params.ChainConfig, genesis common.Hash, head uint64) ID {
+	return NewIDFromForks(GatherForks(config), genesis, head)
+}
+
+// NewIDFromForks calculates the fork ID from an explicit, ordered list of fork
+// block numbers. It is used for chains whose forks cannot be expressed by the
+// vendored params.ChainConfig.
+func NewIDFromForks(forks []uint64, genesis common.Hash, head uint64) ID {
+	// Calculate the starting checksum from the genesis hash
+	hash := crc32.ChecksumIEEE(genesis[:])
+
+	// Calculate the current fork checksum and the next fork block
+	var next uint64
+	for _, fork := range forks {
+		if fork <= head {
+			// Fork already passed, checksum the previous hash and the fork number
+			hash = checksumUpdate(hash, fork)
+			continue
+		}
+		next = fork
+		break
+	}
+	return ID{Hash: checksumToBytes(hash), Next: next}
+}
+
+// NewFilter creates a filter that validates remote fork IDs against the local
+// chain config at the given head.
+func NewFilter(config *params.ChainConfig, genesis common.Hash, head uint64) Filter {
+	return NewFilterFromForks(GatherForks(config), genesis, head)
+}
+
+// NewFilterFromForks is the explicit fork list variant of NewFilter.
+func NewFilterFromForks(list []uint64, genesis common.Hash, head uint64) Filter {
+	// Calculate the all the valid fork hash and fork next combos
+	var (
+		forks = append(append([]uint64{}, list...), math.MaxUint64) // Last fork will never be passed
+		sums  = make([][4]byte, len(forks))
+	)
+	hash := crc32.ChecksumIEEE(genesis[:])
+	sums[0] = checksumToBytes(hash)
+	for i, fork := range forks[:len(forks)-1] {
+		hash = checksumUpdate(hash, fork)
+		sums[i+1] = checksumToBytes(hash)
+	}
+	return func(id ID) (Verdict, error) {
+		// Run the fork checksum validation ruleset:
+		//   1. If local and remote FORK_CSUM matches, compare local head to FORK_NEXT.
+		//        The two nodes are in the same fork state currently. They might know
+		//        of differing future forks, but that's not relevant until the fork
+		//        triggers (might be postponed, nodes might be updated to match).
+		//      1a. A remotely announced but remotely not passed block is already passed
+		//          locally, disconnect, since the chains are incompatible.
+		//      1b. No remotely announced fork; or not yet passed locally, connect.
+		//   2. If the remote FORK_CSUM is a subset of the local past forks and the
+		//      remote FORK_NEXT matches with the locally following fork block number,
+		//      connect.
+		//        Remote node is currently syncing. It might eventually diverge from
+		//        us, but at this current point in time we don't have enough information.
+		//   3. If the remote FORK_CSUM is a superset of the local past forks and can
+		//      be completed with locally known future forks, connect.
+		//        Local node is currently syncing. It might eventually diverge from
+		//        the remote, but at this current point in time we don't have enough
+		//        information.
+		//   4. Reject in all other cases.
+		for i, fork := range forks {
+			// If our head is beyond this fork, continue to the next (we have a dummy
+			// fork of maxuint64 as the last item to always fail this check eventually).
+			if head >= fork {
+				continue
+			}
+			// Found the first unpassed fork block, check if our current state matches
+			// the remote checksum (rule #1).
+			if sums[i] == id.Hash {
+				// Fork checksum matched, check if a remote future fork block already passed
+				// locally without the local node being aware of it (rule #1a).
+				if id.Next > 0 && head >= id.Next {
+					return Incompatible, ErrLocalIncompatibleOrStale
+				}
+				// Haven't passed locally a remote-only fork, accept the connection (rule #1b).
+				return Compatible, nil
+			}
+			// The local and remote nodes are in different forks currently, check if the
+			// remote checksum is a subset of our local forks (rule #2).
+			for j := 0; j < i; j++ {
+				if sums[j] == id.Hash {
+					// Remote checksum is a subset, validate based on the announced next fork
+					if forks[j] != id.Next {
+						return Incompatible, ErrRemoteStale
+					}
+					return Stale, nil
+				}
+			}
+			// Remote chain is not a subset of our local chain, check if it's a superset by
+			// any chance, signalling that we're simply out of sync (rule #3).
+			for j := i + 1; j < len(sums); j++ {
+				if sums[j] == id.Hash {
+					// Yay, remote checksum is a superset, ignore upcoming forks
+					return Compatible, nil
+				}
+			}
+			// No exact, subset or superset match. We are on differing chains, reject.
+			return Incompatible, ErrLocalIncompatibleOrStale
+		}
+		// Something very wrong happened, the head is beyond the max uint64 sentinel.
+		return Incompatible, ErrLocalIncompatibleOrStale
+	}
+}
+
+// checksumUpdate calculates the next IEEE CRC32 checksum based on the previous
+// one and a fork block number (equivalent to CRC32(original-blob || fork)).
+func checksumUpdate(hash uint32, fork uint64) uint32 {
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	return crc32.Update(hash, crc32.IEEETable, blob[:])
+}
+
+// checksumToBytes converts a uint32 checksum into a [4]byte array.
+func checksumToBytes(hash uint32) [4]byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], hash)
+	return blob
+}
+
+// GatherForks gathers all the known forks and creates a sorted list out of them.
+func GatherForks(config *params.ChainConfig) []uint64 {
+	// Gather all the fork block numbers via reflection
+	kind := reflect.TypeOf(params.ChainConfig{})
+	conf := reflect.ValueOf(config).Elem()
+
+	var forks []uint64
+	for i := 0; i < kind.NumField(); i++ {
+		// Fetch the next field and skip non-fork rules
+		field := kind.Field(i)
+		if !strings.HasSuffix(field.Name, "Block") {
+			continue
+		}
+		if field.Type != reflect.TypeOf(new(big.Int)) {
+			continue
+		}
+		// Extract the fork rule block number and aggregate it
+		rule := conf.Field(i).Interface().(*big.Int)
+		if rule != nil {
+			forks = append(forks, rule.Uint64())
+		}
+	}
+	return normalizeForks(forks)
+}
+
+// normalizeForks sorts the fork numbers and removes duplicates as well as
+// any fork at block zero, since those are folded into the genesis.
+func normalizeForks(forks []uint64) []uint64 {
+	sort.Slice(forks, func(i, j int) bool { return forks[i] < forks[j] })
+	for i := 1; i < len(forks); i++ {
+		if forks[i] == forks[i-1] {
+			forks = append(forks[:i], forks[i+1:]...)
+			i--
+		}
+	}
+	if len(forks) > 0 && forks[0] == 0 {
+		forks = forks[1:]
+	}
+	return forks
+}
@@ -0,0 +1,45 @@
+package forkid
+
+// The vendored go-ethereum ChainConfig stops at Petersburg and knows nothing
+// of the ECIP forks, so the fork lists of the chains dp2p checks peers against
+// are maintained here, in the order EIP-2124 folds them into the fork id.
+
+// MainnetForks are the forks of Ethereum mainnet. Forks since the merge are
+// scheduled by block timestamp; EIP-6122 adds them to the fork id after the
+// blocks, just like fork blocks, so they follow the blocks here.
+var MainnetForks = []uint64{
+	1150000,    // Homestead
+	1920000,    // DAO
+	2463000,    // Tangerine Whistle (EIP-150)
+	2675000,    // Spurious Dragon (EIP-155, EIP-158)
+	4370000,    // Byzantium
+	7280000,    // Constantinople, Petersburg
+	9069000,    // Istanbul
+	9200000,    // Muir Glacier
+	12244000,   // Berlin
+	12965000,   // London
+	13773000,   // Arrow Glacier
+	15050000,   // Gray Glacier
+	1681338455, // Shanghai (timestamp)
+	1710338135, // Cancun (timestamp)
+	1746612311, // Prague (timestamp)
+	1764798551, // Osaka (timestamp)
+	1765290071, // BPO1 (timestamp)
+	1767747671, // BPO2 (timestamp)
+}
+
+// ClassicForks are the forks of Ethereum Classic.
+var ClassicForks = []uint64{
+	1150000,  // Homestead
+	2500000,  // Tangerine Whistle (EIP-150)
+	3000000,  // Die Hard (EIP-155, EIP-160)
+	5000000,  // Gotham (ECIP-1017)
+	5900000,  // Defuse Difficulty Bomb (ECIP-1041)
+	8772000,  // Atlantis
+	9573000,  // Agharta
+	10500839, // Phoenix
+	11700000, // Thanos
+	13189133, // Magneto
+	14525000, // Mystique
+	19250000, // Spiral
+}
@@ -0,0 +1,78 @@
+package forkid
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// The published fork ids of the maintained fork lists, as in the go-ethereum
+// and core-geth fork id tests.
+func TestMaintainedForks(t *testing.T) {
+	type testcase struct {
+		head uint64
+		want ID
+	}
+	tests := []struct {
+		name  string
+		forks []uint64
+		cases []testcase
+	}{
+		{
+			"classic",
+			ClassicForks,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0xfc64ec04), Next: 1150000}},         // Unsynced
+				{1150000, ID{Hash: checksumToBytes(0x97c2c34c), Next: 2500000}},   // First Homestead block
+				{2500000, ID{Hash: checksumToBytes(0xdb06803f), Next: 3000000}},   // First Tangerine Whistle block
+				{3000000, ID{Hash: checksumToBytes(0xaff4bed4), Next: 5000000}},   // First Die Hard block
+				{5000000, ID{Hash: checksumToBytes(0xf79a63c0), Next: 5900000}},   // First Gotham block
+				{5900000, ID{Hash: checksumToBytes(0x744899d6), Next: 8772000}},   // First Defuse Difficulty Bomb block
+				{8772000, ID{Hash: checksumToBytes(0x518b59c6), Next: 9573000}},   // First Atlantis block
+				{9573000, ID{Hash: checksumToBytes(0x7ba22882), Next: 10500839}},  // First Agharta block
+				{10500839, ID{Hash: checksumToBytes(0x9007bfcc), Next: 11700000}}, // First Phoenix block
+				{11700000, ID{Hash: checksumToBytes(0xdb63a1ca), Next: 13189133}}, // First Thanos block
+				{13189133, ID{Hash: checksumToBytes(0x0f6bf187), Next: 14525000}}, // First Magneto block
+				{14525000, ID{Hash: checksumToBytes(0x7fd1bb25), Next: 19250000}}, // First Mystique block
+				{19250000, ID{Hash: checksumToBytes(0xbe46d57c), Next: 0}},        // First Spiral block
+			},
+		},
+		{
+			"mainnet",
+			MainnetForks,
+			[]testcase{
+				{7280000, ID{Hash: checksumToBytes(0x668db0af), Next: 9069000}},       // First Petersburg block
+				{9069000, ID{Hash: checksumToBytes(0x879d6e30), Next: 9200000}},       // First Istanbul block
+				{9200000, ID{Hash: checksumToBytes(0xe029e991), Next: 12244000}},      // First Muir Glacier block
+				{12244000, ID{Hash: checksumToBytes(0x0eb440f6), Next: 12965000}},     // First Berlin block
+				{12965000, ID{Hash: checksumToBytes(0xb715077d), Next: 13773000}},     // First London block
+				{13773000, ID{Hash: checksumToBytes(0x20c327fc), Next: 15050000}},     // First Arrow Glacier block
+				{15050000, ID{Hash: checksumToBytes(0xf0afd0e3), Next: 1681338455}},   // First Gray Glacier block
+				{1681338455, ID{Hash: checksumToBytes(0xdce96c2d), Next: 1710338135}}, // First Shanghai block
+				{1710338135, ID{Hash: checksumToBytes(0x9f3d2254), Next: 1746612311}}, // First Cancun block
+				{1746612311, ID{Hash: checksumToBytes(0xc376cf8b), Next: 1764798551}}, // First Prague block
+				{1764798551, ID{Hash: checksumToBytes(0x5167e2a6), Next: 1765290071}}, // First Osaka block
+				{1765290071, ID{Hash: checksumToBytes(0xcba2a1c0), Next: 1767747671}}, // First BPO1 block
+				{1767747671, ID{Hash: checksumToBytes(0x07c9462e), Next: 0}},          // First BPO2 block
+			},
+		},
+	}
+	for _, tt := range tests {
+		for i, c := range tt.cases {
+			if have := NewIDFromForks(tt.forks, params.MainnetGenesisHash, c.head); have != c.want {
+				t.Errorf("%s test %d: fork ID mismatch: have %x, want %x", tt.name, i, have, c.want)
+			}
+		}
+	}
+}
+
+// A node past all classic forks accepts classic peers and rejects mainnet ones.
+func TestClassicFilter(t *testing.T) {
+	filter := NewFilterFromForks(ClassicForks, params.MainnetGenesisHash, ClassicForks[len(ClassicForks)-1])
+	if _, err := filter(ID{Hash: checksumToBytes(0xbe46d57c), Next: 0}); err != nil {
+		t.Errorf("classic peer rejected: %v", err)
+	}
+	if _, err := filter(ID{Hash: checksumToBytes(0x07c9462e), Next: 0}); err == nil {
+		t.Errorf("mainnet peer accepted")
+	}
+}
@@ -0,0 +1,120 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Info is what the databases know about an address. Fields not found are
+// left empty.
+type Info struct {
+	Country string // ISO 3166-1 country code
+	City    string // English city name
+	ASN     uint64 // autonomous system number
+	Org     string // ISP or hosting organization, or the autonomous system's
+	Hosting bool   // listed as a hosting provider (GeoIP2 Anonymous IP)
+}
+
+// AS renders the autonomous system with its organization, e.g.
+// "AS16509 Amazon.com, Inc.", or "" if it's unknown.
+func (i *Info) AS() string {
+	if i.ASN == 0 {
+		return i.Org
+	}
+	return strings.TrimSpace(fmt.Sprintf("AS%d %s", i.ASN, i.Org))
+}
+
+// String renders the known fields as key=value pairs.
+func (i *Info) String() string {
+	var fields []string
+	if i.Country != "" {
+		fields = append(fields, "country="+i.Country)
+	}
+	if i.City != "" {
+		fields = append(fields, fmt.Sprintf("city=%q", i.City))
+	}
+	if i.ASN != 0 {
+		fields = append(fields, fmt.Sprintf("asn=%d", i.ASN))
+	}
+	if i.Org != "" {
+		fields = append(fields, fmt.Sprintf("org=%q", i.Org))
+	}
+	if i.Hosting {
+		fields = append(fields, "hosting=true")
+	}
+	return strings.Join(fields, " ")
+}
+
+// DB looks addresses up in several databases at once, e.g. a city and an
+// ASN database.
+type DB struct {
+	readers []*Reader
+}
+
+// OpenDB opens the database files at paths.
+func OpenDB(paths ...string) (*DB, error) {
+	db := new(DB)
+	for _, path := range paths {
+		r, err := Open(path)
+		if err != nil {
+			return nil, err
+		}
+		db.readers = append(db.readers, r)
+	}
+	return db, nil
+}
+
+// Lookup returns what the databases know about ip. The first database
+// knowing a field wins.
+func (db *DB) Lookup(ip net.IP) (*Info, error) {
+	info := new(Info)
+	for _, r := range db.readers {
+		v, err := r.Lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			info.merge(m)
+		}
+	}
+	return info, nil
+}
+
+// merge fills the empty fields of i from a GeoIP2 or GeoLite2 record.
+func (i *Info) merge(m map[string]interface{}) {
+	if i.Country == "" {
+		i.Country = str(path(m, "country", "iso_code"))
+	}
+	if i.Country == "" {
+		i.Country = str(path(m, "registered_country", "iso_code"))
+	}
+	if i.City == "" {
+		i.City = str(path(m, "city", "names", "en"))
+	}
+	if i.ASN == 0 {
+		i.ASN = num(m["autonomous_system_number"])
+	}
+	if i.Org == "" {
+		for _, k := range []string{"organization", "isp", "autonomous_system_organization"} {
+			if i.Org = str(m[k]); i.Org != "" {
+				break
+			}
+		}
+	}
+	if b, ok := m["is_hosting_provider"].(bool); ok && b {
+		i.Hosting = true
+	}
+}
+
+// path follows keys down nested maps.
+func path(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
@@ -0,0 +1,234 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// pointer is encoded as a data section pointer to the given offset.
+type pointer uint
+
+func encode(buf *bytes.Buffer, v interface{}) {
+	head := func(typ, size int) {
+		if typ > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+			return
+		}
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+	switch v := v.(type) {
+	case pointer:
+		buf.WriteByte(typePointer<<5 | byte(v>>8&7))
+		buf.WriteByte(byte(v))
+	case string:
+		if len(v) >= 29 {
+			buf.WriteByte(typeString<<5 | 29)
+			buf.WriteByte(byte(len(v) - 29))
+		} else {
+			head(typeString, len(v))
+		}
+		buf.WriteString(v)
+	case uint32:
+		head(typeUint32, 4)
+		binary.Write(buf, binary.BigEndian, v)
+	case uint16:
+		head(typeUint16, 2)
+		binary.Write(buf, binary.BigEndian, v)
+	case float64:
+		head(typeDouble, 8)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		head(typeBool, size)
+	case []interface{}:
+		head(typeArray, len(v))
+		for _, e := range v {
+			encode(buf, e)
+		}
+	case map[string]interface{}:
+		head(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	default:
+		panic("can't encode")
+	}
+}
+
+// build writes a database holding the given records for the networks,
+// after a data section prefix that records can point into.
+func build(ipVersion, recordSize int, prefix []interface{}, networks map[string]interface{}) []byte {
+	const empty, leaf = -1, -2
+	type child struct{ kind, index int }
+	nodes := [][2]child{{{kind: empty}, {kind: empty}}}
+
+	var data bytes.Buffer
+	for _, v := range prefix {
+		encode(&data, v)
+	}
+	var offsets []int
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	for _, cidr := range cidrs {
+		_, n, _ := net.ParseCIDR(cidr)
+		ip, ones := n.IP, 0
+		if ip4 := ip.To4(); ip4 != nil && len(n.Mask) == 4 {
+			ones, _ = n.Mask.Size()
+			if ipVersion == 6 {
+				ip, ones = append(make([]byte, 12), ip4...), ones+96
+			} else {
+				ip = ip4
+			}
+		} else {
+			ones, _ = n.Mask.Size()
+		}
+		offsets = append(offsets, data.Len())
+		encode(&data, networks[cidr])
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> uint(7-i%8) & 1
+			if i == ones-1 {
+				nodes[node][bit] = child{kind: leaf, index: len(offsets) - 1}
+				break
+			}
+			if nodes[node][bit].kind == empty {
+				nodes = append(nodes, [2]child{{kind: empty}, {kind: empty}})
+				nodes[node][bit] = child{kind: 0, index: len(nodes) - 1}
+			}
+			node = nodes[node][bit].index
+		}
+	}
+
+	var out bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		var rec [2]uint32
+		for bit, c := range n {
+			switch c.kind {
+			case empty:
+				rec[bit] = uint32(count)
+			case leaf:
+				rec[bit] = uint32(count + dataSeparator + offsets[c.index])
+			default:
+				rec[bit] = uint32(c.index)
+			}
+		}
+		switch recordSize {
+		case 24:
+			for _, r := range rec {
+				out.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+			}
+		case 28:
+			out.Write([]byte{byte(rec[0] >> 16), byte(rec[0] >> 8), byte(rec[0]),
+				byte(rec[0]>>20&0xf0 | rec[1]>>24&0x0f), byte(rec[1] >> 16), byte(rec[1] >> 8), byte(rec[1])})
+		case 32:
+			binary.Write(&out, binary.BigEndian, rec)
+		}
+	}
+	out.Write(make([]byte, dataSeparator))
+	out.Write(data.Bytes())
+	out.Write(metadataMarker)
+	encode(&out, map[string]interface{}{
+		"node_count":    uint32(count),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(ipVersion),
+		"database_type": "Test",
+	})
+	return out.Bytes()
+}
+
+func TestLookup(t *testing.T) {
+	org := "Amazon.com, Inc. (a long organization name)"
+	city := map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "DE"},
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Frankfurt am Main"}},
+		"location": map[string]interface{}{
+			"latitude":  50.1,
+			"longitude": 8.7,
+		},
+	}
+	as := map[string]interface{}{
+		"autonomous_system_number":       uint32(16509),
+		"autonomous_system_organization": pointer(0),
+	}
+	nets := map[string]interface{}{
+		"18.192.0.0/11": city,
+		"2a05:d014::/32": map[string]interface{}{
+			"registered_country":  map[string]interface{}{"iso_code": "IE"},
+			"subdivisions":        []interface{}{map[string]interface{}{"iso_code": "L"}},
+			"is_hosting_provider": true,
+		},
+	}
+	for _, size := range []int{24, 28, 32} {
+		r, err := FromBytes(build(6, size, []interface{}{org}, nets))
+		if err != nil {
+			t.Fatalf("record size %d: %v", size, err)
+		}
+		asr, err := FromBytes(build(4, size, []interface{}{org}, map[string]interface{}{"18.192.0.0/11": as}))
+		if err != nil {
+			t.Fatalf("record size %d: %v", size, err)
+		}
+		db := &DB{readers: []*Reader{r, asr}}
+
+		tests := []struct {
+			ip   string
+			want Info
+		}{
+			{"18.197.1.2", Info{Country: "DE", City: "Frankfurt am Main", ASN: 16509, Org: org}},
+			{"2a05:d014:1::1", Info{Country: "IE", Hosting: true}},
+			{"1.2.3.4", Info{}},
+			{"2001:db8::1", Info{}},
+		}
+		for _, test := range tests {
+			info, err := db.Lookup(net.ParseIP(test.ip))
+			if err != nil {
+				t.Fatalf("record size %d: lookup %s: %v", size, test.ip, err)
+			}
+			if !reflect.DeepEqual(*info, test.want) {
+				t.Errorf("record size %d: lookup %s: got %+v, want %+v", size, test.ip, *info, test.want)
+			}
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	r, err := FromBytes(build(6, 28, nil, map[string]interface{}{"10.0.0.0/8": map[string]interface{}{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Metadata.DatabaseType != "Test" || r.Metadata.RecordSize != 28 || r.Metadata.IPVersion != 6 {
+		t.Errorf("bad metadata %+v", r.Metadata)
+	}
+	if _, err := FromBytes([]byte("not a database")); err == nil {
+		t.Error("no error for a file without metadata")
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	i := &Info{Country: "US", City: "Ashburn", ASN: 14618, Org: "Amazon.com, Inc."}
+	if got, want := i.String(), `country=US city="Ashburn" asn=14618 org="Amazon.com, Inc."`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := i.AS(), "AS14618 Amazon.com, Inc."; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,325 @@
+// Package geoip looks up IP addresses in MaxMind DB (mmdb) files offline,
+// such as the GeoLite2 country, city and ASN databases, and extracts the
+// country, city, autonomous system and organization of an address.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+// metadataMarker precedes the metadata section at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the size of the zero bytes between search tree and data.
+const dataSeparator = 16
+
+// Data types of the data section.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errCorrupt = errors.New("mmdb: corrupt database")
+
+// Metadata describes a database.
+type Metadata struct {
+	DatabaseType string
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	BuildEpoch   uint64
+}
+
+// Reader reads a MaxMind DB file.
+type Reader struct {
+	Metadata Metadata
+	tree     []byte
+	data     []byte
+	ipv4Root uint // node where IPv4 addresses start in an IPv6 tree
+}
+
+// Open reads the database file at path.
+func Open(path string) (*Reader, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := FromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+// FromBytes reads a database from its contents.
+func FromBytes(b []byte) (*Reader, error) {
+	i := bytes.LastIndex(b, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("mmdb: no metadata, not a MaxMind DB file")
+	}
+	meta, _, err := decode(b[i+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errCorrupt
+	}
+	r := &Reader{Metadata: Metadata{
+		DatabaseType: str(m["database_type"]),
+		NodeCount:    uint(num(m["node_count"])),
+		RecordSize:   uint(num(m["record_size"])),
+		IPVersion:    uint(num(m["ip_version"])),
+		BuildEpoch:   num(m["build_epoch"]),
+	}}
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.Metadata.RecordSize)
+	}
+	treeSize := r.Metadata.RecordSize * 2 / 8 * r.Metadata.NodeCount
+	if treeSize+dataSeparator > uint(i) {
+		return nil, errCorrupt
+	}
+	r.tree = b[:treeSize]
+	r.data = b[treeSize+dataSeparator : i]
+
+	if r.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.Metadata.NodeCount; j++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Root = node
+	}
+	return r, nil
+}
+
+// record reads the left (0) or right (1) record of a tree node.
+func (r *Reader) record(node uint, bit uint) uint {
+	switch r.Metadata.RecordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+bit*4:]))
+	}
+}
+
+// Lookup returns the record of ip, nil if the database has none.
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node, bits := uint(0), 0
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Root
+		}
+	} else if ip = ip.To16(); ip == nil {
+		return nil, fmt.Errorf("mmdb: invalid IP address")
+	} else if r.Metadata.IPVersion == 4 {
+		return nil, nil
+	}
+	count := r.Metadata.NodeCount
+	for ; bits < len(ip)*8 && node < count; bits++ {
+		node = r.record(node, uint(ip[bits/8]>>(7-uint(bits%8)))&1)
+	}
+	switch {
+	case node == count:
+		return nil, nil
+	case node < count:
+		return nil, errCorrupt
+	}
+	offset := node - count - dataSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, errCorrupt
+	}
+	v, _, err := decode(r.data, offset)
+	return v, err
+}
+
+// decode decodes the value at offset of the data section data, returning
+// it and the offset following it. Maps decode to map[string]interface{},
+// arrays to []interface{}, unsigned integers to uint64 (or *big.Int) and
+// signed ones to int64.
+func decode(data []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, errCorrupt
+	}
+	ctrl := data[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		return decodePointer(data, ctrl, offset)
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(data)) {
+			return nil, 0, errCorrupt
+		}
+		typ = 7 + uint(data[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, errCorrupt
+		}
+		var v uint
+		for _, b := range data[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + v
+		case 2:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			m[key], offset, err = decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = decode(data, offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errCorrupt
+	}
+	b := data[offset : offset+size]
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("mmdb: unknown data type %d", typ)
+}
+
+// decodePointer decodes the value a pointer refers to. The offset returned is
+// the one following the pointer.
+func decodePointer(data []byte, ctrl byte, offset uint) (interface{}, uint, error) {
+	n := uint(ctrl>>3&3) + 1
+	if offset+n > uint(len(data)) {
+		return nil, 0, errCorrupt
+	}
+	var p uint
+	if n < 4 {
+		p = uint(ctrl & 7)
+	}
+	for _, b := range data[offset : offset+n] {
+		p = p<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	if p < uint(len(data)) && data[p]>>5 == typePointer {
+		return nil, 0, errCorrupt
+	}
+	v, _, err := decode(data, p)
+	return v, offset + n, err
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func num(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+// Package netsize estimates the number of nodes in the discovery network from
+// samples: the distances from random targets to the closest nodes lookups
+// find, and the overlap of repeated samples of the network (capture-recapture).
+package netsize
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Estimate is a population estimate with its confidence interval.
+type Estimate struct {
+	N          float64
+	Low, High  float64
+	Confidence float64 // e.g. 0.95
+}
+
+// Distance returns the XOR distance of a and b as a fraction of the ID space,
+// in [0, 1).
+func Distance(a, b enode.ID) float64 {
+	var x [8]byte
+	for i := range x {
+		x[i] = a[i] ^ b[i]
+	}
+	return float64(binary.BigEndian.Uint64(x[:])) / (1 << 64)
+}
+
+// FromDistances estimates the population from the distances of the k-th
+// closest node to each of a number of random targets. With node IDs spread
+// uniformly, the nodes within a distance r of a target are a Poisson sample
+// of rate N·r, so the sum of the distances of m lookups is Gamma(m·k, N)
+// distributed; N is estimated without bias and with a chi-square interval.
+// Lookups missing close nodes bias the estimate low.
+func FromDistances(distances []float64, k int, confidence float64) (Estimate, error) {
+	var sum float64
+	for _, d := range distances {
+		sum += d
+	}
+	shape := float64(len(distances) * k)
+	if shape < 2 || sum <= 0 {
+		return Estimate{}, errors.New("too few distances")
+	}
+	alpha := 1 - confidence
+	return Estimate{
+		N:          (shape - 1) / sum,
+		Low:        chi2Quantile(alpha/2, 2*shape) / (2 * sum),
+		High:       chi2Quantile(1-alpha/2, 2*shape) / (2 * sum),
+		Confidence: confidence,
+	}, nil
+}
+
+// CaptureRecapture estimates the population from repeated samples of it,
+// with the Schnabel estimator: each sample's nodes are marked, and later
+// samples count the marked nodes they catch again. The interval treats the
+// recaptures as Poisson, and neither it nor the estimate go below the number
+// of nodes caught. It also returns the number of recaptures.
+// Samples favoring some nodes, e.g. those in many tables, bias it low.
+func CaptureRecapture(samples []map[enode.ID]bool, confidence float64) (Estimate, int, error) {
+	if len(samples) < 2 {
+		return Estimate{}, 0, errors.New("need at least two samples")
+	}
+	var (
+		marked = make(map[enode.ID]bool)
+		sum    float64 // of catches times nodes marked before
+		recaps int
+	)
+	for i, sample := range samples {
+		if i > 0 {
+			sum += float64(len(sample)) * float64(len(marked))
+			for id := range sample {
+				if marked[id] {
+					recaps++
+				}
+			}
+		}
+		for id := range sample {
+			marked[id] = true
+		}
+	}
+	if recaps == 0 {
+		return Estimate{}, 0, errors.New("no node was caught twice")
+	}
+	alpha := 1 - confidence
+	r := float64(recaps)
+	e := Estimate{
+		N:          sum / r,
+		Low:        sum / (chi2Quantile(1-alpha/2, 2*r+2) / 2),
+		High:       sum / (chi2Quantile(alpha/2, 2*r) / 2),
+		Confidence: confidence,
+	}
+	// There are at least as many nodes as were caught.
+	caught := float64(len(marked))
+	e.N, e.Low, e.High = math.Max(e.N, caught), math.Max(e.Low, caught), math.Max(e.High, caught)
+	return e, recaps, nil
+}
+
+// normQuantile is the quantile function of the standard normal distribution.
+func normQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// chi2Quantile approximates the p quantile of the chi-square distribution
+// with v degrees of freedom (Wilson-Hilferty).
+func chi2Quantile(p, v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	c := 2 / (9 * v)
+	q := 1 - c + normQuantile(p)*math.Sqrt(c)
+	if q < 0 {
+		return 0
+	}
+	return v * q * q * q
+}
@@ -0,0 +1,88 @@
+package netsize
+
+import (
+	"crypto/rand"
+	"math"
+	mrand "math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func randomID() (id enode.ID) {
+	rand.Read(id[:])
+	return id
+}
+
+func TestChi2Quantile(t *testing.T) {
+	tests := []struct {
+		p, v, want float64
+	}{
+		{0.975, 10, 20.483},
+		{0.025, 10, 3.247},
+		{0.95, 100, 124.342},
+		{0.5, 2000, 1999.33},
+	}
+	for _, test := range tests {
+		if got := chi2Quantile(test.p, test.v); math.Abs(got-test.want)/test.want > 0.02 {
+			t.Errorf("chi2Quantile(%v, %v) = %v, want %v", test.p, test.v, got, test.want)
+		}
+	}
+}
+
+func TestFromDistances(t *testing.T) {
+	const n, k = 5000, 16
+	nodes := make([]enode.ID, n)
+	for i := range nodes {
+		nodes[i] = randomID()
+	}
+	var distances []float64
+	for i := 0; i < 100; i++ {
+		target := randomID()
+		ds := make([]float64, n)
+		for j, id := range nodes {
+			ds[j] = Distance(target, id)
+		}
+		sort.Float64s(ds)
+		distances = append(distances, ds[k-1])
+	}
+	e, err := FromDistances(distances, k, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Low > n || e.High < n || math.Abs(e.N-n)/n > 0.15 {
+		t.Errorf("estimate %.0f (%.0f-%.0f) for %d nodes", e.N, e.Low, e.High, n)
+	}
+	if _, err := FromDistances(nil, k, 0.95); err == nil {
+		t.Error("no error without distances")
+	}
+}
+
+func TestCaptureRecapture(t *testing.T) {
+	const n = 2000
+	nodes := make([]enode.ID, n)
+	for i := range nodes {
+		nodes[i] = randomID()
+	}
+	var samples []map[enode.ID]bool
+	for i := 0; i < 4; i++ {
+		sample := make(map[enode.ID]bool)
+		for _, j := range mrand.Perm(n)[:400] {
+			sample[nodes[j]] = true
+		}
+		samples = append(samples, sample)
+	}
+	e, recaps, err := CaptureRecapture(samples, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recaps == 0 || e.Low > n || e.High < n || math.Abs(e.N-n)/n > 0.2 {
+		t.Errorf("estimate %.0f (%.0f-%.0f) for %d nodes, %d recaptures", e.N, e.Low, e.High, n, recaps)
+	}
+
+	disjoint := []map[enode.ID]bool{{nodes[0]: true}, {nodes[1]: true}}
+	if _, _, err := CaptureRecapture(disjoint, 0.95); err == nil {
+		t.Error("no error without recaptures")
+	}
+}
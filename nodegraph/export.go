@@ -0,0 +1,200 @@
+package nodegraph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats lists the export formats.
+var Formats = []string{"dot", "graphml", "gexf", "json"}
+
+// Write exports g in the given format.
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "graphml":
+		return g.WriteGraphML(w)
+	case "gexf":
+		return g.WriteGEXF(w)
+	case "json":
+		return g.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown graph format %q (want %s)", format, strings.Join(Formats, ", "))
+}
+
+// WriteDOT exports g in the Graphviz DOT language.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph neighbors {\n")
+	for _, v := range g.Vertices {
+		fmt.Fprintf(&b, "  %q [label=%q addr=%q client=%q];\n", v.ID.String(), v.ID.TerminalString(), v.Addr, v.Client)
+	}
+	g.Edges(func(from, to *Vertex) {
+		fmt.Fprintf(&b, "  %q -> %q;\n", from.ID.String(), to.ID.String())
+	})
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphml struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphmlNode `xml:"node"`
+		Edges       []graphmlEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// WriteGraphML exports g as GraphML.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := &graphml{Xmlns: "http://graphml.graphdrawing.org/xmlns"}
+	doc.Keys = []graphmlKey{
+		{"addr", "node", "addr", "string"},
+		{"client", "node", "client", "string"},
+		{"in", "node", "indegree", "int"},
+		{"out", "node", "outdegree", "int"},
+	}
+	doc.Graph.EdgeDefault = "directed"
+	for _, v := range g.Vertices {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{v.ID.String(), []graphmlData{
+			{"addr", v.Addr},
+			{"client", v.Client},
+			{"in", strconv.Itoa(v.InDegree())},
+			{"out", strconv.Itoa(v.OutDegree())},
+		}})
+	}
+	g.Edges(func(from, to *Vertex) {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{from.ID.String(), to.ID.String()})
+	})
+	return writeXML(w, doc)
+}
+
+type gexfAttr struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     int    `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Graph   struct {
+		DefaultEdgeType string `xml:"defaultedgetype,attr"`
+		Attributes      struct {
+			Class string     `xml:"class,attr"`
+			Attrs []gexfAttr `xml:"attribute"`
+		} `xml:"attributes"`
+		Nodes []gexfNode `xml:"nodes>node"`
+		Edges []gexfEdge `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+// WriteGEXF exports g as GEXF 1.2.
+func (g *Graph) WriteGEXF(w io.Writer) error {
+	doc := &gexf{Xmlns: "http://www.gexf.net/1.2draft", Version: "1.2"}
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes.Class = "node"
+	doc.Graph.Attributes.Attrs = []gexfAttr{
+		{"addr", "addr", "string"},
+		{"client", "client", "string"},
+	}
+	for _, v := range g.Vertices {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{v.ID.String(), v.ID.TerminalString(), []gexfValue{
+			{"addr", v.Addr},
+			{"client", v.Client},
+		}})
+	}
+	g.Edges(func(from, to *Vertex) {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{len(doc.Graph.Edges), from.ID.String(), to.ID.String()})
+	})
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type jsonNode struct {
+	ID     string `json:"id"`
+	Addr   string `json:"addr,omitempty"`
+	Client string `json:"client,omitempty"`
+	In     int    `json:"in"`
+	Out    int    `json:"out"`
+}
+
+type jsonEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// WriteJSON exports g as a JSON object with nodes and edges lists.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	doc := struct {
+		Nodes []jsonNode `json:"nodes"`
+		Edges []jsonEdge `json:"edges"`
+	}{Nodes: []jsonNode{}, Edges: []jsonEdge{}}
+	for _, v := range g.Vertices {
+		doc.Nodes = append(doc.Nodes, jsonNode{v.ID.String(), v.Addr, v.Client, v.InDegree(), v.OutDegree()})
+	}
+	g.Edges(func(from, to *Vertex) {
+		doc.Edges = append(doc.Edges, jsonEdge{from.ID.String(), to.ID.String()})
+	})
+	return json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,209 @@
+// Package nodegraph holds the directed graph of discovery neighbors, where
+// an edge goes from a node to each node it reported in a NEIGHBORS reply.
+// It computes topology metrics and exports the graph as DOT, GraphML, GEXF
+// or JSON.
+package nodegraph
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Vertex is a node of the graph.
+type Vertex struct {
+	ID     enode.ID
+	Addr   string // optional attributes, exported with the graph
+	Client string
+
+	index int
+	out   []int // indices of the reported nodes
+	in    int
+}
+
+// InDegree is the number of nodes that reported v, i.e. had it in their table.
+func (v *Vertex) InDegree() int { return v.in }
+
+// OutDegree is the number of nodes v reported.
+func (v *Vertex) OutDegree() int { return len(v.out) }
+
+// Graph is a directed neighbor graph.
+type Graph struct {
+	Vertices []*Vertex // in the order they were added
+	ids      map[enode.ID]int
+	edges    map[[2]int]bool
+}
+
+// New creates an empty graph.
+func New() *Graph {
+	return &Graph{ids: make(map[enode.ID]int), edges: make(map[[2]int]bool)}
+}
+
+// Vertex returns the vertex of id, adding it if needed.
+func (g *Graph) Vertex(id enode.ID) *Vertex {
+	if i, ok := g.ids[id]; ok {
+		return g.Vertices[i]
+	}
+	v := &Vertex{ID: id, index: len(g.Vertices)}
+	g.ids[id] = v.index
+	g.Vertices = append(g.Vertices, v)
+	return v
+}
+
+// Lookup returns the vertex of id, nil if it isn't in the graph.
+func (g *Graph) Lookup(id enode.ID) *Vertex {
+	if i, ok := g.ids[id]; ok {
+		return g.Vertices[i]
+	}
+	return nil
+}
+
+// AddEdge adds the edge from -> to, unless it's there already.
+// Self-references are ignored.
+func (g *Graph) AddEdge(from, to enode.ID) {
+	if from == to {
+		return
+	}
+	f, t := g.Vertex(from), g.Vertex(to)
+	k := [2]int{f.index, t.index}
+	if g.edges[k] {
+		return
+	}
+	g.edges[k] = true
+	f.out = append(f.out, t.index)
+	t.in++
+}
+
+// NumEdges returns the number of edges.
+func (g *Graph) NumEdges() int {
+	return len(g.edges)
+}
+
+// Edges calls f with each edge, grouped by the reporting node.
+func (g *Graph) Edges(f func(from, to *Vertex)) {
+	for _, v := range g.Vertices {
+		for _, t := range v.out {
+			f(v, g.Vertices[t])
+		}
+	}
+}
+
+// Reporters returns the number of vertices with outgoing edges, i.e. the
+// nodes whose NEIGHBORS replies are in the graph.
+func (g *Graph) Reporters() int {
+	var n int
+	for _, v := range g.Vertices {
+		if len(v.out) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Distribution summarizes a degree distribution.
+type Distribution struct {
+	Min, Median, Max int
+	Mean             float64
+	Counts           map[int]int // vertices by degree
+}
+
+// InDegrees returns the in-degree distribution over all vertices.
+func (g *Graph) InDegrees() *Distribution {
+	return g.distribution(func(v *Vertex) (int, bool) { return v.in, true })
+}
+
+// OutDegrees returns the out-degree distribution over the reporting vertices.
+// Nodes never asked for neighbors would only skew it towards zero.
+func (g *Graph) OutDegrees() *Distribution {
+	return g.distribution(func(v *Vertex) (int, bool) { return len(v.out), len(v.out) > 0 })
+}
+
+func (g *Graph) distribution(degree func(*Vertex) (int, bool)) *Distribution {
+	d := &Distribution{Counts: make(map[int]int)}
+	var degrees []int
+	for _, v := range g.Vertices {
+		if k, ok := degree(v); ok {
+			degrees = append(degrees, k)
+			d.Counts[k]++
+		}
+	}
+	if len(degrees) == 0 {
+		return d
+	}
+	sort.Ints(degrees)
+	d.Min, d.Max, d.Median = degrees[0], degrees[len(degrees)-1], degrees[len(degrees)/2]
+	var sum int
+	for _, k := range degrees {
+		sum += k
+	}
+	d.Mean = float64(sum) / float64(len(degrees))
+	return d
+}
+
+// Components returns the strongly connected components, largest first.
+func (g *Graph) Components() [][]*Vertex {
+	// Tarjan's algorithm.
+	var (
+		index   = make([]int, len(g.Vertices))
+		low     = make([]int, len(g.Vertices))
+		onStack = make([]bool, len(g.Vertices))
+		stack   []int
+		next    = 1
+		comps   [][]*Vertex
+	)
+	var connect func(v int)
+	connect = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range g.Vertices[v].out {
+			if index[w] == 0 {
+				connect(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		var comp []*Vertex
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			comp = append(comp, g.Vertices[w])
+			if w == v {
+				break
+			}
+		}
+		comps = append(comps, comp)
+	}
+	for v := range g.Vertices {
+		if index[v] == 0 {
+			connect(v)
+		}
+	}
+	sort.SliceStable(comps, func(i, j int) bool { return len(comps[i]) > len(comps[j]) })
+	return comps
+}
+
+// MostReported returns the n vertices with the highest in-degree, i.e. the
+// nodes found in the most tables.
+func (g *Graph) MostReported(n int) []*Vertex {
+	vs := append([]*Vertex{}, g.Vertices...)
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].in != vs[j].in {
+			return vs[i].in > vs[j].in
+		}
+		return bytes.Compare(vs[i].ID[:], vs[j].ID[:]) < 0
+	})
+	if len(vs) > n {
+		vs = vs[:n]
+	}
+	return vs
+}
@@ -0,0 +1,126 @@
+package nodegraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func id(b byte) enode.ID {
+	var id enode.ID
+	id[0] = b
+	return id
+}
+
+// testGraph has the cycle 1 -> 2 -> 3 -> 1, with 3 -> 4 and 4 -> 5 -> 4 hanging off it.
+func testGraph() *Graph {
+	g := New()
+	for _, e := range [][2]byte{{1, 2}, {2, 3}, {3, 1}, {3, 4}, {4, 5}, {5, 4}, {1, 2}, {1, 1}} {
+		g.AddEdge(id(e[0]), id(e[1]))
+	}
+	g.Vertex(id(6)) // known, but neither reporting nor reported
+	return g
+}
+
+func TestDegrees(t *testing.T) {
+	g := testGraph()
+	if n := g.NumEdges(); n != 6 {
+		t.Errorf("%d edges, want 6", n)
+	}
+	if n := g.Reporters(); n != 5 {
+		t.Errorf("%d reporters, want 5", n)
+	}
+	in := g.InDegrees()
+	if in.Min != 0 || in.Max != 2 || in.Counts[1] != 4 || in.Counts[2] != 1 || in.Counts[0] != 1 {
+		t.Errorf("in-degrees %+v", in)
+	}
+	out := g.OutDegrees()
+	if out.Min != 1 || out.Max != 2 || out.Counts[1] != 4 || out.Mean != 6.0/5 {
+		t.Errorf("out-degrees %+v", out)
+	}
+	if top := g.MostReported(1); len(top) != 1 || top[0].ID != id(4) {
+		t.Errorf("most reported %v", top)
+	}
+}
+
+func TestComponents(t *testing.T) {
+	comps := testGraph().Components()
+	var sizes []int
+	for _, c := range comps {
+		sizes = append(sizes, len(c))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("component sizes %v, want [3 2 1]", sizes)
+	}
+	members := make(map[enode.ID]bool)
+	for _, v := range comps[0] {
+		members[v.ID] = true
+	}
+	if !members[id(1)] || !members[id(2)] || !members[id(3)] {
+		t.Errorf("largest component %v", comps[0])
+	}
+}
+
+func TestExport(t *testing.T) {
+	g := testGraph()
+	g.Vertex(id(1)).Addr = "10.0.0.1:30303"
+	g.Vertex(id(1)).Client = `Geth/v1.9.0 "quoted" <&>`
+
+	var buf bytes.Buffer
+	if err := g.Write(&buf, "dot"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"`+id(1).String()+`" -> "`+id(2).String()+`";`) {
+		t.Errorf("DOT output lacks an edge:\n%s", buf.String())
+	}
+
+	for _, format := range []string{"graphml", "gexf"} {
+		buf.Reset()
+		if err := g.Write(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"graph>node"`
+			GEXFNodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"graph>nodes>node"`
+			Edges     []struct{} `xml:"graph>edge"`
+			GEXFEdges []struct{} `xml:"graph>edges>edge"`
+		}
+		if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		nodes, edges := len(doc.Nodes)+len(doc.GEXFNodes), len(doc.Edges)+len(doc.GEXFEdges)
+		if nodes != 6 || edges != 6 {
+			t.Errorf("%s: %d nodes, %d edges, want 6 and 6", format, nodes, edges)
+		}
+		if !strings.Contains(buf.String(), "&#34;quoted&#34; &lt;&amp;&gt;") {
+			t.Errorf("%s: client not escaped", format)
+		}
+	}
+
+	buf.Reset()
+	if err := g.Write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Nodes []jsonNode
+		Edges []jsonEdge
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Nodes) != 6 || len(doc.Edges) != 6 || doc.Nodes[0].Out != 1 {
+		t.Errorf("JSON output %+v", doc)
+	}
+
+	if err := g.Write(&buf, "svg"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,219 @@
+// Package policy decides which nodes dp2p may talk to: CIDR allow and deny
+// lists, denied node IDs, and whether LAN and loopback addresses are allowed.
+//
+// A policy is built from rules, given as flags or read from a file with one
+// rule per line:
+//
+//	# probe the test network only
+//	allow 10.0.0.0/8
+//	deny 10.1.0.0/16
+//	deny lan
+//	deny loopback
+//	deny-id 5c4f1d...
+//
+// Deny rules win over allow rules. Without allow rules, all networks not
+// denied are allowed.
+package policy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+)
+
+// Policy is a set of rules. The zero value allows everything.
+type Policy struct {
+	allow        []*net.IPNet
+	deny         []*net.IPNet
+	denyIDs      map[enode.ID]bool
+	denyLAN      bool
+	denyLoopback bool
+}
+
+// Violation is the error for a node the policy doesn't allow.
+type Violation struct {
+	Rule string // the rule, or "allow" if no allow rule matched
+}
+
+func (v *Violation) Error() string {
+	return "filtered by policy: " + v.Rule
+}
+
+// Load reads the rules in file.
+func Load(file string) (*Policy, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := new(Policy)
+	if err := p.Read(f); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return p, nil
+}
+
+// Read adds the rules read from r, one per line. Blank lines and lines
+// starting with # are skipped.
+func (p *Policy) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: want <allow|deny|deny-id> <value>", line)
+		}
+		if err := p.Add(fields[0], fields[1]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Add adds a rule:
+//
+//	allow <cidr or ip>
+//	deny <cidr or ip|lan|loopback>
+//	deny-id <node id, public key or enode URL>
+func (p *Policy) Add(verb, value string) error {
+	switch verb {
+	case "allow":
+		n, err := parseNet(value)
+		if err != nil {
+			return err
+		}
+		p.allow = append(p.allow, n)
+	case "deny":
+		switch value {
+		case "lan":
+			p.denyLAN = true
+		case "loopback":
+			p.denyLoopback = true
+		default:
+			n, err := parseNet(value)
+			if err != nil {
+				return err
+			}
+			p.deny = append(p.deny, n)
+		}
+	case "deny-id":
+		id, err := parseID(value)
+		if err != nil {
+			return err
+		}
+		if p.denyIDs == nil {
+			p.denyIDs = make(map[enode.ID]bool)
+		}
+		p.denyIDs[id] = true
+	default:
+		return fmt.Errorf("unknown rule %q", verb)
+	}
+	return nil
+}
+
+// parseNet parses a CIDR, or a single IP as a network of its own.
+func parseNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+func parseID(s string) (enode.ID, error) {
+	if strings.HasPrefix(s, "enode://") {
+		n, err := enode.ParseV4(s)
+		if err != nil {
+			return enode.ID{}, err
+		}
+		return n.ID(), nil
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return enode.ID{}, fmt.Errorf("invalid node id %q: %v", s, err)
+	}
+	var id enode.ID
+	switch len(b) {
+	case len(id):
+		copy(id[:], b)
+		return id, nil
+	case 64:
+		// A public key, as in enode URLs.
+		key, err := crypto.UnmarshalPubkey(append([]byte{4}, b...))
+		if err != nil {
+			return id, fmt.Errorf("invalid node key %q: %v", s, err)
+		}
+		return enode.PubkeyToIDV4(key), nil
+	}
+	return id, fmt.Errorf("invalid node id %q: want %d bytes, or a 64 byte public key", s, len(id))
+}
+
+// CheckIP returns a *Violation if the policy doesn't allow ip.
+func (p *Policy) CheckIP(ip net.IP) error {
+	switch {
+	case p.denyLoopback && ip.IsLoopback():
+		return &Violation{"deny loopback"}
+	case p.denyLAN && !ip.IsLoopback() && netutil.IsLAN(ip):
+		return &Violation{"deny lan"}
+	}
+	for _, n := range p.deny {
+		if n.Contains(ip) {
+			return &Violation{"deny " + n.String()}
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, n := range p.allow {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return &Violation{"allow"}
+}
+
+// Check returns a *Violation if the policy doesn't allow the node id at ip.
+func (p *Policy) Check(id enode.ID, ip net.IP) error {
+	if p.denyIDs[id] {
+		return &Violation{"deny-id " + id.TerminalString()}
+	}
+	return p.CheckIP(ip)
+}
+
+// CheckNode is Check for n.
+func (p *Policy) CheckNode(n *enode.Node) error {
+	return p.Check(n.ID(), n.IP())
+}
+
+// NetRestrict returns the allowed networks as a whitelist for the p2p
+// server and discovery configs, or nil if all networks are allowed.
+func (p *Policy) NetRestrict() *netutil.Netlist {
+	if len(p.allow) == 0 {
+		return nil
+	}
+	list := make(netutil.Netlist, len(p.allow))
+	for i, n := range p.allow {
+		list[i] = *n
+	}
+	return &list
+}
+
+// Empty reports whether the policy has no rules.
+func (p *Policy) Empty() bool {
+	return len(p.allow) == 0 && len(p.deny) == 0 && len(p.denyIDs) == 0 && !p.denyLAN && !p.denyLoopback
+}
@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+const testRules = `
+# private net testing
+allow 10.0.0.0/8
+allow fd00::/8
+deny 10.1.0.0/16
+deny 10.2.3.4
+deny-id 0x0102030000000000000000000000000000000000000000000000000000000000
+deny loopback
+`
+
+func TestPolicy(t *testing.T) {
+	p := new(Policy)
+	if err := p.Read(strings.NewReader(testRules)); err != nil {
+		t.Fatal(err)
+	}
+	denied := enode.ID{1, 2, 3}
+	tests := []struct {
+		id   enode.ID
+		ip   string
+		rule string // "" if allowed
+	}{
+		{enode.ID{}, "10.0.0.1", ""},
+		{enode.ID{}, "fd00::1", ""},
+		{enode.ID{}, "10.1.2.3", "deny 10.1.0.0/16"},
+		{enode.ID{}, "10.2.3.4", "deny 10.2.3.4/32"},
+		{enode.ID{}, "10.2.3.5", ""},
+		{enode.ID{}, "1.2.3.4", "allow"},
+		{enode.ID{}, "2a01:4f8::1", "allow"},
+		{enode.ID{}, "127.0.0.1", "deny loopback"},
+		{enode.ID{}, "::1", "deny loopback"},
+		{denied, "10.0.0.1", "deny-id " + denied.TerminalString()},
+	}
+	for _, test := range tests {
+		err := p.Check(test.id, net.ParseIP(test.ip))
+		switch {
+		case test.rule == "" && err != nil:
+			t.Errorf("%s: unexpected %v", test.ip, err)
+		case test.rule != "" && (err == nil || err.(*Violation).Rule != test.rule):
+			t.Errorf("%s: got %v, want rule %q", test.ip, err, test.rule)
+		}
+	}
+	if nr := p.NetRestrict(); nr == nil || !nr.Contains(net.ParseIP("10.9.9.9")) || nr.Contains(net.ParseIP("1.2.3.4")) {
+		t.Errorf("wrong NetRestrict %v", nr)
+	}
+}
+
+func TestPolicyLAN(t *testing.T) {
+	p := new(Policy)
+	if !p.Empty() || p.CheckIP(net.ParseIP("192.168.1.1")) != nil || p.NetRestrict() != nil {
+		t.Fatal("zero policy isn't empty")
+	}
+	p.Add("deny", "lan")
+	for ip, denied := range map[string]bool{
+		"192.168.1.1": true,
+		"10.0.0.1":    true,
+		"fe80::1":     true,
+		"fd00::1":     true,
+		"127.0.0.1":   false, // loopback has its own rule
+		"1.2.3.4":     false,
+		"2a01:4f8::1": false,
+	} {
+		if err := p.CheckIP(net.ParseIP(ip)); (err != nil) != denied {
+			t.Errorf("%s: got %v, want denied %v", ip, err, denied)
+		}
+	}
+}
+
+func TestPolicyDenyKey(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	id := enode.PubkeyToIDV4(&key.PublicKey)
+	pub := hex.EncodeToString(crypto.FromECDSAPub(&key.PublicKey)[1:])
+	for _, value := range []string{pub, "enode://" + pub + "@1.2.3.4:30303", id.String()} {
+		p := new(Policy)
+		if err := p.Add("deny-id", value); err != nil {
+			t.Fatal(err)
+		}
+		if p.Check(id, net.ParseIP("1.2.3.4")) == nil {
+			t.Errorf("%s: node not denied", value)
+		}
+	}
+}
+
+func TestPolicyBadRules(t *testing.T) {
+	for _, rule := range []string{"allow lan", "deny 10.0.0.0/33", "deny-id 0102", "permit 10.0.0.0/8", "allow"} {
+		if err := new(Policy).Read(strings.NewReader(rule)); err == nil {
+			t.Errorf("%q: no error", rule)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rlpx
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+	prv0, _ := crypto.GenerateKey()
+	prv1, _ := crypto.GenerateKey()
+	fd0, fd1 := net.Pipe()
+	c0, c1 := NewConn(fd0), NewConn(fd1)
+	defer c0.Close(nil)
+	defer c1.Close(nil)
+
+	hello0 := &ProtoHandshake{Version: BaseProtocolVersion, Name: "zero", Caps: []p2p.Cap{{Name: "eth", Version: 63}}, ID: crypto.FromECDSAPub(&prv0.PublicKey)[1:], Rest: []rlp.RawValue{}}
+	hello1 := &ProtoHandshake{Version: BaseProtocolVersion, Name: "one", Caps: []p2p.Cap{{Name: "les", Version: 2}}, ID: crypto.FromECDSAPub(&prv1.PublicKey)[1:], Rest: []rlp.RawValue{}}
+
+	done := make(chan error, 1)
+	go func() {
+		pub, err := c1.DoEncHandshake(prv1, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		if !reflect.DeepEqual(pub, &prv0.PublicKey) {
+			t.Errorf("recipient saw wrong remote key")
+		}
+		their, err := c1.DoProtoHandshake(hello1)
+		if err == nil && !reflect.DeepEqual(their, hello0) {
+			t.Errorf("recipient got wrong hello:\n got %+v\nwant %+v", their, hello0)
+		}
+		done <- err
+	}()
+
+	pub, err := c0.DoEncHandshake(prv0, &prv1.PublicKey)
+	if err != nil {
+		t.Fatal("initiator enc handshake:", err)
+	}
+	if !reflect.DeepEqual(pub, &prv1.PublicKey) {
+		t.Errorf("initiator saw wrong remote key")
+	}
+	their, err := c0.DoProtoHandshake(hello0)
+	if err != nil {
+		t.Fatal("initiator proto handshake:", err)
+	}
+	if !reflect.DeepEqual(their, hello1) {
+		t.Errorf("initiator got wrong hello:\n got %+v\nwant %+v", their, hello1)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("recipient:", err)
+	}
+}
+
+func TestDecodeDisconnect(t *testing.T) {
+	tests := []struct {
+		payload interface{}
+		want    p2p.DiscReason
+	}{
+		{[]uint{uint(p2p.DiscTooManyPeers)}, p2p.DiscTooManyPeers},
+		{uint(p2p.DiscUselessPeer), p2p.DiscUselessPeer},
+		{[]uint{}, p2p.DiscRequested},
+	}
+	for _, test := range tests {
+		size, r, err := rlp.EncodeToReader(test.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := p2p.Msg{Code: DiscMsg, Size: uint32(size), Payload: r}
+		if got := DecodeDisconnect(msg); got != test.want {
+			t.Errorf("payload %v: got %v, want %v", test.payload, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package socks5 is a minimal SOCKS5 client (RFC 1928) for dialing TCP
+// connections through a proxy. It only implements CONNECT, without
+// authentication or with a username and password (RFC 1929).
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	version5 = 5
+
+	authNone     = 0
+	authPassword = 2
+	authNoAccept = 0xff
+	authVersion  = 1 // of the username/password subnegotiation
+
+	cmdConnect = 1
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+// Dialer dials TCP connections through a SOCKS5 proxy.
+type Dialer struct {
+	ProxyAddr string // host:port of the proxy
+	Username  string // empty for no authentication
+	Password  string
+}
+
+// Parse parses a proxy URL, socks5://[user:password@]host:port.
+func Parse(rawurl string) (*Dialer, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		return nil, errors.New("proxy URL has no port")
+	}
+	d := &Dialer{ProxyAddr: u.Host}
+	if u.User != nil {
+		d.Username = u.User.Username()
+		d.Password, _ = u.User.Password()
+	}
+	return d, nil
+}
+
+// ProxyError is a failure to use the proxy itself, as opposed to the
+// proxy failing to reach the target.
+type ProxyError struct {
+	Err error
+}
+
+func (e *ProxyError) Error() string {
+	return "socks5 proxy: " + e.Err.Error()
+}
+
+// Reply codes of a failed CONNECT.
+const (
+	ReplyFailure         = 1
+	ReplyNotAllowed      = 2
+	ReplyNetUnreachable  = 3
+	ReplyHostUnreachable = 4
+	ReplyRefused         = 5
+	ReplyTTLExpired      = 6
+	ReplyCmdUnsupported  = 7
+	ReplyAddrUnsupported = 8
+)
+
+// ReplyError is a failed CONNECT reported by the proxy.
+type ReplyError struct {
+	Code byte
+}
+
+var replyText = map[byte]string{
+	ReplyFailure:         "general SOCKS server failure",
+	ReplyNotAllowed:      "connection not allowed by ruleset",
+	ReplyNetUnreachable:  "network unreachable",
+	ReplyHostUnreachable: "host unreachable",
+	ReplyRefused:         "connection refused",
+	ReplyTTLExpired:      "TTL expired",
+	ReplyCmdUnsupported:  "command not supported",
+	ReplyAddrUnsupported: "address type not supported",
+}
+
+func (e *ReplyError) Error() string {
+	if s, ok := replyText[e.Code]; ok {
+		return "socks5: " + s
+	}
+	return fmt.Sprintf("socks5: reply code %d", e.Code)
+}
+
+// Conn is a connection through the proxy.
+type Conn struct {
+	net.Conn
+	bound net.Addr
+}
+
+// BoundAddr is the address the proxy connected to the target from,
+// as the proxy reports it.
+func (c *Conn) BoundAddr() net.Addr {
+	return c.bound
+}
+
+// DialTimeout connects to addr (host:port) through the proxy. The timeout
+// covers connecting to the proxy and the proxy connecting to addr.
+func (d *Dialer) DialTimeout(addr string, timeout time.Duration) (*Conn, error) {
+	fd, err := net.DialTimeout("tcp", d.ProxyAddr, timeout)
+	if err != nil {
+		return nil, &ProxyError{err}
+	}
+	if timeout > 0 {
+		fd.SetDeadline(time.Now().Add(timeout))
+	}
+	bound, err := d.connect(fd, addr)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	fd.SetDeadline(time.Time{})
+	return &Conn{Conn: fd, bound: bound}, nil
+}
+
+func (d *Dialer) connect(rw io.ReadWriter, addr string) (net.Addr, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port %q", portStr)
+	}
+
+	// Method negotiation.
+	method := byte(authNone)
+	if d.Username != "" {
+		method = authPassword
+	}
+	if _, err := rw.Write([]byte{version5, 1, method}); err != nil {
+		return nil, &ProxyError{err}
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return nil, &ProxyError{err}
+	}
+	switch {
+	case reply[0] != version5:
+		return nil, &ProxyError{fmt.Errorf("version %d", reply[0])}
+	case reply[1] == authNoAccept || reply[1] != method:
+		return nil, &ProxyError{errors.New("no acceptable authentication method")}
+	}
+	if method == authPassword {
+		if err := d.authenticate(rw); err != nil {
+			return nil, err
+		}
+	}
+
+	// CONNECT request.
+	req := []byte{version5, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, errors.New("host name too long")
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, atypIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, atypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := rw.Write(req); err != nil {
+		return nil, &ProxyError{err}
+	}
+
+	var head [4]byte
+	if _, err := io.ReadFull(rw, head[:]); err != nil {
+		return nil, &ProxyError{err}
+	}
+	if head[0] != version5 {
+		return nil, &ProxyError{fmt.Errorf("version %d", head[0])}
+	}
+	if head[1] != 0 {
+		return nil, &ReplyError{head[1]}
+	}
+	return readAddr(rw, head[3])
+}
+
+// authenticate runs the username/password subnegotiation (RFC 1929).
+func (d *Dialer) authenticate(rw io.ReadWriter) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return &ProxyError{errors.New("username or password too long")}
+	}
+	req := []byte{authVersion, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := rw.Write(req); err != nil {
+		return &ProxyError{err}
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return &ProxyError{err}
+	}
+	switch {
+	case reply[0] != authVersion:
+		return &ProxyError{fmt.Errorf("authentication version %d", reply[0])}
+	case reply[1] != 0:
+		return &ProxyError{errors.New("authentication failed")}
+	}
+	return nil
+}
+
+// readAddr reads the bound address of a reply.
+func readAddr(r io.Reader, atyp byte) (net.Addr, error) {
+	var host []byte
+	switch atyp {
+	case atypIPv4:
+		host = make([]byte, net.IPv4len)
+	case atypIPv6:
+		host = make([]byte, net.IPv6len)
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return nil, &ProxyError{err}
+		}
+		host = make([]byte, n[0])
+	default:
+		return nil, &ProxyError{fmt.Errorf("unknown address type %d", atyp)}
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, host); err != nil {
+		return nil, &ProxyError{err}
+	}
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return nil, &ProxyError{err}
+	}
+	p := int(port[0])<<8 | int(port[1])
+	if atyp == atypDomain {
+		return &hostAddr{net.JoinHostPort(string(host), strconv.Itoa(p))}, nil
+	}
+	return &net.TCPAddr{IP: net.IP(host), Port: p}, nil
+}
+
+// hostAddr is a bound address given as a host name.
+type hostAddr struct {
+	addr string
+}
+
+func (a *hostAddr) Network() string { return "tcp" }
+func (a *hostAddr) String() string  { return a.addr }
@@ -0,0 +1,224 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// standIn is a SOCKS5 server accepting CONNECT requests. If reply is
+// nonzero, it fails every request with that code. If authVersion is
+// nonzero, it answers the password subnegotiation with that version.
+type standIn struct {
+	ln          net.Listener
+	user        string
+	password    string
+	reply       byte
+	authVersion byte
+	targets     chan string
+}
+
+func newStandIn(t *testing.T) *standIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &standIn{ln: ln, targets: make(chan string, 10)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *standIn) serve(c net.Conn) {
+	defer c.Close()
+	var head [2]byte
+	if _, err := io.ReadFull(c, head[:]); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+	want := byte(authNone)
+	if s.user != "" {
+		want = authPassword
+	}
+	if bytes.IndexByte(methods, want) < 0 {
+		c.Write([]byte{version5, authNoAccept})
+		return
+	}
+	c.Write([]byte{version5, want})
+	if want == authPassword {
+		var n [1]byte
+		io.ReadFull(c, n[:]) // subnegotiation version
+		io.ReadFull(c, n[:])
+		user := make([]byte, n[0])
+		io.ReadFull(c, user)
+		io.ReadFull(c, n[:])
+		pass := make([]byte, n[0])
+		io.ReadFull(c, pass)
+		version := byte(authVersion)
+		if s.authVersion != 0 {
+			version = s.authVersion
+		}
+		if string(user) != s.user || string(pass) != s.password {
+			c.Write([]byte{version, 1})
+			return
+		}
+		c.Write([]byte{version, 0})
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return
+	}
+	addr, err := readAddr(c, req[3])
+	if err != nil {
+		return
+	}
+	s.targets <- addr.String()
+	if s.reply != 0 {
+		c.Write([]byte{version5, s.reply, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	target, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		c.Write([]byte{version5, ReplyRefused, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	bound := target.LocalAddr().(*net.TCPAddr)
+	c.Write(append([]byte{version5, 0, 0, atypIPv4}, bound.IP.To4()[0], bound.IP.To4()[1], bound.IP.To4()[2], bound.IP.To4()[3], byte(bound.Port>>8), byte(bound.Port)))
+	go io.Copy(target, c)
+	io.Copy(c, target)
+}
+
+// echoServer echoes one connection.
+func echoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	return ln
+}
+
+func TestDial(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+	s := newStandIn(t)
+	defer s.ln.Close()
+
+	d, err := Parse("socks5://" + s.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.DialTimeout(echo.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if target := <-s.targets; target != echo.Addr().String() {
+		t.Errorf("proxy connected to %s, want %s", target, echo.Addr())
+	}
+	if bound, ok := c.BoundAddr().(*net.TCPAddr); !ok || !bound.IP.IsLoopback() || bound.Port == 0 {
+		t.Errorf("bad bound address %v", c.BoundAddr())
+	}
+
+	msg := []byte("hello")
+	c.Write(msg)
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("echo %q, want %q", got, msg)
+	}
+}
+
+func TestDialPassword(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+	s := newStandIn(t)
+	s.user, s.password = "user", "secret"
+	defer s.ln.Close()
+
+	d, _ := Parse("socks5://user:wrong@" + s.ln.Addr().String())
+	if _, err := d.DialTimeout(echo.Addr().String(), time.Second); err == nil {
+		t.Fatal("dial with a wrong password succeeded")
+	} else if _, ok := err.(*ProxyError); !ok {
+		t.Errorf("got %T %v, want *ProxyError", err, err)
+	}
+	d, _ = Parse("socks5://user:secret@" + s.ln.Addr().String())
+	c, err := d.DialTimeout(echo.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// A success reply must still carry the subnegotiation version.
+	bad := newStandIn(t)
+	bad.user, bad.password, bad.authVersion = "user", "secret", version5
+	defer bad.ln.Close()
+	d, _ = Parse("socks5://user:secret@" + bad.ln.Addr().String())
+	if _, err := d.DialTimeout(echo.Addr().String(), time.Second); err == nil {
+		t.Fatal("dial with a bad authentication reply version succeeded")
+	} else if _, ok := err.(*ProxyError); !ok {
+		t.Errorf("got %T %v, want *ProxyError", err, err)
+	}
+}
+
+func TestDialReplyError(t *testing.T) {
+	s := newStandIn(t)
+	s.reply = ReplyHostUnreachable
+	defer s.ln.Close()
+
+	d := &Dialer{ProxyAddr: s.ln.Addr().String()}
+	for _, addr := range []string{"[2001:db8::1]:30303", "192.0.2.1:30303", "node.example.org:30303"} {
+		_, err := d.DialTimeout(addr, time.Second)
+		if rerr, ok := err.(*ReplyError); !ok || rerr.Code != ReplyHostUnreachable {
+			t.Errorf("%s: got %v, want host unreachable", addr, err)
+		}
+		if target := <-s.targets; target != addr {
+			t.Errorf("proxy was asked for %s, want %s", target, addr)
+		}
+	}
+}
+
+func TestDialNoProxy(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	d := &Dialer{ProxyAddr: "127.0.0.1:" + strconv.Itoa(port)}
+	if _, err := d.DialTimeout("192.0.2.1:30303", time.Second); err == nil {
+		t.Fatal("dial through a closed proxy succeeded")
+	} else if _, ok := err.(*ProxyError); !ok {
+		t.Errorf("got %T %v, want *ProxyError", err, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, bad := range []string{"http://127.0.0.1:8080", "socks5://127.0.0.1", "127.0.0.1:1080"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Package tableaudit assesses how easily a node could be eclipsed, from its
+// discovery table as reconstructed by findnode requests targeting each of its
+// buckets: how its entries are spread over subnets, autonomous systems,
+// addresses and node IDs, and how many of them are dead.
+package tableaudit
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// The table layout and limits of discovery v4 (see package discover).
+const (
+	NumBuckets    = 17
+	MinDistance   = 256 - NumBuckets // the closest bucket holds the entries up to one above this log distance
+	BucketSize    = 16
+	Subnet        = 24 // prefix length the IP limits apply to
+	BucketIPLimit = 2  // entries per subnet in a bucket
+	TableIPLimit  = 10 // entries per subnet in the table
+)
+
+// MaxNetworkSize bounds the network size estimated from a table, far above
+// any public discovery network. It's assumed when every bucket is full.
+const MaxNetworkSize = 1 << 17
+
+// BucketOf returns the bucket of id in the table of owner.
+func BucketOf(owner, id enode.ID) int {
+	d := enode.LogDist(owner, id)
+	if d <= MinDistance {
+		return 0
+	}
+	return d - MinDistance - 1
+}
+
+// TargetKey grinds a public key whose node ID falls in the given bucket of
+// the table of owner, so that a findnode request targeting it is answered
+// with the entries of that bucket first. Only the public key is needed, so
+// candidates are found by adding the generator to a random point rather than
+// generating keys. The closest bucket takes about 131000 tries.
+func TargetKey(owner enode.ID, bucket int) (*ecdsa.PublicKey, error) {
+	var (
+		workers = runtime.NumCPU()
+		found   = make(chan *ecdsa.PublicKey, 1)
+		errc    = make(chan error, workers)
+		done    = make(chan struct{})
+	)
+	defer close(done)
+	curve := crypto.S256()
+	gx, gy := curve.Params().Gx, curve.Params().Gy
+	for i := 0; i < workers; i++ {
+		go func() {
+			start, err := crypto.GenerateKey()
+			if err != nil {
+				errc <- err
+				return
+			}
+			x, y := start.X, start.Y
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+				if BucketOf(owner, enode.PubkeyToIDV4(pub)) == bucket {
+					select {
+					case found <- pub:
+					default:
+					}
+					return
+				}
+				x, y = curve.Add(x, y, gx, gy)
+			}
+		}()
+	}
+	// Wait for a key, or for every worker to fail.
+	var err error
+	for failed := 0; failed < workers; failed++ {
+		select {
+		case pub := <-found:
+			return pub, nil
+		case err = <-errc:
+		}
+	}
+	return nil, err
+}
+
+// Entry is an entry of the reconstructed table.
+type Entry struct {
+	ID     enode.ID
+	IP     net.IP
+	Bucket int
+	Pinged bool   // whether it was pinged
+	Dead   bool   // pinged and didn't answer
+	AS     string // autonomous system, empty if unknown
+}
+
+// Cluster is a group of entries that share a subnet, autonomous system or
+// address, or crowd a close bucket, and stand out.
+type Cluster struct {
+	Kind    string // subnet, asn, ip or id
+	Key     string
+	Entries int
+	Buckets int     // buckets the entries are in
+	Share   float64 // of the table's entries
+	Reason  string
+}
+
+func (c *Cluster) String() string {
+	return fmt.Sprintf("%s %s entries %d (%.1f%%) buckets %d: %s", c.Kind, c.Key, c.Entries, 100*c.Share, c.Buckets, c.Reason)
+}
+
+// Report is the assessment of a table.
+type Report struct {
+	Entries int
+	Buckets [NumBuckets]int // entries per bucket
+	Subnets int             // distinct /24s
+	ASNs    int             // distinct autonomous systems, of the entries with a known one
+	Pinged  int
+	Dead    int
+
+	// NetworkSize is the number of nodes the farthest bucket that isn't
+	// full points to, if node IDs are spread at random.
+	NetworkSize int
+
+	// Risk components, between 0 (diverse, live) and 1.
+	SubnetShare   float64 // share of entries sharing their subnet with another entry
+	ASNHHI        float64 // Herfindahl-Hirschman index of the autonomous systems, -1 if unknown
+	SharedIPShare float64 // share of entries sharing their address with another entry
+	DeadShare     float64 // share of the pinged entries that are dead
+	CloseIDShare  float64 // share of entries in buckets beyond what NetworkSize explains
+
+	// Score is the weighted risk components from 0 to 100, raised by the
+	// close ID share, which is a risk however diverse the addresses are.
+	// It's -1 for a table without entries.
+	Score    int
+	Clusters []*Cluster
+}
+
+// Component weights of the risk score.
+const (
+	subnetWeight   = 0.3
+	asnWeight      = 0.3
+	sharedIPWeight = 0.2
+	deadWeight     = 0.2
+)
+
+// Level names the risk of a score, unknown for a table without entries.
+func Level(score int) string {
+	switch {
+	case score < 0:
+		return "unknown"
+	case score < 15:
+		return "low"
+	case score < 35:
+		return "medium"
+	}
+	return "high"
+}
+
+// subnetOf returns the /24 of ip, with the prefix length geth applies to
+// both address families.
+func subnetOf(ip net.IP) string {
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	n := net.IPNet{IP: ip.Mask(net.CIDRMask(Subnet, bits)), Mask: net.CIDRMask(Subnet, bits)}
+	return n.String()
+}
+
+// group collects the entries by a key, skipping those with an empty one.
+type group struct {
+	entries int
+	buckets map[int]int
+}
+
+func groupBy(entries []Entry, key func(*Entry) string) map[string]*group {
+	groups := make(map[string]*group)
+	for i := range entries {
+		k := key(&entries[i])
+		if k == "" {
+			continue
+		}
+		g := groups[k]
+		if g == nil {
+			g = &group{buckets: make(map[int]int)}
+			groups[k] = g
+		}
+		g.entries++
+		g.buckets[entries[i].Bucket]++
+	}
+	return groups
+}
+
+// Analyze assesses the entries of a table. Autonomous systems holding more
+// than asnShare of the entries are reported as clusters.
+func Analyze(entries []Entry, asnShare float64) *Report {
+	r := &Report{Entries: len(entries), ASNHHI: -1, Score: -1}
+	if len(entries) == 0 {
+		return r
+	}
+	total := float64(len(entries))
+	for _, e := range entries {
+		r.Buckets[e.Bucket]++
+		if e.Pinged {
+			r.Pinged++
+			if e.Dead {
+				r.Dead++
+			}
+		}
+	}
+	if r.Pinged > 0 {
+		r.DeadShare = float64(r.Dead) / float64(r.Pinged)
+	}
+
+	subnets := groupBy(entries, func(e *Entry) string { return subnetOf(e.IP) })
+	r.Subnets = len(subnets)
+	var clustered int
+	for key, g := range subnets {
+		if g.entries > 1 {
+			clustered += g.entries
+		}
+		var reason string
+		for b := 0; b < NumBuckets; b++ {
+			if n := g.buckets[b]; n > BucketIPLimit {
+				reason = fmt.Sprintf("%d entries in bucket %d exceed the bucket limit of %d per /%d", n, b, BucketIPLimit, Subnet)
+				break
+			}
+		}
+		if g.entries > TableIPLimit {
+			reason = fmt.Sprintf("exceeds the table limit of %d per /%d", TableIPLimit, Subnet)
+		}
+		if reason == "" && g.entries > BucketIPLimit {
+			reason = "one operator may control several entries"
+		}
+		if reason != "" {
+			r.Clusters = append(r.Clusters, &Cluster{Kind: "subnet", Key: key, Entries: g.entries, Buckets: len(g.buckets), Share: float64(g.entries) / total, Reason: reason})
+		}
+	}
+	r.SubnetShare = float64(clustered) / total
+
+	ips := groupBy(entries, func(e *Entry) string { return e.IP.String() })
+	var shared int
+	for key, g := range ips {
+		if g.entries > 1 {
+			shared += g.entries
+			r.Clusters = append(r.Clusters, &Cluster{Kind: "ip", Key: key, Entries: g.entries, Buckets: len(g.buckets), Share: float64(g.entries) / total, Reason: fmt.Sprintf("%d node IDs on one address", g.entries)})
+		}
+	}
+	r.SharedIPShare = float64(shared) / total
+
+	asns := groupBy(entries, func(e *Entry) string { return e.AS })
+	r.ASNs = len(asns)
+	var known int
+	for _, g := range asns {
+		known += g.entries
+	}
+	if known > 0 {
+		r.ASNHHI = 0
+		for key, g := range asns {
+			share := float64(g.entries) / float64(known)
+			r.ASNHHI += share * share
+			if share > asnShare {
+				r.Clusters = append(r.Clusters, &Cluster{Kind: "asn", Key: key, Entries: g.entries, Buckets: len(g.buckets), Share: float64(g.entries) / total, Reason: fmt.Sprintf("holds %.1f%% of the located entries", 100*share)})
+			}
+		}
+	}
+
+	r.NetworkSize = networkSize(r.Buckets)
+	var crowded int
+	for b, n := range r.Buckets {
+		want := expectedEntries(r.NetworkSize, b)
+		if excess := n - int(2*want+2); excess > 0 {
+			crowded += excess
+			reason := fmt.Sprintf("%d entries where about %.1f are expected in a network of %d: node IDs may be ground to get close", n, want, r.NetworkSize)
+			r.Clusters = append(r.Clusters, &Cluster{Kind: "id", Key: fmt.Sprintf("bucket-%d", b), Entries: n, Buckets: 1, Share: float64(n) / total, Reason: reason})
+		}
+	}
+	r.CloseIDShare = float64(crowded) / total
+
+	score, weights := subnetWeight*r.SubnetShare+sharedIPWeight*r.SharedIPShare, subnetWeight+sharedIPWeight
+	if r.ASNHHI >= 0 {
+		score += asnWeight * r.ASNHHI
+		weights += asnWeight
+	}
+	if r.Pinged > 0 {
+		score += deadWeight * r.DeadShare
+		weights += deadWeight
+	}
+	r.Score = int(100*math.Min(score/weights+r.CloseIDShare, 1) + 0.5)
+
+	sort.Slice(r.Clusters, func(i, j int) bool {
+		a, b := r.Clusters[i], r.Clusters[j]
+		if a.Entries != b.Entries {
+			return a.Entries > b.Entries
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Key < b.Key
+	})
+	return r
+}
+
+// bucketShare is the share of random node IDs falling in bucket b.
+func bucketShare(b int) float64 {
+	if b == 0 {
+		return math.Ldexp(1, MinDistance-256+1)
+	}
+	return math.Ldexp(1, MinDistance+1+b-257)
+}
+
+// expectedEntries is the number of nodes of a network of the given size
+// falling in bucket b, at most a full bucket.
+func expectedEntries(size, b int) float64 {
+	return math.Min(float64(size)*bucketShare(b), BucketSize)
+}
+
+// networkSize estimates the size of the network from the farthest bucket
+// that isn't full: each bucket closer holds half the IDs of the one before,
+// so the buckets up to it say the most about the whole network and the least
+// about IDs ground to get close.
+func networkSize(buckets [NumBuckets]int) int {
+	for b := NumBuckets - 1; b >= 0; b-- {
+		if buckets[b] < BucketSize {
+			return int(math.Min((float64(buckets[b])+0.5)/bucketShare(b), MaxNetworkSize))
+		}
+	}
+	return MaxNetworkSize
+}
@@ -0,0 +1,146 @@
+package tableaudit
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestBucketOf(t *testing.T) {
+	var owner enode.ID
+	owner[0] = 0x80
+	tests := []struct {
+		dist, bucket int
+	}{
+		{256, 16}, {255, 15}, {241, 1}, {240, 0}, {239, 0}, {1, 0},
+	}
+	for _, test := range tests {
+		id := enode.RandomID(owner, test.dist)
+		if b := BucketOf(owner, id); b != test.bucket {
+			t.Errorf("distance %d: got bucket %d, want %d", test.dist, b, test.bucket)
+		}
+	}
+}
+
+func TestTargetKey(t *testing.T) {
+	owner := enode.HexID("66498ac935f3f54d873de4719bf2d6d61e0c74dd173b547531325bcef331480f")
+	for _, b := range []int{16, 15, 12} {
+		key, err := TargetKey(owner, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			t.Errorf("target key for bucket %d is not on the curve", b)
+		}
+		if got := BucketOf(owner, enode.PubkeyToIDV4(key)); got != b {
+			t.Errorf("target key for bucket %d is in bucket %d", b, got)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var owner enode.ID
+	var entries []Entry
+	add := func(ip string, bucket int, as string, dead bool) {
+		entries = append(entries, Entry{
+			ID:     enode.RandomID(owner, MinDistance+1+bucket),
+			IP:     net.ParseIP(ip),
+			Bucket: bucket,
+			Pinged: true,
+			Dead:   dead,
+			AS:     as,
+		})
+	}
+	// 12 entries in one /24, 3 of them in bucket 16 and 2 on one address.
+	for i := 0; i < 12; i++ {
+		add(fmt.Sprintf("1.2.3.%d", 10+i), 16-i%4, "AS1 Sybil", false)
+	}
+	add("1.2.3.10", 15, "AS1 Sybil", false)
+	// 7 diverse entries, 2 dead.
+	for i := 0; i < 7; i++ {
+		add(fmt.Sprintf("%d.0.0.1", 20+i), 10+i, fmt.Sprintf("AS%d Other", 20+i), i < 2)
+	}
+
+	r := Analyze(entries, 0.25)
+	if r.Entries != 20 || r.Subnets != 8 || r.ASNs != 8 || r.Dead != 2 {
+		t.Fatalf("got entries %d subnets %d asns %d dead %d", r.Entries, r.Subnets, r.ASNs, r.Dead)
+	}
+	if r.SubnetShare != 13.0/20 || r.SharedIPShare != 2.0/20 || r.DeadShare != 2.0/20 {
+		t.Errorf("got subnet share %v shared ip share %v dead share %v", r.SubnetShare, r.SharedIPShare, r.DeadShare)
+	}
+	if len(r.Clusters) != 4 {
+		t.Fatalf("got clusters %v", r.Clusters)
+	}
+	if c := r.Clusters[0]; c.Kind != "asn" || c.Key != "AS1 Sybil" || c.Entries != 13 {
+		t.Errorf("first cluster %v", c)
+	}
+	if c := r.Clusters[1]; c.Kind != "subnet" || c.Key != "1.2.3.0/24" || c.Entries != 13 || c.Buckets != 4 {
+		t.Errorf("second cluster %v", c)
+	}
+	// Bucket 16 holding 4 entries points to a network of 9 nodes, too few
+	// for 4 of them in bucket 13.
+	if c := r.Clusters[2]; c.Kind != "id" || c.Key != "bucket-13" || c.Entries != 4 {
+		t.Errorf("third cluster %v", c)
+	}
+	if c := r.Clusters[3]; c.Kind != "ip" || c.Key != "1.2.3.10" || c.Entries != 2 {
+		t.Errorf("fourth cluster %v", c)
+	}
+	if Level(r.Score) != "high" {
+		t.Errorf("score %d is %s", r.Score, Level(r.Score))
+	}
+
+	if r := Analyze(entries[13:], 0.25); len(r.Clusters) != 0 || Level(r.Score) != "low" {
+		t.Errorf("diverse table: clusters %v score %d", r.Clusters, r.Score)
+	}
+}
+
+func TestAnalyzeCloseIDs(t *testing.T) {
+	var owner enode.ID
+	var entries []Entry
+	add := func(bucket, n int) {
+		for i := 0; i < n; i++ {
+			entries = append(entries, Entry{
+				ID:     enode.RandomID(owner, MinDistance+1+bucket),
+				IP:     net.IPv4(byte(10+len(entries)), 0, 0, 1),
+				Bucket: bucket,
+			})
+		}
+	}
+	// A network of about 1000 nodes: full far buckets, then halving.
+	add(16, 16)
+	add(15, 16)
+	add(14, 16)
+	add(13, 16)
+	add(12, 16)
+	add(11, 8)
+	add(10, 4)
+	add(9, 2)
+	add(8, 1)
+
+	r := Analyze(entries, 0.25)
+	if r.NetworkSize < 500 || r.NetworkSize > 2000 {
+		t.Errorf("got network size %d, want about 1000", r.NetworkSize)
+	}
+	if r.CloseIDShare != 0 || len(r.Clusters) != 0 {
+		t.Errorf("random IDs: close id share %v clusters %v", r.CloseIDShare, r.Clusters)
+	}
+
+	// Two close buckets filled up.
+	add(3, 16)
+	add(2, 16)
+	r = Analyze(entries, 0.25)
+	if len(r.Clusters) != 2 || r.Clusters[0].Kind != "id" || r.Clusters[1].Kind != "id" {
+		t.Fatalf("got clusters %v", r.Clusters)
+	}
+	if want := 2 * 14.0 / float64(len(entries)); r.CloseIDShare != want {
+		t.Errorf("got close id share %v, want %v", r.CloseIDShare, want)
+	}
+}
+
+func TestAnalyzeEmpty(t *testing.T) {
+	if r := Analyze(nil, 0.25); r.Score != -1 || Level(r.Score) != "unknown" {
+		t.Errorf("empty table: score %d %s", r.Score, Level(r.Score))
+	}
+}